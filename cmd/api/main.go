@@ -1,10 +1,12 @@
 package main
 
 import (
+        "fmt"
         "log"
         "os"
         "os/signal"
         "syscall"
+        "time"
 
         "github.com/gin-gonic/gin"
         "github.com/yourusername/video-compressor/internal/compressor"
@@ -13,6 +15,8 @@ import (
         "github.com/yourusername/video-compressor/internal/middleware"
         "github.com/yourusername/video-compressor/internal/queue"
         "github.com/yourusername/video-compressor/internal/storage"
+        "github.com/yourusername/video-compressor/internal/tenants"
+        "github.com/yourusername/video-compressor/internal/uploads"
         "github.com/yourusername/video-compressor/internal/worker"
         "github.com/yourusername/video-compressor/pkg/config"
 )
@@ -41,11 +45,59 @@ func main() {
         defer redisQueue.Close()
         log.Println("Connected to Redis queue")
 
-        videoComp := compressor.NewVideoCompressor(cfg.FFmpegPath, cfg.TempDir)
-        imageComp := compressor.NewImageCompressor(cfg.ImageMagickPath, cfg.TempDir)
-        wpStorage := storage.NewWordPressStorage(cfg.WordPressAPIURL, cfg.WordPressUsername, cfg.WordPressAppPassword)
+        videoComp := compressor.NewVideoCompressor(cfg.FFmpegPath, cfg.FFprobePath, compressor.VideoLimits{
+                MaxDuration:  float64(cfg.MaxVideoDuration),
+                MaxDimension: cfg.MaxVideoDimension,
+                MaxStreams:   cfg.MaxVideoStreams,
+                MaxFrames:    cfg.MaxAnimationFrames,
+        })
+        imageBackend, err := compressor.NewImageBackend(cfg.ImageBackend, cfg.ImageMagickPath, cfg.IdentifyPath, cfg.SRGBProfilePath)
+        if err != nil {
+                log.Fatal("Invalid image backend:", err)
+        }
+        log.Printf("Using %s image backend", imageBackend.Name())
+
+        imageComp := compressor.NewImageCompressor(imageBackend, compressor.ImageLimits{
+                MaxPixels:    cfg.MaxImagePixels,
+                MaxDimension: cfg.MaxImageDimension,
+        })
+        storageRegistry, err := newStorageRegistry(cfg)
+        if err != nil {
+                log.Fatal("Invalid storage configuration:", err)
+        }
+        log.Printf("Storage backends: %v (default %s)", storageRegistry.Names(), storageRegistry.Default())
 
-        w := worker.NewWorker(cfg, db, redisQueue, videoComp, imageComp, wpStorage)
+        sourcePolicy, err := storage.NewSourcePolicy(cfg.SourceAllowedSchemes, cfg.SourceAllowedHosts, cfg.SourceAllowedNetworks)
+        if err != nil {
+                log.Fatal("Invalid source policy:", err)
+        }
+
+        urlRewrites, err := storage.ParseURLRewrites(cfg.URLRewrites)
+        if err != nil {
+                log.Fatal("Invalid URL rewrites:", err)
+        }
+        for backend := range urlRewrites {
+                if !storageRegistry.Has(backend) {
+                        log.Fatal("Invalid URL rewrites:", fmt.Errorf("storage backend %q is not configured", backend))
+                }
+        }
+
+        tenantStore, err := tenants.NewStore(db, storageRegistry, sourcePolicy, urlRewrites, cfg.TenantEncryptionKey)
+        if err != nil {
+                log.Fatal("Invalid tenant configuration:", err)
+        }
+
+        maxUploadSize := cfg.MaxVideoFileSize
+        if cfg.MaxImageFileSize > maxUploadSize {
+                maxUploadSize = cfg.MaxImageFileSize
+        }
+        uploadStore, err := uploads.NewStore(db, cfg.UploadDir, time.Duration(cfg.UploadTTL)*time.Second, maxUploadSize)
+        if err != nil {
+                log.Fatal("Failed to initialize upload store:", err)
+        }
+        go uploadStore.Start()
+
+        w := worker.NewWorker(cfg, db, redisQueue, videoComp, imageComp, tenantStore, uploadStore)
         go w.Start()
         log.Println("Worker started")
 
@@ -59,22 +111,63 @@ func main() {
 
         router.Use(middleware.CORS(cfg.AllowedDomains))
 
+        uploadHandler := handlers.NewUploadHandler(uploadStore)
+
+        var fileSigner *storage.URLSigner
+        if cfg.FileSigningKey != "" {
+                fileSigner = storage.NewURLSigner(cfg.FileSigningKey, time.Duration(cfg.FileURLTTL)*time.Second)
+                fileHandler := handlers.NewFileHandler(tenantStore, fileSigner)
+                router.GET(storage.FilesPath+"/:backend/:expires/:signature/*key", fileHandler.ServeFile)
+                router.HEAD(storage.FilesPath+"/:backend/:expires/:signature/*key", fileHandler.ServeFile)
+        }
+
         api := router.Group("/api")
         {
-                api.Use(middleware.APIKeyAuth(cfg.APIKey))
+                api.Use(middleware.APIKeyAuth(cfg.APIKey, tenantStore))
                 api.Use(middleware.DomainWhitelist(cfg.AllowedDomains))
                 api.Use(middleware.NewRateLimiter(cfg.RateLimitPerMinute).Middleware())
 
-                compressHandler := handlers.NewCompressHandler(db, redisQueue, cfg)
+                compressHandler := handlers.NewCompressHandler(db, redisQueue, tenantStore, uploadStore, fileSigner, w, cfg)
 
                 api.POST("/compress", compressHandler.Compress)
                 api.GET("/status/:job_id", compressHandler.GetStatus)
                 api.GET("/result/:job_id", compressHandler.GetResult)
                 api.GET("/queue/stats", compressHandler.GetQueueStats)
                 api.POST("/queue/cancel/:job_id", compressHandler.CancelJob)
+                api.POST("/revert/:job_id", compressHandler.RevertJob)
+
+                duplicateHandler := handlers.NewDuplicateHandler(db)
+                api.GET("/duplicates/:job_id", duplicateHandler.GetDuplicates)
+
+                api.POST("/uploads", uploadHandler.Upload)
+                api.GET("/uploads/:upload_id", uploadHandler.GetUpload)
+                api.DELETE("/uploads/:upload_id", uploadHandler.DeleteUpload)
+                api.POST("/tus", uploadHandler.TusCreate)
+
+                tenantHandler := handlers.NewTenantHandler(tenantStore)
+                admin := api.Group("/tenants", middleware.AdminOnly())
+                admin.POST("", tenantHandler.CreateTenant)
+                admin.GET("", tenantHandler.ListTenants)
+                admin.GET("/:tenant_id", tenantHandler.GetTenant)
+                admin.PUT("/:tenant_id", tenantHandler.UpdateTenant)
+                admin.DELETE("/:tenant_id", tenantHandler.DeleteTenant)
+                admin.POST("/:tenant_id/rotate-key", tenantHandler.RotateKey)
         }
 
-        healthHandler := handlers.NewHealthHandler(db, redisQueue)
+        // Chunks of a tus upload skip the rate limiter, one upload can take
+        // hundreds of them.
+        tus := router.Group("/api/tus")
+        {
+                tus.Use(middleware.APIKeyAuth(cfg.APIKey, tenantStore))
+                tus.Use(middleware.DomainWhitelist(cfg.AllowedDomains))
+
+                tus.OPTIONS("", uploadHandler.TusOptions)
+                tus.HEAD("/:upload_id", uploadHandler.TusHead)
+                tus.PATCH("/:upload_id", uploadHandler.TusPatch)
+                tus.DELETE("/:upload_id", uploadHandler.TusDelete)
+        }
+
+        healthHandler := handlers.NewHealthHandler(db, redisQueue, w)
         router.GET("/health", healthHandler.Health)
         router.GET("/ready", healthHandler.Ready)
         
@@ -91,6 +184,11 @@ func main() {
                                 "result":       "GET /api/result/:job_id (requires API key)",
                                 "queue_stats":  "GET /api/queue/stats (requires API key)",
                                 "cancel":       "POST /api/queue/cancel/:job_id (requires API key)",
+                                "revert":       "POST /api/revert/:job_id (requires API key)",
+                                "duplicates":   "GET /api/duplicates/:job_id (requires API key)",
+                                "upload":       "POST /api/uploads (multipart, requires API key)",
+                                "tus":          "POST /api/tus (tus 1.0.0 resumable upload, requires API key)",
+                                "tenants":      "POST /api/tenants (requires the deployment API key)",
                         },
                 })
         })
@@ -109,5 +207,79 @@ func main() {
 
         log.Println("Shutting down server...")
         w.Stop()
+        uploadStore.Stop()
         log.Println("Server stopped")
 }
+
+func newStorageRegistry(cfg *config.Config) (*storage.Registry, error) {
+        registry := storage.NewRegistry(cfg.StorageBackend)
+
+        if cfg.WordPressAPIURL != "" {
+                registry.Register(storage.NewWordPressStorage(cfg.WordPressAPIURL, cfg.WordPressUsername, cfg.WordPressAppPassword))
+        }
+
+        if cfg.LocalStoragePath != "" {
+                local, err := storage.NewLocalStorage(cfg.LocalStoragePath, cfg.LocalStorageBaseURL)
+                if err != nil {
+                        return nil, err
+                }
+                registry.Register(local)
+        }
+
+        if cfg.S3Bucket != "" {
+                s3, err := storage.NewS3Storage(storage.S3Config{
+                        Endpoint:  cfg.S3Endpoint,
+                        Region:    cfg.S3Region,
+                        Bucket:    cfg.S3Bucket,
+                        AccessKey: cfg.S3AccessKey,
+                        SecretKey: cfg.S3SecretKey,
+                        PublicURL: cfg.S3PublicURL,
+                        PathStyle: cfg.S3PathStyle,
+                })
+                if err != nil {
+                        return nil, err
+                }
+                registry.Register(s3)
+        }
+
+        if cfg.SFTPAddr != "" {
+                var privateKey []byte
+                if cfg.SFTPPrivateKeyFile != "" {
+                        var err error
+                        if privateKey, err = os.ReadFile(cfg.SFTPPrivateKeyFile); err != nil {
+                                return nil, fmt.Errorf("failed to read sftp private key: %w", err)
+                        }
+                }
+                sftp, err := storage.NewSFTPStorage(storage.SFTPConfig{
+                        Addr:       cfg.SFTPAddr,
+                        Username:   cfg.SFTPUsername,
+                        Password:   cfg.SFTPPassword,
+                        PrivateKey: string(privateKey),
+                        HostKey:    cfg.SFTPHostKey,
+                        Root:       cfg.SFTPRoot,
+                        PublicURL:  cfg.SFTPPublicURL,
+                })
+                if err != nil {
+                        return nil, err
+                }
+                registry.Register(sftp)
+        }
+
+        if cfg.WebDAVURL != "" {
+                webdav, err := storage.NewWebDAVStorage(storage.WebDAVConfig{
+                        URL:       cfg.WebDAVURL,
+                        Username:  cfg.WebDAVUsername,
+                        Password:  cfg.WebDAVPassword,
+                        PublicURL: cfg.WebDAVPublicURL,
+                })
+                if err != nil {
+                        return nil, err
+                }
+                registry.Register(webdav)
+        }
+
+        if !registry.Has(cfg.StorageBackend) {
+                return nil, fmt.Errorf("default storage backend %q is not configured", cfg.StorageBackend)
+        }
+        return registry, nil
+}
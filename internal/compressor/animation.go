@@ -0,0 +1,278 @@
+package compressor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Animation describes the frames of an animated image.
+type Animation struct {
+	Format SourceFormat
+	// Delays holds how long each frame is shown, in order.
+	Delays []time.Duration
+}
+
+func (a *Animation) Frames() int {
+	return len(a.Delays)
+}
+
+func (a *Animation) Duration() time.Duration {
+	var total time.Duration
+	for _, delay := range a.Delays {
+		total += delay
+	}
+	return total
+}
+
+// InspectAnimation reads the frame timing of an animated image from its
+// container: GIF image descriptors and graphic control extensions, WebP ANMF
+// chunks and APNG fcTL chunks. It returns nil for still images.
+func InspectAnimation(path string) (*Animation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	// Files shorter than the header are still images or not images at all.
+	header, peekErr := r.Peek(16)
+	if peekErr != nil && peekErr != io.EOF {
+		return nil, peekErr
+	}
+
+	var animation *Animation
+	switch {
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		animation, err = gifAnimation(r)
+	case len(header) >= 16 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		animation, err = webpAnimation(r)
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		animation, err = pngAnimation(r)
+	}
+	if err != nil || animation == nil || animation.Frames() < 2 {
+		return nil, err
+	}
+	for i, delay := range animation.Delays {
+		animation.Delays[i] = frameDelay(delay)
+	}
+	return animation, nil
+}
+
+// ExtractFrames decodes the frames of an animation with the image backend
+// and writes an ffconcat list that shows each for its delay, for input
+// ffmpeg cannot decode itself, such as animated WebP.
+func (i *ImageCompressor) ExtractFrames(inputPath, outputDir string, animation *Animation) (string, error) {
+	extractor, ok := i.backend.(FrameExtractor)
+	if !ok {
+		return "", fmt.Errorf("the %s backend cannot decode animated %s images", i.backend.Name(), animation.Format)
+	}
+
+	frames, err := extractor.ExtractFrames(inputPath, outputDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract frames: %w", err)
+	}
+	if len(frames) != animation.Frames() {
+		return "", fmt.Errorf("extracted %d frames, the animation has %d", len(frames), animation.Frames())
+	}
+
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	for n, frame := range frames {
+		fmt.Fprintf(&list, "file '%s'\nduration %.3f\n", filepath.Base(frame), animation.Delays[n].Seconds())
+	}
+	// The concat demuxer only honours the duration of the last frame when
+	// another entry follows it.
+	fmt.Fprintf(&list, "file '%s'\n", filepath.Base(frames[len(frames)-1]))
+
+	listPath := filepath.Join(outputDir, "frames.ffconcat")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write frame list: %w", err)
+	}
+	return listPath, nil
+}
+
+// frameDelay follows browsers, which show frames with a delay of 10ms or
+// less for 100ms.
+func frameDelay(delay time.Duration) time.Duration {
+	if delay <= 10*time.Millisecond {
+		return 100 * time.Millisecond
+	}
+	return delay
+}
+
+func gifAnimation(r *bufio.Reader) (*Animation, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << ((hdr[10] & 0x07) + 1)); err != nil {
+			return nil, err
+		}
+	}
+
+	animation := &Animation{Format: SourceGIF}
+	var delay time.Duration
+	for {
+		block, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch block {
+		case 0x21: // extension
+			label, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if label == 0xF9 { // graphic control extension
+				var gce [5]byte
+				if _, err := io.ReadFull(r, gce[:]); err != nil {
+					return nil, err
+				}
+				delay = time.Duration(binary.LittleEndian.Uint16(gce[2:4])) * 10 * time.Millisecond
+				if err := skipGIFSubBlocks(r); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return nil, err
+			}
+		case 0x2C: // image descriptor
+			animation.Delays = append(animation.Delays, delay)
+			delay = 0
+			var desc [9]byte
+			if _, err := io.ReadFull(r, desc[:]); err != nil {
+				return nil, err
+			}
+			if desc[8]&0x80 != 0 {
+				if _, err := r.Discard(3 << ((desc[8] & 0x07) + 1)); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := r.ReadByte(); err != nil { // LZW minimum code size
+				return nil, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return nil, err
+			}
+		case 0x3B: // trailer
+			return animation, nil
+		default:
+			return nil, fmt.Errorf("invalid GIF block 0x%02x", block)
+		}
+	}
+}
+
+func skipGIFSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+func webpAnimation(r *bufio.Reader) (*Animation, error) {
+	if _, err := r.Discard(12); err != nil {
+		return nil, nil
+	}
+
+	animation := &Animation{Format: SourceWebP}
+	var chunk [8]byte
+	for {
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return animation, nil
+		}
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		size += size & 1
+
+		switch string(chunk[0:4]) {
+		case "VP8X":
+			flags, err := r.Peek(1)
+			if err != nil {
+				return nil, nil
+			}
+			if flags[0]&0x02 == 0 {
+				return nil, nil
+			}
+		case "ANMF":
+			// X, Y, width and height take 3 bytes each, then the 24-bit
+			// duration in milliseconds.
+			frame, err := r.Peek(15)
+			if err != nil {
+				return animation, nil
+			}
+			ms := uint32(frame[12]) | uint32(frame[13])<<8 | uint32(frame[14])<<16
+			animation.Delays = append(animation.Delays, time.Duration(ms)*time.Millisecond)
+		case "VP8 ", "VP8L":
+			// A still image has no VP8X chunk with the animation flag.
+			if len(animation.Delays) == 0 {
+				return nil, nil
+			}
+		}
+		if _, err := r.Discard(size); err != nil {
+			return animation, nil
+		}
+	}
+}
+
+func pngAnimation(r *bufio.Reader) (*Animation, error) {
+	if _, err := r.Discard(8); err != nil {
+		return nil, err
+	}
+
+	var animation *Animation
+	var chunk [8]byte
+	for {
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return animation, nil
+		}
+		length := int(binary.BigEndian.Uint32(chunk[0:4]))
+		switch string(chunk[4:8]) {
+		case "acTL":
+			animation = &Animation{Format: SourcePNG}
+		case "fcTL":
+			if animation == nil {
+				break
+			}
+			// Sequence number, width, height and offsets take 4 bytes each,
+			// then the delay as a 16-bit fraction of a second.
+			frame, err := r.Peek(24)
+			if err != nil {
+				return animation, nil
+			}
+			num := binary.BigEndian.Uint16(frame[20:22])
+			den := binary.BigEndian.Uint16(frame[22:24])
+			if den == 0 {
+				den = 100
+			}
+			animation.Delays = append(animation.Delays, time.Duration(num)*time.Second/time.Duration(den))
+		case "IDAT":
+			// acTL must come before the image data.
+			if animation == nil {
+				return nil, nil
+			}
+		case "IEND":
+			return animation, nil
+		}
+		if _, err := r.Discard(length + 4); err != nil {
+			return animation, nil
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func gifFixture(t *testing.T, delays ...int) []byte {
+	t.Helper()
+	animation := &gif.GIF{}
+	for n, delay := range delays {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+		frame.SetColorIndex(n%4, n%4, uint8(n+1))
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, delay)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	// The CRC is not checked.
+	return append(chunk, 0, 0, 0, 0)
+}
+
+func fcTL(sequence uint32, num, den uint16) []byte {
+	data := binary.BigEndian.AppendUint32(nil, sequence)
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint16(data, num)
+	data = binary.BigEndian.AppendUint16(data, den)
+	return pngChunk("fcTL", append(data, 0, 0))
+}
+
+func apngFixture(beforeIDAT bool, delays ...[2]uint16) []byte {
+	out := []byte("\x89PNG\r\n\x1a\n")
+	out = append(out, pngChunk("IHDR", []byte{0, 0, 0, 4, 0, 0, 0, 4, 8, 6, 0, 0, 0})...)
+	acTL := pngChunk("acTL", []byte{0, 0, 0, byte(len(delays)), 0, 0, 0, 0})
+	if beforeIDAT {
+		out = append(out, acTL...)
+	}
+	for n, delay := range delays {
+		out = append(out, fcTL(uint32(n), delay[0], delay[1])...)
+		if n == 0 {
+			out = append(out, pngChunk("IDAT", []byte("frame data"))...)
+		} else {
+			out = append(out, pngChunk("fdAT", []byte("frame data"))...)
+		}
+	}
+	if !beforeIDAT {
+		out = append(out, acTL...)
+	}
+	return append(out, pngChunk("IEND", nil)...)
+}
+
+func webpChunk(typ string, data []byte) []byte {
+	chunk := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFixture(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func anmf(ms uint32) []byte {
+	data := make([]byte, 12)
+	data = append(data, byte(ms), byte(ms>>8), byte(ms>>16), 0)
+	// An odd-sized frame checks the chunk padding.
+	return webpChunk("ANMF", append(data, []byte("VP8L frame")...))
+}
+
+func vp8x(flags byte) []byte {
+	return webpChunk("VP8X", []byte{flags, 0, 0, 0, 3, 0, 0, 3, 0, 0})
+}
+
+func TestInspectAnimation(t *testing.T) {
+	var still bytes.Buffer
+	if err := png.Encode(&still, image.NewNRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name    string
+		content []byte
+		format  SourceFormat
+		want    []time.Duration
+	}{
+		{"gif", gifFixture(t, 5, 0, 12), SourceGIF, []time.Duration{50 * ms, 100 * ms, 120 * ms}},
+		{"gif with 10ms frames", gifFixture(t, 1, 1), SourceGIF, []time.Duration{100 * ms, 100 * ms}},
+		{"still gif", gifFixture(t, 50), "", nil},
+		{"apng", apngFixture(true, [2]uint16{1, 10}, [2]uint16{33, 1000}, [2]uint16{5, 0}), SourcePNG, []time.Duration{100 * ms, 33 * ms, 50 * ms}},
+		{"apng with acTL after IDAT", apngFixture(false, [2]uint16{1, 10}, [2]uint16{1, 10}), "", nil},
+		{"still png", still.Bytes(), "", nil},
+		{"webp", webpFixture(vp8x(0x02), webpChunk("ANIM", make([]byte, 6)), anmf(80), anmf(0), anmf(1500)), SourceWebP, []time.Duration{80 * ms, 100 * ms, 1500 * ms}},
+		{"webp without the animation flag", webpFixture(vp8x(0x10), webpChunk("VP8L", []byte("still image"))), "", nil},
+		{"still webp", webpFixture(webpChunk("VP8L", []byte("still image"))), "", nil},
+		{"jpeg", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00"), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "input")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			animation, err := InspectAnimation(path)
+			if err != nil {
+				t.Fatalf("InspectAnimation = %v", err)
+			}
+			if tt.want == nil {
+				if animation != nil {
+					t.Errorf("InspectAnimation = %+v, want a still image", animation)
+				}
+				return
+			}
+			if animation == nil || animation.Format != tt.format || len(animation.Delays) != len(tt.want) {
+				t.Fatalf("InspectAnimation = %+v, want %s with delays %v", animation, tt.format, tt.want)
+			}
+			for i := range tt.want {
+				if animation.Delays[i] != tt.want[i] {
+					t.Errorf("delay %d = %v, want %v", i, animation.Delays[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Uploads are untrusted; a file cut off anywhere must not crash the worker.
+func TestInspectAnimationTruncated(t *testing.T) {
+	fixtures := map[string][]byte{
+		"gif":  gifFixture(t, 5, 0, 12),
+		"apng": apngFixture(true, [2]uint16{1, 10}, [2]uint16{33, 1000}),
+		"webp": webpFixture(vp8x(0x02), webpChunk("ANIM", make([]byte, 6)), anmf(80), anmf(90)),
+	}
+	dir := t.TempDir()
+	for name, content := range fixtures {
+		for n := 0; n < len(content); n++ {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, content[:n], 0644); err != nil {
+				t.Fatal(err)
+			}
+			InspectAnimation(path)
+		}
+	}
+
+	// Lengths and sizes that point far past the end of the file.
+	corrupt := map[string][]byte{
+		"gif":  append([]byte("GIF89a\x01\x00\x01\x00\x87\x00\x00"), 0x21, 0xF9, 0xFF),
+		"apng": append(append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("acTL", make([]byte, 8))...), "\xff\xff\xff\xfffcTL"...),
+		"webp": webpFixture(webpChunk("VP8X", []byte{0x02}), []byte("ANMF\xff\xff\xff\xff")),
+	}
+	for name, content := range corrupt {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		InspectAnimation(path)
+	}
+}
@@ -0,0 +1,81 @@
+package compressor
+
+import (
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const (
+	ImageBackendAuto        = "auto"
+	ImageBackendImageMagick = "imagemagick"
+	ImageBackendGo          = "go"
+)
+
+// ImageBackend performs the pixel work for ImageCompressor. The ImageMagick
+// backend shells out to convert/identify; the Go backend does everything
+// in-process and needs no external binaries.
+type ImageBackend interface {
+	Name() string
+	Identify(path string) (*ImageInfo, error)
+	Preview(path string, maxSize int) (image.Image, error)
+	Render(inputPath, outputPath string, opts RenderOptions) error
+	Normalize(inputPath, outputPath string, info *ImageInfo) error
+	CanEncode(format models.ImageFormat) bool
+	CanDecode(format SourceFormat) bool
+}
+
+// FrameExtractor is implemented by backends that can decode every frame of
+// an animated image, for animations ffmpeg cannot read.
+type FrameExtractor interface {
+	ExtractFrames(inputPath, outputDir string) ([]string, error)
+}
+
+// ImageInfo describes a source image. Profile is the description of the
+// embedded ICC profile, empty when there is none.
+type ImageInfo struct {
+	Width      int
+	Height     int
+	HasAlpha   bool
+	ColorSpace string
+	Profile    string
+}
+
+type RenderOptions struct {
+	Variant  models.ImageVariantSpec
+	Crop     *image.Rectangle
+	Quality  int
+	Format   models.ImageFormat
+	HasAlpha bool
+}
+
+func NewImageBackend(name, convertPath, identifyPath, srgbProfilePath string) (ImageBackend, error) {
+	switch name {
+	case ImageBackendImageMagick:
+		return NewImageMagickBackend(convertPath, identifyPath, srgbProfilePath), nil
+	case ImageBackendGo:
+		return NewGoImageBackend(), nil
+	case ImageBackendAuto, "":
+		if fileExists(convertPath) && fileExists(identifyPath) {
+			return NewImageMagickBackend(convertPath, identifyPath, srgbProfilePath), nil
+		}
+		return NewGoImageBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown image backend: %s", name)
+	}
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func formatSupportsAlpha(format models.ImageFormat) bool {
+	switch format {
+	case "jpg", "jpeg", "bmp":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,247 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+type SourceFormat string
+
+const (
+	SourceJPEG    SourceFormat = "jpeg"
+	SourcePNG     SourceFormat = "png"
+	SourceGIF     SourceFormat = "gif"
+	SourceWebP    SourceFormat = "webp"
+	SourceAVIF    SourceFormat = "avif"
+	SourceHEIC    SourceFormat = "heic"
+	SourceTIFF    SourceFormat = "tiff"
+	SourceBMP     SourceFormat = "bmp"
+	SourceCR2     SourceFormat = "cr2"
+	SourceCR3     SourceFormat = "cr3"
+	SourceNEF     SourceFormat = "nef"
+	SourceARW     SourceFormat = "arw"
+	SourceDNG     SourceFormat = "dng"
+	SourceORF     SourceFormat = "orf"
+	SourceRW2     SourceFormat = "rw2"
+	SourceRAF     SourceFormat = "raf"
+	SourcePEF     SourceFormat = "pef"
+	SourceUnknown SourceFormat = ""
+)
+
+// Extension is the file extension ImageMagick and its delegates expect for
+// the format.
+func (f SourceFormat) Extension() string {
+	if f == SourceJPEG {
+		return ".jpg"
+	}
+	return "." + string(f)
+}
+
+func (f SourceFormat) IsRaw() bool {
+	switch f {
+	case SourceCR2, SourceCR3, SourceNEF, SourceARW, SourceDNG, SourceORF, SourceRW2, SourceRAF, SourcePEF:
+		return true
+	}
+	return false
+}
+
+// OutputFormat is the web format variants are written in when the caller
+// asks for the "original" format. Formats browsers cannot show are mapped to
+// JPEG, or PNG when transparency has to be kept.
+func (f SourceFormat) OutputFormat(hasAlpha bool) models.ImageFormat {
+	switch f {
+	case SourceJPEG, SourcePNG, SourceGIF, SourceWebP, SourceAVIF:
+		return models.ImageFormat(f)
+	}
+	if hasAlpha {
+		return "png"
+	}
+	return "jpeg"
+}
+
+var isoBrands = map[string]SourceFormat{
+	"avif": SourceAVIF, "avis": SourceAVIF,
+	"heic": SourceHEIC, "heix": SourceHEIC, "hevc": SourceHEIC, "hevx": SourceHEIC,
+	"heim": SourceHEIC, "heis": SourceHEIC, "hevm": SourceHEIC, "hevs": SourceHEIC,
+	"mif1": SourceHEIC, "msf1": SourceHEIC,
+	"crx ": SourceCR3,
+}
+
+// rawMaker is a camera maker whose RAW files are plain TIFF containers. A
+// TIFF only counts as one of them when it also carries the maker's own
+// signature, since scanners and editors write the camera's Make into
+// ordinary TIFFs too.
+type rawMaker struct {
+	format SourceFormat
+	// makerNotes are the prefixes of the MakerNote the maker's cameras write.
+	makerNotes []string
+	// privateTag is an IFD0 tag only the maker's RAW files have, if any.
+	privateTag uint16
+}
+
+var (
+	pentaxMakerNotes = []string{"AOC\x00", "PENTAX \x00"}
+
+	// Canon's CR2 is recognised by its header; a Canon TIFF is a scan.
+	rawMakers = map[string]rawMaker{
+		"NIKON":   {format: SourceNEF, makerNotes: []string{"Nikon\x00"}},
+		"SONY":    {format: SourceARW, makerNotes: []string{"SONY DSC ", "SONY CAM ", "SONY MOBILE "}, privateTag: 0xC634}, // SR2Private
+		"PENTAX":  {format: SourcePEF, makerNotes: pentaxMakerNotes},
+		"RICOH":   {format: SourcePEF, makerNotes: pentaxMakerNotes},
+		"OLYMPUS": {format: SourceORF, makerNotes: []string{"OLYMPUS\x00", "OLYMP\x00"}},
+	}
+)
+
+// DetectFormat identifies an image by its leading bytes, ignoring the file
+// name.
+func DetectFormat(path string) (SourceFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SourceUnknown, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 64)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return SourceUnknown, fmt.Errorf("failed to read header: %w", err)
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return SourceJPEG, nil
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return SourcePNG, nil
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return SourceGIF, nil
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return SourceWebP, nil
+	case bytes.HasPrefix(header, []byte("BM")):
+		return SourceBMP, nil
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")):
+		return SourceRAF, nil
+	case bytes.HasPrefix(header, []byte("IIRO")), bytes.HasPrefix(header, []byte("IIRS")), bytes.HasPrefix(header, []byte("MMOR")):
+		return SourceORF, nil
+	case bytes.HasPrefix(header, []byte("IIU\x00")):
+		return SourceRW2, nil
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return detectISOBrand(header), nil
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		if len(header) >= 10 && bytes.Equal(header[8:10], []byte("CR")) {
+			return SourceCR2, nil
+		}
+		return detectTIFFVariant(f, header)
+	}
+
+	return SourceUnknown, nil
+}
+
+func detectISOBrand(header []byte) SourceFormat {
+	boxSize := int(binary.BigEndian.Uint32(header[0:4]))
+	if boxSize > len(header) || boxSize < 16 {
+		boxSize = len(header)
+	}
+
+	// Major brand, then compatible brands after the minor version.
+	brands := []string{string(header[8:12])}
+	for i := 16; i+4 <= boxSize; i += 4 {
+		brands = append(brands, string(header[i:i+4]))
+	}
+	for _, brand := range brands {
+		if format, ok := isoBrands[brand]; ok {
+			return format
+		}
+	}
+	return SourceUnknown
+}
+
+// detectTIFFVariant walks IFD0 of a TIFF container. Camera RAW formats such
+// as NEF, ARW and DNG are TIFF files that carry a DNGVersion tag, or a camera
+// maker in the Make tag together with that maker's MakerNote.
+func detectTIFFVariant(r io.ReaderAt, header []byte) (SourceFormat, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	ifd0 := readIFD(r, order, int64(order.Uint32(header[4:8])))
+	// A DNG keeps the Make of the camera it was converted from.
+	if _, ok := ifd0[0xC612]; ok { // DNGVersion
+		return SourceDNG, nil
+	}
+	makeTag, ok := ifd0[0x010F]
+	if !ok {
+		return SourceTIFF, nil
+	}
+	maker := strings.ToUpper(strings.TrimRight(string(entryValue(r, order, makeTag, 64)), "\x00 "))
+	for prefix, raw := range rawMakers {
+		if !strings.HasPrefix(maker, prefix) {
+			continue
+		}
+		if _, ok := ifd0[raw.privateTag]; raw.privateTag != 0 && ok {
+			return raw.format, nil
+		}
+		exif, ok := ifd0[0x8769] // ExifIFD
+		if !ok {
+			break
+		}
+		makerNote, ok := readIFD(r, order, int64(order.Uint32(exif[8:12])))[0x927C]
+		if !ok {
+			break
+		}
+		value := entryValue(r, order, makerNote, 16)
+		for _, signature := range raw.makerNotes {
+			if bytes.HasPrefix(value, []byte(signature)) {
+				return raw.format, nil
+			}
+		}
+		break
+	}
+
+	return SourceTIFF, nil
+}
+
+// readIFD returns the 12-byte entries of the IFD at offset by tag. A damaged
+// IFD reads as empty.
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset int64) map[uint16][]byte {
+	var countBuf [2]byte
+	if _, err := r.ReadAt(countBuf[:], offset); err != nil {
+		return nil
+	}
+	count := int(order.Uint16(countBuf[:]))
+	if count > 512 {
+		return nil
+	}
+
+	buf := make([]byte, count*12)
+	if _, err := r.ReadAt(buf, offset+2); err != nil {
+		return nil
+	}
+	entries := make(map[uint16][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := buf[i*12 : i*12+12]
+		entries[order.Uint16(entry[0:2])] = entry
+	}
+	return entries
+}
+
+// entryValue returns up to limit bytes of a byte-typed entry such as ASCII or
+// UNDEFINED. Values of four bytes or less are stored in the entry itself.
+func entryValue(r io.ReaderAt, order binary.ByteOrder, entry []byte, limit int) []byte {
+	length := int(order.Uint32(entry[4:8]))
+	if length <= 4 {
+		return entry[8 : 8+length]
+	}
+	value := make([]byte, min(length, limit))
+	if _, err := r.ReadAt(value, int64(order.Uint32(entry[8:12]))); err != nil {
+		return nil
+	}
+	return value
+}
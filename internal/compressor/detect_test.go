@@ -0,0 +1,138 @@
+package compressor
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type tiffTag struct {
+	tag   uint16
+	typ   uint16
+	value []byte
+}
+
+func ascii(s string) tiffTag     { return tiffTag{0x010F, 2, []byte(s + "\x00")} }
+func makerNote(s string) tiffTag { return tiffTag{0x927C, 7, []byte(s)} }
+
+// tiffFile lays out a TIFF with IFD0, an Exif IFD when exif is not nil, and
+// the values that do not fit in their entries after both.
+func tiffFile(bigEndian bool, ifd0, exif []tiffTag) []byte {
+	var order binary.AppendByteOrder = binary.LittleEndian
+	out := []byte("II*\x00")
+	if bigEndian {
+		order = binary.BigEndian
+		out = []byte("MM\x00*")
+	}
+	out = order.AppendUint32(out, 8)
+
+	ifd0 = append([]tiffTag(nil), ifd0...)
+	exifOffset := 8 + 2 + 12*(len(ifd0)+1) + 4
+	if exif != nil {
+		ifd0 = append(ifd0, tiffTag{0x8769, 4, order.AppendUint32(nil, uint32(exifOffset))})
+	}
+	dataOffset := exifOffset + 2 + 12*len(exif) + 4
+
+	var data []byte
+	writeIFD := func(tags []tiffTag) {
+		sort.Slice(tags, func(i, j int) bool { return tags[i].tag < tags[j].tag })
+		out = order.AppendUint16(out, uint16(len(tags)))
+		for _, tag := range tags {
+			out = order.AppendUint16(out, tag.tag)
+			out = order.AppendUint16(out, tag.typ)
+			count := len(tag.value)
+			if tag.typ == 4 {
+				count /= 4
+			}
+			out = order.AppendUint32(out, uint32(count))
+			if len(tag.value) <= 4 {
+				out = append(out, make([]byte, 4)...)
+				copy(out[len(out)-4:], tag.value)
+				continue
+			}
+			out = order.AppendUint32(out, uint32(dataOffset+len(data)))
+			data = append(data, tag.value...)
+		}
+		out = order.AppendUint32(out, 0)
+	}
+	writeIFD(ifd0)
+	if exif != nil {
+		writeIFD(exif)
+	} else {
+		// Keep the offsets computed for an Exif IFD valid.
+		out = append(out, make([]byte, dataOffset-len(out))...)
+	}
+	return append(out, data...)
+}
+
+func TestDetectFormat(t *testing.T) {
+	dngVersion := tiffTag{0xC612, 1, []byte{1, 4, 0, 0}}
+	sr2Private := tiffTag{0xC634, 4, []byte{0, 1, 0, 0}}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    SourceFormat
+	}{
+		{"text", []byte("hello, world"), SourceUnknown},
+		{"jpeg", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00"), SourceJPEG},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), SourcePNG},
+		{"gif87a", []byte("GIF87a\x01\x00\x01\x00"), SourceGIF},
+		{"gif89a", []byte("GIF89a\x01\x00\x01\x00"), SourceGIF},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), SourceWebP},
+		{"riff without webp", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), SourceUnknown},
+		{"bmp", []byte("BM\x3e\x00\x00\x00\x00\x00"), SourceBMP},
+		{"raf", []byte("FUJIFILMCCD-RAW 0201FF383501"), SourceRAF},
+		{"orf", []byte("IIRO\x08\x00\x00\x00"), SourceORF},
+		{"orf big-endian", []byte("MMOR\x00\x00\x00\x08"), SourceORF},
+		{"rw2", []byte("IIU\x00\x18\x00\x00\x00"), SourceRW2},
+		{"cr2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00\x00\x00\x00\x00"), SourceCR2},
+		{"avif", []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf"), SourceAVIF},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), SourceHEIC},
+		{"heif by compatible brand", []byte("\x00\x00\x00\x18ftypmiaf\x00\x00\x00\x00miafmif1"), SourceHEIC},
+		{"cr3", []byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01crx isom"), SourceCR3},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2"), SourceUnknown},
+
+		{"tiff", tiffFile(false, []tiffTag{{0x0100, 3, []byte{1, 0}}}, nil), SourceTIFF},
+		{"tiff big-endian", tiffFile(true, []tiffTag{{0x0100, 3, []byte{0, 1}}}, nil), SourceTIFF},
+		{"tiff with a short make", tiffFile(false, []tiffTag{ascii("HP")}, nil), SourceTIFF},
+		{"nikon scan", tiffFile(false, []tiffTag{ascii("Nikon")}, nil), SourceTIFF},
+		{"nikon without maker note", tiffFile(false, []tiffTag{ascii("NIKON CORPORATION")}, []tiffTag{}), SourceTIFF},
+		{"nef", tiffFile(false, []tiffTag{ascii("NIKON CORPORATION")}, []tiffTag{makerNote("Nikon\x00\x02\x10\x00\x00MM\x00*")}), SourceNEF},
+		{"nikon with another maker note", tiffFile(false, []tiffTag{ascii("NIKON CORPORATION")}, []tiffTag{makerNote("AOC\x00MM\x00\x00\x00")}), SourceTIFF},
+		{"canon scan", tiffFile(false, []tiffTag{ascii("Canon")}, nil), SourceTIFF},
+		{"dng", tiffFile(false, []tiffTag{dngVersion, ascii("Adobe")}, nil), SourceDNG},
+		{"dng from a nikon", tiffFile(false, []tiffTag{ascii("NIKON CORPORATION"), dngVersion}, []tiffTag{makerNote("Nikon\x00\x02\x10\x00\x00MM\x00*")}), SourceDNG},
+		{"dng from a sony", tiffFile(false, []tiffTag{ascii("SONY"), dngVersion, sr2Private}, nil), SourceDNG},
+		{"arw", tiffFile(false, []tiffTag{ascii("SONY"), sr2Private}, nil), SourceARW},
+		{"arw by maker note", tiffFile(false, []tiffTag{ascii("SONY")}, []tiffTag{makerNote("SONY DSC \x00\x00\x00")}), SourceARW},
+		{"sony tiff", tiffFile(false, []tiffTag{ascii("SONY")}, []tiffTag{}), SourceTIFF},
+		{"pef", tiffFile(true, []tiffTag{ascii("PENTAX Corporation")}, []tiffTag{makerNote("AOC\x00MM\x00\x00\x00")}), SourcePEF},
+		{"pef by ricoh", tiffFile(false, []tiffTag{ascii("RICOH IMAGING COMPANY, LTD.")}, []tiffTag{makerNote("PENTAX \x00II\x00\x00")}), SourcePEF},
+		{"pentax scan", tiffFile(true, []tiffTag{ascii("PENTAX Corporation")}, nil), SourceTIFF},
+		{"olympus", tiffFile(false, []tiffTag{ascii("OLYMPUS IMAGING CORP.")}, []tiffTag{makerNote("OLYMPUS\x00II\x03\x00")}), SourceORF},
+
+		{"tiff with IFD0 past the end", []byte("II*\x00\xff\xff\x00\x00"), SourceTIFF},
+		{"tiff with a truncated IFD0", []byte("II*\x00\x08\x00\x00\x00\x10\x00\x0f\x01\x02\x00"), SourceTIFF},
+		{"tiff with too many entries", []byte("MM\x00*\x00\x00\x00\x08\xff\xff"), SourceTIFF},
+		{"make past the end", tiffFile(false, []tiffTag{ascii("NIKON CORPORATION")}, nil)[:26], SourceTIFF},
+		{"exif IFD past the end", tiffFile(false, []tiffTag{ascii("NIKON"), {0x8769, 4, []byte{0, 0, 1, 0}}}, nil), SourceTIFF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "upload.jpg")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DetectFormat(path)
+			if err != nil {
+				t.Fatalf("DetectFormat = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
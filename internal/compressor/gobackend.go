@@ -0,0 +1,253 @@
+package compressor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+// GoImageBackend decodes, resizes and encodes with the standard library and
+// golang.org/x/image. It reads JPEG, PNG, GIF, WebP, TIFF and BMP and writes
+// JPEG, PNG and GIF; other output formats are skipped by the caller. Embedded
+// ICC profiles are ignored.
+type GoImageBackend struct {
+	scaler draw.Scaler
+}
+
+func NewGoImageBackend() *GoImageBackend {
+	return &GoImageBackend{scaler: draw.CatmullRom}
+}
+
+func (b *GoImageBackend) Name() string {
+	return ImageBackendGo
+}
+
+// Identify reads only the image header, so it is safe to call before the
+// input limits have been checked.
+func (b *GoImageBackend) Identify(path string) (*ImageInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	info := &ImageInfo{Width: config.Width, Height: config.Height, ColorSpace: "sRGB"}
+	switch model := config.ColorModel.(type) {
+	case color.Palette:
+		for _, c := range model {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				info.HasAlpha = true
+				break
+			}
+		}
+	default:
+		switch model {
+		case color.CMYKModel:
+			info.ColorSpace = "CMYK"
+		case color.GrayModel, color.Gray16Model:
+			info.ColorSpace = "Gray"
+		case color.NRGBAModel, color.NRGBA64Model, color.AlphaModel, color.Alpha16Model:
+			// The decoders use the premultiplied RGBA models for
+			// formats without an alpha channel.
+			info.HasAlpha = true
+		}
+	}
+	return info, nil
+}
+
+func (b *GoImageBackend) Preview(path string, maxSize int) (image.Image, error) {
+	img, err := decodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	scale := math.Min(1, float64(maxSize)/float64(max(bounds.Dx(), bounds.Dy())))
+	return b.resize(img, scaled(bounds.Dx(), scale), scaled(bounds.Dy(), scale)), nil
+}
+
+func (b *GoImageBackend) Render(inputPath, outputPath string, opts RenderOptions) error {
+	img, err := decodeFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	if opts.Crop != nil {
+		img = crop(img, *opts.Crop)
+	}
+
+	img, err = b.fit(img, opts.Variant, opts.HasAlpha)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output: %w", err)
+	}
+	defer out.Close()
+
+	switch opts.Format {
+	case "jpeg", "jpg":
+		if opts.HasAlpha {
+			img = flatten(img, color.White)
+		}
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: opts.Quality})
+	case "png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(out, img)
+	case "gif":
+		err = gif.Encode(out, img, nil)
+	default:
+		return fmt.Errorf("go backend cannot encode %s", opts.Format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", opts.Format, err)
+	}
+
+	return out.Close()
+}
+
+func (b *GoImageBackend) Normalize(inputPath, outputPath string, info *ImageInfo) error {
+	img, err := decodeFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output: %w", err)
+	}
+	defer out.Close()
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(out, img); err != nil {
+		return fmt.Errorf("failed to encode png: %w", err)
+	}
+
+	return out.Close()
+}
+
+func (b *GoImageBackend) CanDecode(format SourceFormat) bool {
+	switch format {
+	case SourceJPEG, SourcePNG, SourceGIF, SourceWebP, SourceTIFF, SourceBMP:
+		return true
+	}
+	return false
+}
+
+func (b *GoImageBackend) CanEncode(format models.ImageFormat) bool {
+	switch format {
+	case "jpeg", "jpg", "png", "gif":
+		return true
+	}
+	return false
+}
+
+func (b *GoImageBackend) fit(img image.Image, variant models.ImageVariantSpec, hasAlpha bool) (image.Image, error) {
+	if variant.Width == 0 && variant.Height == 0 {
+		return img, nil
+	}
+
+	bounds := img.Bounds()
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+	scaleW := float64(variant.Width) / srcW
+	scaleH := float64(variant.Height) / srcH
+
+	switch {
+	case variant.Height == 0:
+		return b.resize(img, variant.Width, scaled(bounds.Dy(), scaleW)), nil
+	case variant.Width == 0:
+		return b.resize(img, scaled(bounds.Dx(), scaleH), variant.Height), nil
+	}
+
+	switch variant.Fit {
+	case models.FitFill:
+		return b.resize(img, variant.Width, variant.Height), nil
+	case models.FitInside, "":
+		scale := math.Min(scaleW, scaleH)
+		return b.resize(img, scaled(bounds.Dx(), scale), scaled(bounds.Dy(), scale)), nil
+	case models.FitCover:
+		scale := math.Max(scaleW, scaleH)
+		resized := b.resize(img, scaled(bounds.Dx(), scale), scaled(bounds.Dy(), scale))
+		rb := resized.Bounds()
+		x := (rb.Dx() - variant.Width) / 2
+		y := (rb.Dy() - variant.Height) / 2
+		return crop(resized, image.Rect(x, y, x+variant.Width, y+variant.Height)), nil
+	case models.FitContain:
+		scale := math.Min(scaleW, scaleH)
+		resized := b.resize(img, scaled(bounds.Dx(), scale), scaled(bounds.Dy(), scale))
+		canvas := image.NewNRGBA(image.Rect(0, 0, variant.Width, variant.Height))
+		if !hasAlpha {
+			draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+		}
+		rb := resized.Bounds()
+		offset := image.Pt((variant.Width-rb.Dx())/2, (variant.Height-rb.Dy())/2)
+		draw.Draw(canvas, rb.Sub(rb.Min).Add(offset), resized, rb.Min, draw.Over)
+		return canvas, nil
+	default:
+		return nil, fmt.Errorf("unsupported fit mode: %s", variant.Fit)
+	}
+}
+
+func (b *GoImageBackend) resize(img image.Image, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	b.scaler.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func decodeFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+func crop(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Add(img.Bounds().Min).Intersect(img.Bounds())
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+func flatten(img image.Image, background color.Color) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+func scaled(n int, scale float64) int {
+	return max(1, int(math.Round(float64(n)*scale)))
+}
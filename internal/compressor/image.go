@@ -2,70 +2,405 @@ package compressor
 
 import (
 	"fmt"
+	"image"
+	"log"
+	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/yourusername/video-compressor/internal/models"
 )
 
+const (
+	previewSize           = 384
+	perceptualCompareSize = 2048
+	minPerceptualQuality  = 30
+	maxPerceptualQuality  = 95
+)
+
 type ImageCompressor struct {
-	imageMagickPath string
-	tempDir         string
+	backend ImageBackend
+	limits  ImageLimits
+}
+
+// ImageLimits bounds the images Prepare accepts. Zero disables a limit.
+type ImageLimits struct {
+	MaxPixels    int64
+	MaxDimension int
 }
 
-func NewImageCompressor(imageMagickPath, tempDir string) *ImageCompressor {
+func NewImageCompressor(backend ImageBackend, limits ImageLimits) *ImageCompressor {
 	return &ImageCompressor{
-		imageMagickPath: imageMagickPath,
-		tempDir:         tempDir,
+		backend: backend,
+		limits:  limits,
+	}
+}
+
+func (i *ImageCompressor) Backend() ImageBackend {
+	return i.backend
+}
+
+// SourceImage is a downloaded image ready for variant generation. Path points
+// at OriginalPath unless the input had to be decoded to an sRGB intermediate.
+type SourceImage struct {
+	OriginalPath string
+	Path         string
+	Format       SourceFormat
+	Width        int
+	Height       int
+	HasAlpha     bool
+}
+
+// Prepare identifies a downloaded image by its content, gives it the matching
+// extension and converts formats browsers cannot display, CMYK images and
+// images with a non-sRGB ICC profile to an sRGB PNG intermediate.
+func (i *ImageCompressor) Prepare(inputPath string) (*SourceImage, error) {
+	format, err := DetectFormat(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect image format: %w", err)
+	}
+	if format == SourceUnknown {
+		return nil, fmt.Errorf("unrecognised image format")
+	}
+	if !i.backend.CanDecode(format) {
+		return nil, fmt.Errorf("%s images are not supported by the %s backend", format, i.backend.Name())
+	}
+
+	originalPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + format.Extension()
+	if originalPath != inputPath {
+		if err := os.Rename(inputPath, originalPath); err != nil {
+			return nil, fmt.Errorf("failed to rename input: %w", err)
+		}
+	}
+
+	info, err := i.backend.Identify(originalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify %s image: %w", format, err)
+	}
+	if err := i.limits.check(info.Width, info.Height); err != nil {
+		return nil, err
+	}
+
+	source := &SourceImage{
+		OriginalPath: originalPath,
+		Path:         originalPath,
+		Format:       format,
+		Width:        info.Width,
+		Height:       info.Height,
+		HasAlpha:     info.HasAlpha,
+	}
+
+	if !needsNormalization(format, info) {
+		return source, nil
+	}
+
+	normalizedPath := strings.TrimSuffix(originalPath, filepath.Ext(originalPath)) + "_srgb.png"
+	if err := i.backend.Normalize(originalPath, normalizedPath, info); err != nil {
+		return nil, fmt.Errorf("failed to convert %s image to sRGB: %w", format, err)
+	}
+	source.Path = normalizedPath
+
+	if normalized, err := i.backend.Identify(normalizedPath); err == nil {
+		source.Width, source.Height = normalized.Width, normalized.Height
+	}
+
+	return source, nil
+}
+
+// check runs on header-only Identify results, before any pixels are decoded.
+func (l ImageLimits) check(width, height int) error {
+	if l.MaxDimension > 0 && (width > l.MaxDimension || height > l.MaxDimension) {
+		return fmt.Errorf("%w: image is %dx%d, the largest side allowed is %d pixels", models.ErrLimitExceeded, width, height, l.MaxDimension)
+	}
+	if pixels := int64(width) * int64(height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return fmt.Errorf("%w: image has %d pixels, the limit is %d", models.ErrLimitExceeded, pixels, l.MaxPixels)
 	}
+	return nil
 }
 
-func (i *ImageCompressor) CompressWithVariants(inputPath string, quality models.ImageQuality, variants []string) (map[string]string, error) {
-	results := make(map[string]string)
+func needsNormalization(format SourceFormat, info *ImageInfo) bool {
+	switch format {
+	case SourceJPEG, SourcePNG, SourceGIF, SourceWebP, SourceAVIF:
+	default:
+		return true
+	}
+
+	if strings.EqualFold(info.ColorSpace, "CMYK") {
+		return true
+	}
+	return info.Profile != "" && !strings.Contains(strings.ToLower(info.Profile), "srgb")
+}
+
+type VariantOutput struct {
+	Path    string
+	Formats map[models.ImageFormat]string
+	Quality int
+	Score   float64
+}
+
+// CompressWithVariants writes each variant to outputDir, named after the
+// variant.
+func (i *ImageCompressor) CompressWithVariants(source *SourceImage, outputDir string, data *models.ImageData, variants []models.ImageVariantSpec) (map[string]*VariantOutput, error) {
+	results := make(map[string]*VariantOutput)
+	planner := &cropPlanner{compressor: i, inputPath: source.Path, focal: data.FocalPoint}
 
 	for _, variant := range variants {
-		outputPath, err := i.generateVariant(inputPath, variant, quality)
+		crop := planner.cropFor(variant)
+
+		output, err := i.generateVariant(source, outputDir, variant, data, crop)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate %s variant: %w", variant, err)
+			return nil, fmt.Errorf("failed to generate %s variant: %w", variant.Name, err)
 		}
-		results[variant] = outputPath
+		results[variant.Name] = output
 	}
 
 	return results, nil
 }
 
-func (i *ImageCompressor) generateVariant(inputPath, variant string, quality models.ImageQuality) (string, error) {
-	ext := filepath.Ext(inputPath)
-	outputPath := filepath.Join(i.tempDir, fmt.Sprintf("%s_%d%s", variant, time.Now().Unix(), ext))
+func (i *ImageCompressor) generateVariant(source *SourceImage, outputDir string, variant models.ImageVariantSpec, data *models.ImageData, crop *image.Rectangle) (*VariantOutput, error) {
+	inputPath, hasAlpha := source.Path, source.HasAlpha
+	base := filepath.Join(outputDir, variant.Name)
+
+	qualityValue := variant.Quality
+	if qualityValue == 0 {
+		qualityValue = i.getQualityValue(data.Quality, variant.Name)
+	}
+
+	opts := RenderOptions{
+		Variant:  variant,
+		Crop:     crop,
+		Quality:  qualityValue,
+		Format:   source.Format.OutputFormat(hasAlpha),
+		HasAlpha: hasAlpha,
+	}
+	if !i.backend.CanEncode(opts.Format) {
+		opts.Format = "jpeg"
+		if hasAlpha {
+			opts.Format = "png"
+		}
+	}
+	ext := extensionFor(opts.Format)
 
-	var args []string
-	args = append(args, inputPath)
+	var target float64
+	var reference image.Image
+	if data.QualityMode == models.QualityModePerceptual && variant.Quality == 0 {
+		target = data.TargetScore
+		if target == 0 {
+			target = defaultTargetScore(data.Quality)
+		}
 
-	qualityValue := i.getQualityValue(quality, variant)
+		var err error
+		reference, err = i.renderReference(inputPath, base, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	switch variant {
-	case "thumbnail":
-		args = append(args, "-resize", "150x150^", "-gravity", "center", "-extent", "150x150")
-	case "medium":
-		args = append(args, "-resize", "400x300")
-	case "large":
-		args = append(args, "-resize", "800x600")
-	case "original":
-	default:
-		return "", fmt.Errorf("unsupported variant: %s", variant)
+	outputPath := base + ext
+	quality, score, err := i.render(inputPath, outputPath, opts, reference, target)
+	if err != nil {
+		return nil, err
 	}
 
-	args = append(args, "-quality", fmt.Sprintf("%d", qualityValue), outputPath)
+	output := &VariantOutput{
+		Path:    outputPath,
+		Formats: make(map[models.ImageFormat]string),
+		Quality: quality,
+		Score:   score,
+	}
 
-	cmd := exec.Command(i.imageMagickPath, args...)
-	output, err := cmd.CombinedOutput()
+	baseInfo, err := os.Stat(outputPath)
 	if err != nil {
-		return "", fmt.Errorf("imagemagick failed: %w, output: %s", err, string(output))
+		return nil, err
 	}
 
-	return outputPath, nil
+	for _, format := range data.Formats {
+		if format == models.ImageFormatOriginal || format == opts.Format {
+			continue
+		}
+		if !i.backend.CanEncode(format) {
+			log.Printf("Skipping %s output for %s variant: not supported by the %s backend", format, variant.Name, i.backend.Name())
+			continue
+		}
+
+		formatOpts := opts
+		formatOpts.Format = format
+		formatPath := base + extensionFor(format)
+		if _, _, err := i.render(inputPath, formatPath, formatOpts, reference, target); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", format, err)
+		}
+
+		info, err := os.Stat(formatPath)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() >= baseInfo.Size() {
+			os.Remove(formatPath)
+			continue
+		}
+
+		output.Formats[format] = formatPath
+	}
+
+	return output, nil
+}
+
+// render encodes a variant. Without a reference image it uses the quality in
+// opts; with one it binary-searches the lowest quality whose SSIM against the
+// reference reaches target, and returns the chosen quality and its score.
+func (i *ImageCompressor) render(inputPath, outputPath string, opts RenderOptions, reference image.Image, target float64) (int, float64, error) {
+	if reference == nil || !isLossyFormat(opts.Format) {
+		return opts.Quality, 0, i.backend.Render(inputPath, outputPath, opts)
+	}
+
+	lo, hi := minPerceptualQuality, maxPerceptualQuality
+	bestQuality := maxPerceptualQuality
+	scores := make(map[int]float64)
+	lastRendered := -1
+
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		opts.Quality = mid
+		if err := i.backend.Render(inputPath, outputPath, opts); err != nil {
+			return 0, 0, err
+		}
+		lastRendered = mid
+
+		score, err := i.compare(reference, outputPath)
+		if err != nil {
+			return 0, 0, err
+		}
+		scores[mid] = score
+
+		if score >= target {
+			bestQuality = mid
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	// If even the highest quality misses the target, bestQuality stays at
+	// the maximum.
+	if lastRendered != bestQuality {
+		opts.Quality = bestQuality
+		if err := i.backend.Render(inputPath, outputPath, opts); err != nil {
+			return 0, 0, err
+		}
+		if _, ok := scores[bestQuality]; !ok {
+			score, err := i.compare(reference, outputPath)
+			if err != nil {
+				return 0, 0, err
+			}
+			scores[bestQuality] = score
+		}
+	}
+
+	return bestQuality, scores[bestQuality], nil
+}
+
+func (i *ImageCompressor) renderReference(inputPath, base string, opts RenderOptions) (image.Image, error) {
+	referencePath := base + "_reference.png"
+	defer os.Remove(referencePath)
+
+	opts.Format = "png"
+	if err := i.backend.Render(inputPath, referencePath, opts); err != nil {
+		return nil, fmt.Errorf("failed to render reference: %w", err)
+	}
+	return i.backend.Preview(referencePath, perceptualCompareSize)
+}
+
+func (i *ImageCompressor) compare(reference image.Image, candidatePath string) (float64, error) {
+	candidate, err := i.backend.Preview(candidatePath, perceptualCompareSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode candidate: %w", err)
+	}
+	return SSIM(reference, candidate)
+}
+
+func defaultTargetScore(quality models.ImageQuality) float64 {
+	switch quality {
+	case models.ImageQualityLow:
+		return 0.95
+	case models.ImageQualityHigh:
+		return 0.985
+	case models.ImageQualityUltra:
+		return 0.995
+	default:
+		return 0.975
+	}
+}
+
+func isLossyFormat(format models.ImageFormat) bool {
+	switch format {
+	case "jpeg", "jpg", models.ImageFormatWebP, models.ImageFormatAVIF:
+		return true
+	}
+	return false
+}
+
+// cropPlanner picks the source window for cover variants whose aspect ratio
+// differs from the source. An explicit focal point wins; otherwise the
+// saliency map is built once from a small preview and shared by all variants.
+type cropPlanner struct {
+	compressor *ImageCompressor
+	inputPath  string
+	focal      *models.FocalPoint
+
+	loaded   bool
+	width    int
+	height   int
+	saliency *SaliencyMap
+}
+
+func (p *cropPlanner) cropFor(variant models.ImageVariantSpec) *image.Rectangle {
+	if variant.Fit != models.FitCover || variant.Width == 0 || variant.Height == 0 {
+		return nil
+	}
+
+	if !p.loaded {
+		p.loaded = true
+		if info, err := p.compressor.backend.Identify(p.inputPath); err == nil {
+			p.width, p.height = info.Width, info.Height
+		}
+		if p.width > 0 && p.height > 0 && p.focal == nil {
+			if preview, err := p.compressor.backend.Preview(p.inputPath, previewSize); err == nil {
+				p.saliency = NewSaliencyMap(preview, p.width, p.height)
+			} else {
+				log.Printf("Smart crop unavailable for %s: %v", p.inputPath, err)
+			}
+		}
+	}
+	if p.width == 0 || p.height == 0 {
+		return nil
+	}
+
+	sourceAspect := float64(p.width) / float64(p.height)
+	targetAspect := float64(variant.Width) / float64(variant.Height)
+	if math.Abs(sourceAspect-targetAspect) < 0.01 {
+		return nil
+	}
+
+	cropWidth, cropHeight := CoverCropSize(p.width, p.height, variant.Width, variant.Height)
+
+	var crop image.Rectangle
+	switch {
+	case p.focal != nil:
+		crop = FocalCrop(p.width, p.height, cropWidth, cropHeight, *p.focal)
+	case p.saliency != nil:
+		crop = p.saliency.BestCrop(cropWidth, cropHeight)
+	default:
+		return nil
+	}
+	return &crop
+}
+
+func extensionFor(format models.ImageFormat) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + string(format)
 }
 
 func (i *ImageCompressor) getQualityValue(quality models.ImageQuality, variant string) int {
@@ -94,11 +429,10 @@ func (i *ImageCompressor) GetImageInfo(imagePath string) (int64, string, error)
 		return 0, "", err
 	}
 
-	cmd := exec.Command("identify", "-format", "%wx%h", imagePath)
-	output, err := cmd.Output()
+	imageInfo, err := i.backend.Identify(imagePath)
 	if err != nil {
 		return info.Size(), "", nil
 	}
 
-	return info.Size(), string(output), nil
+	return info.Size(), fmt.Sprintf("%dx%d", imageInfo.Width, imageInfo.Height), nil
 }
@@ -0,0 +1,126 @@
+package compressor
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+// qualityBackend renders a file holding the quality it was asked for, and
+// previews it as the reference with less noise the higher that quality is.
+type qualityBackend struct {
+	reference *image.NRGBA
+	renders   []int
+}
+
+func (b *qualityBackend) Name() string                                    { return "test" }
+func (b *qualityBackend) Identify(path string) (*ImageInfo, error)        { return nil, nil }
+func (b *qualityBackend) CanEncode(format models.ImageFormat) bool        { return true }
+func (b *qualityBackend) CanDecode(format SourceFormat) bool              { return true }
+func (b *qualityBackend) Normalize(in, out string, info *ImageInfo) error { return nil }
+
+func (b *qualityBackend) Render(inputPath, outputPath string, opts RenderOptions) error {
+	b.renders = append(b.renders, opts.Quality)
+	return os.WriteFile(outputPath, []byte(strconv.Itoa(opts.Quality)), 0644)
+}
+
+func (b *qualityBackend) Preview(path string, maxSize int) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	quality, err := strconv.Atoi(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return b.preview(quality), nil
+}
+
+func (b *qualityBackend) preview(quality int) image.Image {
+	return noisy(b.reference, float64(100-quality)/2)
+}
+
+func TestRenderQualitySearch(t *testing.T) {
+	reference := gradient(32, 32)
+	fixture := &qualityBackend{reference: reference}
+	scores := make(map[int]float64)
+	for q := minPerceptualQuality; q <= maxPerceptualQuality; q++ {
+		score, err := SSIM(reference, fixture.preview(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q > minPerceptualQuality && score < scores[q-1] {
+			t.Fatalf("fixture score drops from %v to %v at quality %d", scores[q-1], score, q)
+		}
+		scores[q] = score
+	}
+	// lowest is the quality a linear scan would pick.
+	lowest := func(target float64) int {
+		for q := minPerceptualQuality; q <= maxPerceptualQuality; q++ {
+			if scores[q] >= target {
+				return q
+			}
+		}
+		return maxPerceptualQuality
+	}
+
+	tests := []struct {
+		name   string
+		target float64
+	}{
+		{"any quality passes", 0},
+		{"middle", scores[62]},
+		{"between scores", (scores[40] + scores[41]) / 2},
+		{"low", scores[31]},
+		{"high", scores[94]},
+		{"unreachable", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &qualityBackend{reference: reference}
+			i := NewImageCompressor(backend, ImageLimits{})
+			output := filepath.Join(t.TempDir(), "medium.webp")
+
+			quality, score, err := i.render("input.png", output, RenderOptions{Format: models.ImageFormatWebP}, reference, tt.target)
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			want := lowest(tt.target)
+			if quality != want || score != scores[want] {
+				t.Errorf("render = quality %d, score %v, want %d, %v", quality, score, want, scores[want])
+			}
+			if data, _ := os.ReadFile(output); string(data) != strconv.Itoa(want) {
+				t.Errorf("output was left at quality %s, want %d", data, want)
+			}
+			if len(backend.renders) > 8 {
+				t.Errorf("rendered %d times: %v", len(backend.renders), backend.renders)
+			}
+		})
+	}
+}
+
+func TestRenderWithoutSearch(t *testing.T) {
+	reference := gradient(16, 16)
+	tests := []struct {
+		name      string
+		format    models.ImageFormat
+		reference image.Image
+	}{
+		{"lossless format", models.ImageFormat("png"), reference},
+		{"no reference", models.ImageFormatWebP, nil},
+	}
+	for _, tt := range tests {
+		backend := &qualityBackend{reference: reference}
+		i := NewImageCompressor(backend, ImageLimits{})
+		output := filepath.Join(t.TempDir(), "medium")
+
+		quality, score, err := i.render("input.png", output, RenderOptions{Format: tt.format, Quality: 82}, tt.reference, 0.99)
+		if err != nil || quality != 82 || score != 0 || len(backend.renders) != 1 {
+			t.Errorf("%s: render = %d, %v, %v after %v, want quality 82 from one render", tt.name, quality, score, err, backend.renders)
+		}
+	}
+}
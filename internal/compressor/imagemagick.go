@@ -0,0 +1,179 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+type ImageMagickBackend struct {
+	convertPath     string
+	identifyPath    string
+	srgbProfilePath string
+}
+
+func NewImageMagickBackend(convertPath, identifyPath, srgbProfilePath string) *ImageMagickBackend {
+	return &ImageMagickBackend{
+		convertPath:     convertPath,
+		identifyPath:    identifyPath,
+		srgbProfilePath: srgbProfilePath,
+	}
+}
+
+func (b *ImageMagickBackend) Name() string {
+	return ImageBackendImageMagick
+}
+
+func (b *ImageMagickBackend) Identify(path string) (*ImageInfo, error) {
+	cmd := exec.Command(b.identifyPath, "-ping", "-format", "%w %h %A %[colorspace]\n%[profile:icc]", path+"[0]")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("identify failed: %w", err)
+	}
+
+	line, profile, _ := strings.Cut(string(output), "\n")
+
+	var width, height int
+	var alpha, colorSpace string
+	if _, err := fmt.Sscanf(line, "%d %d %s %s", &width, &height, &alpha, &colorSpace); err != nil {
+		return nil, fmt.Errorf("unexpected identify output %q", string(output))
+	}
+
+	info := &ImageInfo{
+		Width:      width,
+		Height:     height,
+		ColorSpace: colorSpace,
+		Profile:    strings.TrimSpace(profile),
+	}
+	switch strings.ToLower(alpha) {
+	case "true", "blend", "activate", "on":
+		info.HasAlpha = true
+	}
+	return info, nil
+}
+
+func (b *ImageMagickBackend) Preview(path string, maxSize int) (image.Image, error) {
+	cmd := exec.Command(b.convertPath, path+"[0]", "-resize", fmt.Sprintf("%dx%d>", maxSize, maxSize), "png:-")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("imagemagick preview failed: %w", err)
+	}
+	return png.Decode(bytes.NewReader(output))
+}
+
+func (b *ImageMagickBackend) Render(inputPath, outputPath string, opts RenderOptions) error {
+	geometry, err := geometryArgs(opts.Variant, opts.HasAlpha)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	args = append(args, inputPath+"[0]")
+	if crop := opts.Crop; crop != nil {
+		args = append(args, "-crop", fmt.Sprintf("%dx%d+%d+%d", crop.Dx(), crop.Dy(), crop.Min.X, crop.Min.Y), "+repage")
+	}
+	args = append(args, geometry...)
+
+	switch {
+	case opts.HasAlpha && !formatSupportsAlpha(opts.Format):
+		args = append(args, "-background", "white", "-alpha", "remove", "-alpha", "off")
+	case opts.HasAlpha && opts.Format == models.ImageFormatWebP:
+		args = append(args, "-define", "webp:alpha-quality=100")
+	case !opts.HasAlpha:
+		args = append(args, "-alpha", "off")
+	}
+
+	args = append(args, "-quality", fmt.Sprintf("%d", opts.Quality), string(opts.Format)+":"+outputPath)
+
+	cmd := exec.Command(b.convertPath, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("imagemagick failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// Normalize writes an upright sRGB PNG of the first frame. An embedded ICC
+// profile is converted to the configured sRGB profile; without either,
+// ImageMagick's built-in colorspace conversion is used.
+func (b *ImageMagickBackend) Normalize(inputPath, outputPath string, info *ImageInfo) error {
+	args := []string{inputPath + "[0]", "-auto-orient"}
+	if info.Profile != "" && fileExists(b.srgbProfilePath) {
+		args = append(args, "-profile", b.srgbProfilePath)
+	} else {
+		args = append(args, "-colorspace", "sRGB")
+	}
+	args = append(args, "-strip", "png:"+outputPath)
+
+	cmd := exec.Command(b.convertPath, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("imagemagick failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// ExtractFrames writes each frame of an animation, composed onto the frames
+// before it, as a PNG.
+func (b *ImageMagickBackend) ExtractFrames(inputPath, outputDir string) ([]string, error) {
+	cmd := exec.Command(b.convertPath, inputPath, "-coalesce", "png:"+filepath.Join(outputDir, "frame-%05d.png"))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("imagemagick failed: %w, output: %s", err, string(output))
+	}
+
+	frames, err := filepath.Glob(filepath.Join(outputDir, "frame-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(frames)
+	return frames, nil
+}
+
+func (b *ImageMagickBackend) CanEncode(format models.ImageFormat) bool {
+	return true
+}
+
+func (b *ImageMagickBackend) CanDecode(format SourceFormat) bool {
+	return format != SourceUnknown
+}
+
+func geometryArgs(variant models.ImageVariantSpec, hasAlpha bool) ([]string, error) {
+	if variant.Width == 0 && variant.Height == 0 {
+		return nil, nil
+	}
+
+	size := fmt.Sprintf("%dx%d", variant.Width, variant.Height)
+	if variant.Height == 0 {
+		size = fmt.Sprintf("%d", variant.Width)
+	} else if variant.Width == 0 {
+		size = fmt.Sprintf("x%d", variant.Height)
+	}
+
+	background := "white"
+	if hasAlpha {
+		background = "none"
+	}
+
+	switch variant.Fit {
+	case models.FitCover:
+		return []string{"-resize", size + "^", "-gravity", "center", "-extent", size}, nil
+	case models.FitContain:
+		return []string{"-resize", size, "-background", background, "-gravity", "center", "-extent", size}, nil
+	case models.FitFill:
+		return []string{"-resize", size + "!"}, nil
+	case models.FitInside, "":
+		return []string{"-resize", size}, nil
+	default:
+		return nil, fmt.Errorf("unsupported fit mode: %s", variant.Fit)
+	}
+}
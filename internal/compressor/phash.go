@@ -0,0 +1,96 @@
+package compressor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	phashSize    = 32
+	phashLowFreq = 8
+)
+
+// PerceptualHash loads a small preview of an image and returns its pHash.
+func (i *ImageCompressor) PerceptualHash(imagePath string) (uint64, error) {
+	preview, err := i.backend.Preview(imagePath, 2*phashSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load image sample: %w", err)
+	}
+	return PHash(preview), nil
+}
+
+// PHash computes a 64-bit DCT perceptual hash: the image is reduced to a
+// 32x32 luma grid, and each bit records whether one of the 8x8 lowest
+// frequencies is above their median. Visually similar images differ in few
+// bits.
+func PHash(img image.Image) uint64 {
+	small := image.NewRGBA(image.Rect(0, 0, phashSize, phashSize))
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+	var pixels [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			c := color.GrayModel.Convert(small.At(x, y)).(color.Gray)
+			pixels[y][x] = float64(c.Y)
+		}
+	}
+
+	coefficients := dct2D(pixels)
+
+	values := make([]float64, 0, phashLowFreq*phashLowFreq)
+	for v := 0; v < phashLowFreq; v++ {
+		for u := 0; u < phashLowFreq; u++ {
+			values = append(values, coefficients[v][u])
+		}
+	}
+
+	// The DC term only carries overall brightness and is left out of the
+	// median.
+	sorted := append([]float64(nil), values[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, value := range values {
+		if value > median {
+			hash |= 1 << uint(len(values)-1-i)
+		}
+	}
+	return hash
+}
+
+func dct2D(pixels [phashSize][phashSize]float64) [phashSize][phashSize]float64 {
+	var cosines [phashSize][phashSize]float64
+	for k := 0; k < phashSize; k++ {
+		for n := 0; n < phashSize; n++ {
+			cosines[k][n] = math.Cos(math.Pi / phashSize * (float64(n) + 0.5) * float64(k))
+		}
+	}
+
+	var rows, out [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for u := 0; u < phashLowFreq; u++ {
+			var sum float64
+			for x := 0; x < phashSize; x++ {
+				sum += pixels[y][x] * cosines[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	for u := 0; u < phashLowFreq; u++ {
+		for v := 0; v < phashLowFreq; v++ {
+			var sum float64
+			for y := 0; y < phashSize; y++ {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			out[v][u] = sum
+		}
+	}
+	return out
+}
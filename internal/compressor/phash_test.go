@@ -0,0 +1,63 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// scene is a gradient with a dark disc and a light bar on it.
+func scene(width, height int, flip bool) *image.NRGBA {
+	img := gradient(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			if flip {
+				fx = 1 - fx
+			}
+			switch {
+			case (fx-0.3)*(fx-0.3)+(fy-0.4)*(fy-0.4) < 0.04:
+				img.SetNRGBA(x, y, color.NRGBA{20, 20, 30, 255})
+			case fx > 0.6 && fx < 0.9 && fy > 0.6 && fy < 0.75:
+				img.SetNRGBA(x, y, color.NRGBA{240, 240, 220, 255})
+			}
+		}
+	}
+	return img
+}
+
+func resized(img image.Image, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func TestPHash(t *testing.T) {
+	original := scene(640, 480, false)
+	hash := PHash(original)
+	if hash == 0 || hash == ^uint64(0) {
+		t.Fatalf("PHash = %016x, want a mix of bits", hash)
+	}
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		maxDistance int
+		minDistance int
+	}{
+		{"same image", original, 0, 0},
+		{"resized copy", resized(original, 320, 240), 4, 0},
+		{"thumbnail", resized(original, 96, 72), 6, 0},
+		{"noisy copy", noisy(original, 6), 4, 0},
+		{"mirrored", scene(640, 480, true), 64, 16},
+	}
+	for _, tt := range tests {
+		distance := bits.OnesCount64(hash ^ PHash(tt.img))
+		if distance > tt.maxDistance || distance < tt.minDistance {
+			t.Errorf("%s: Hamming distance %d, want %d to %d", tt.name, distance, tt.minDistance, tt.maxDistance)
+		}
+	}
+}
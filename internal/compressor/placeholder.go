@@ -0,0 +1,208 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const (
+	placeholderSampleSize = 64
+	lqipSize              = 16
+	lqipQuality           = 40
+	blurHashComponentsX   = 4
+	blurHashComponentsY   = 3
+)
+
+// Placeholder computes the loading placeholders for an image: a BlurHash, a
+// tiny inline JPEG and the dominant and average colours.
+func (i *ImageCompressor) Placeholder(imagePath string) (*models.ImagePlaceholder, error) {
+	sample, err := i.backend.Preview(imagePath, placeholderSampleSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image sample: %w", err)
+	}
+
+	lqip, err := encodeLQIP(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	average, dominant := colorStats(sample)
+
+	return &models.ImagePlaceholder{
+		BlurHash:      encodeBlurHash(sample, blurHashComponentsX, blurHashComponentsY),
+		LQIP:          lqip,
+		DominantColor: hexColor(dominant),
+		AverageColor:  hexColor(average),
+	}, nil
+}
+
+func encodeLQIP(img image.Image) (string, error) {
+	bounds := img.Bounds()
+	scale := math.Min(1, float64(lqipSize)/float64(max(bounds.Dx(), bounds.Dy())))
+	dst := image.NewRGBA(image.Rect(0, 0, scaled(bounds.Dx(), scale), scaled(bounds.Dy(), scale)))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: lqipQuality}); err != nil {
+		return "", fmt.Errorf("failed to encode LQIP: %w", err)
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// colorStats returns the mean colour and the mean of the most populated
+// 4-bit-per-channel bucket. Mostly transparent pixels are ignored.
+func colorStats(img image.Image) (color.RGBA, color.RGBA) {
+	type bucket struct {
+		r, g, b, n uint64
+	}
+	buckets := make(map[uint16]*bucket)
+	var total bucket
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+
+			key := uint16(c.R>>4)<<8 | uint16(c.G>>4)<<4 | uint16(c.B>>4)
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			for _, b := range []*bucket{bk, &total} {
+				b.r += uint64(c.R)
+				b.g += uint64(c.G)
+				b.b += uint64(c.B)
+				b.n++
+			}
+		}
+	}
+
+	mean := func(b *bucket) color.RGBA {
+		if b == nil || b.n == 0 {
+			return color.RGBA{255, 255, 255, 255}
+		}
+		return color.RGBA{uint8(b.r / b.n), uint8(b.g / b.n), uint8(b.b / b.n), 255}
+	}
+
+	var dominant *bucket
+	for _, b := range buckets {
+		if dominant == nil || b.n > dominant.n {
+			dominant = b
+		}
+	}
+
+	return mean(&total), mean(dominant)
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// encodeBlurHash implements the reference BlurHash encoder
+// (https://github.com/woltapp/blurhash).
+func encodeBlurHash(img image.Image, componentsX, componentsY int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	factors := make([][3]float64, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					r += basis * srgbToLinear(c.R)
+					g += basis * srgbToLinear(c.G)
+					b += basis * srgbToLinear(c.B)
+				}
+			}
+
+			scale := 1.0 / float64(width*height)
+			factors[j*componentsX+i] = [3]float64{r * scale, g * scale, b * scale}
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, f := range ac {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dcValue := linearToSRGB(dc[0])<<16 + linearToSRGB(dc[1])<<8 + linearToSRGB(dc[2])
+	hash.WriteString(encodeBase83(dcValue, 4))
+
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encodeBase83(value, length int) string {
+	out := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		out[i-1] = base83Chars[digit]
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	c := math.Max(0, math.Min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
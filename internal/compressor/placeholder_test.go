@@ -0,0 +1,101 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradient is a red to blue ramp from left to right, with green rising from
+// top to bottom.
+func gradient(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r := uint8(x * 255 / (width - 1))
+			img.SetNRGBA(x, y, color.NRGBA{r, uint8(y * 255 / (height - 1)), 255 - r, 255})
+		}
+	}
+	return img
+}
+
+func solid(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeBlurHash(t *testing.T) {
+	// The same gradient inside a larger image, away from its origin.
+	large := image.NewNRGBA(image.Rect(0, 0, 12, 10))
+	small := gradient(8, 6)
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			large.SetNRGBA(x+2, y+3, small.NRGBAAt(x, y))
+		}
+	}
+	offset := large.SubImage(image.Rect(2, 3, 10, 9))
+
+	tests := []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		{"gradient", gradient(8, 6), "L~I5e~7jfXxvu^RqfTnTeqf7fQf7"},
+		{"solid red", solid(8, 6, color.NRGBA{255, 0, 0, 255}), "LsTI:j]9fQ]9|csUfQsUfQfQfQfQ"},
+		{"sub-image", offset, "L~I5e~7jfXxvu^RqfTnTeqf7fQf7"},
+	}
+	for _, tt := range tests {
+		if got := encodeBlurHash(tt.img, 4, 3); got != tt.want {
+			t.Errorf("%s: encodeBlurHash = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeBase83(t *testing.T) {
+	tests := []struct {
+		value  int
+		length int
+		want   string
+	}{
+		{0, 1, "0"},
+		{21, 1, "L"},
+		{82, 1, "~"},
+		{255 << 16, 4, "TI:j"},
+		{83*83 - 1, 2, "~~"},
+	}
+	for _, tt := range tests {
+		if got := encodeBase83(tt.value, tt.length); got != tt.want {
+			t.Errorf("encodeBase83(%d, %d) = %q, want %q", tt.value, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestColorStats(t *testing.T) {
+	img := solid(4, 4, color.NRGBA{200, 40, 40, 255})
+	// A quarter of the image is blue, and one transparent pixel is ignored.
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{40, 40, 200, 255})
+		}
+	}
+	img.SetNRGBA(3, 3, color.NRGBA{0, 255, 0, 10})
+
+	average, dominant := colorStats(img)
+	if got := hexColor(dominant); got != "#c82828" {
+		t.Errorf("dominant = %s, want #c82828", got)
+	}
+	// 11 red and 4 blue pixels.
+	if got := hexColor(average); got != "#9d2852" {
+		t.Errorf("average = %s, want #9d2852", got)
+	}
+
+	average, dominant = colorStats(solid(2, 2, color.NRGBA{}))
+	if hexColor(average) != "#ffffff" || hexColor(dominant) != "#ffffff" {
+		t.Errorf("transparent image = %s, %s, want white", hexColor(average), hexColor(dominant))
+	}
+}
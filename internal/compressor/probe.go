@@ -0,0 +1,99 @@
+package compressor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+// VideoLimits bounds the videos Inspect accepts. Zero disables a limit.
+// MaxDuration and MaxFrames also bound animated images converted to video.
+type VideoLimits struct {
+	MaxDuration  float64
+	MaxDimension int
+	MaxStreams   int
+	MaxFrames    int
+}
+
+type VideoProbe struct {
+	Duration float64
+	Width    int
+	Height   int
+	Streams  int
+}
+
+// Inspect reads the container and stream headers with ffprobe and rejects
+// videos that break the configured limits before any decoding starts.
+func (v *VideoCompressor) Inspect(inputPath string) (*VideoProbe, error) {
+	probe, err := v.Probe(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := v.limits
+	switch {
+	case limits.MaxDuration > 0 && probe.Duration > limits.MaxDuration:
+		return nil, fmt.Errorf("%w: video is %.0f seconds long, the limit is %.0f seconds", models.ErrLimitExceeded, probe.Duration, limits.MaxDuration)
+	case limits.MaxDimension > 0 && (probe.Width > limits.MaxDimension || probe.Height > limits.MaxDimension):
+		return nil, fmt.Errorf("%w: video is %dx%d, the largest side allowed is %d pixels", models.ErrLimitExceeded, probe.Width, probe.Height, limits.MaxDimension)
+	case limits.MaxStreams > 0 && probe.Streams > limits.MaxStreams:
+		return nil, fmt.Errorf("%w: video has %d streams, the limit is %d", models.ErrLimitExceeded, probe.Streams, limits.MaxStreams)
+	}
+
+	return probe, nil
+}
+
+// CheckAnimation applies the limits to an animated image before it is
+// encoded. Frames can be shown for as little as 20ms, so their number is
+// limited as well as the total duration.
+func (v *VideoCompressor) CheckAnimation(animation *Animation) error {
+	limits := v.limits
+	duration := animation.Duration().Seconds()
+	switch {
+	case limits.MaxDuration > 0 && duration > limits.MaxDuration:
+		return fmt.Errorf("%w: animation is %.0f seconds long, the limit is %.0f seconds", models.ErrLimitExceeded, duration, limits.MaxDuration)
+	case limits.MaxFrames > 0 && animation.Frames() > limits.MaxFrames:
+		return fmt.Errorf("%w: animation has %d frames, the limit is %d", models.ErrLimitExceeded, animation.Frames(), limits.MaxFrames)
+	}
+	return nil
+}
+
+func (v *VideoCompressor) Probe(inputPath string) (*VideoProbe, error) {
+	cmd := exec.Command(v.ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration:stream=codec_type,width,height",
+		"-of", "json",
+		inputPath,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var parsed struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	probe := &VideoProbe{Streams: len(parsed.Streams)}
+	probe.Duration, _ = strconv.ParseFloat(parsed.Format.Duration, 64)
+	for _, stream := range parsed.Streams {
+		if stream.CodecType == "video" && stream.Width*stream.Height > probe.Width*probe.Height {
+			probe.Width, probe.Height = stream.Width, stream.Height
+		}
+	}
+
+	return probe, nil
+}
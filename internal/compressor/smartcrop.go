@@ -0,0 +1,177 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const (
+	saliencyGridSize   = 48
+	saliencyHistBins   = 16
+	saliencyEdgeScale  = 4.0
+	saliencyCenterBias = 0.15
+)
+
+// SaliencyMap scores a coarse grid laid over the source image by how much
+// detail each cell holds: edge strength plus luminance entropy. Crop windows
+// are then placed where the summed score is highest.
+type SaliencyMap struct {
+	srcWidth, srcHeight int
+	cols, rows          int
+	integral            []float64
+}
+
+func NewSaliencyMap(img image.Image, srcWidth, srcHeight int) *SaliencyMap {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			g := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			luma[y*w+x] = float64(g.Y)
+		}
+	}
+
+	cols, rows := saliencyGridSize, saliencyGridSize
+	if w < cols {
+		cols = w
+	}
+	if h < rows {
+		rows = h
+	}
+
+	edges := make([]float64, cols*rows)
+	hists := make([][saliencyHistBins]float64, cols*rows)
+	counts := make([]float64, cols*rows)
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := luma[(y-1)*w+x+1] + 2*luma[y*w+x+1] + luma[(y+1)*w+x+1] -
+				luma[(y-1)*w+x-1] - 2*luma[y*w+x-1] - luma[(y+1)*w+x-1]
+			gy := luma[(y+1)*w+x-1] + 2*luma[(y+1)*w+x] + luma[(y+1)*w+x+1] -
+				luma[(y-1)*w+x-1] - 2*luma[(y-1)*w+x] - luma[(y-1)*w+x+1]
+
+			cell := (y*rows/h)*cols + x*cols/w
+			edges[cell] += math.Sqrt(gx*gx + gy*gy)
+			hists[cell][int(luma[y*w+x])*saliencyHistBins/256]++
+			counts[cell]++
+		}
+	}
+
+	scores := make([]float64, cols*rows)
+	for i := range scores {
+		if counts[i] == 0 {
+			continue
+		}
+		var entropy float64
+		for _, n := range hists[i] {
+			if n > 0 {
+				p := n / counts[i]
+				entropy -= p * math.Log2(p)
+			}
+		}
+		scores[i] = edges[i]/counts[i]/255*saliencyEdgeScale + entropy/math.Log2(saliencyHistBins)
+
+		cx := (float64(i%cols)+0.5)/float64(cols) - 0.5
+		cy := (float64(i/cols)+0.5)/float64(rows) - 0.5
+		scores[i] *= 1 - saliencyCenterBias*math.Sqrt(cx*cx+cy*cy)*2
+	}
+
+	integral := make([]float64, (cols+1)*(rows+1))
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			integral[(y+1)*(cols+1)+x+1] = scores[y*cols+x] +
+				integral[y*(cols+1)+x+1] + integral[(y+1)*(cols+1)+x] - integral[y*(cols+1)+x]
+		}
+	}
+
+	return &SaliencyMap{
+		srcWidth:  srcWidth,
+		srcHeight: srcHeight,
+		cols:      cols,
+		rows:      rows,
+		integral:  integral,
+	}
+}
+
+// BestCrop returns the cropWidth x cropHeight window, in source pixels, that
+// covers the most salient part of the image.
+func (m *SaliencyMap) BestCrop(cropWidth, cropHeight int) image.Rectangle {
+	cellW := float64(m.srcWidth) / float64(m.cols)
+	cellH := float64(m.srcHeight) / float64(m.rows)
+
+	spanX := int(math.Round(float64(cropWidth) / cellW))
+	spanY := int(math.Round(float64(cropHeight) / cellH))
+	if spanX < 1 {
+		spanX = 1
+	}
+	if spanY < 1 {
+		spanY = 1
+	}
+	if spanX > m.cols {
+		spanX = m.cols
+	}
+	if spanY > m.rows {
+		spanY = m.rows
+	}
+
+	bestX, bestY, bestScore := 0, 0, -1.0
+	for y := 0; y+spanY <= m.rows; y++ {
+		for x := 0; x+spanX <= m.cols; x++ {
+			score := m.sum(x, y, x+spanX, y+spanY)
+			if score > bestScore {
+				bestX, bestY, bestScore = x, y, score
+			}
+		}
+	}
+
+	centerX := (float64(bestX) + float64(spanX)/2) * cellW
+	centerY := (float64(bestY) + float64(spanY)/2) * cellH
+	return placeWindow(m.srcWidth, m.srcHeight, cropWidth, cropHeight, centerX, centerY)
+}
+
+func (m *SaliencyMap) sum(x0, y0, x1, y1 int) float64 {
+	stride := m.cols + 1
+	return m.integral[y1*stride+x1] - m.integral[y0*stride+x1] - m.integral[y1*stride+x0] + m.integral[y0*stride+x0]
+}
+
+// FocalCrop centres the crop window on a focal point given in percent of the
+// source width and height, sliding it back inside the image where needed.
+func FocalCrop(srcWidth, srcHeight, cropWidth, cropHeight int, focal models.FocalPoint) image.Rectangle {
+	return placeWindow(srcWidth, srcHeight, cropWidth, cropHeight,
+		focal.X/100*float64(srcWidth), focal.Y/100*float64(srcHeight))
+}
+
+// CoverCropSize is the largest window with the target aspect ratio that fits
+// inside the source.
+func CoverCropSize(srcWidth, srcHeight, targetWidth, targetHeight int) (int, int) {
+	targetAspect := float64(targetWidth) / float64(targetHeight)
+	if float64(srcWidth)/float64(srcHeight) > targetAspect {
+		return int(math.Round(float64(srcHeight) * targetAspect)), srcHeight
+	}
+	return srcWidth, int(math.Round(float64(srcWidth) / targetAspect))
+}
+
+func placeWindow(srcWidth, srcHeight, cropWidth, cropHeight int, centerX, centerY float64) image.Rectangle {
+	x := int(math.Round(centerX - float64(cropWidth)/2))
+	y := int(math.Round(centerY - float64(cropHeight)/2))
+
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	if x+cropWidth > srcWidth {
+		x = srcWidth - cropWidth
+	}
+	if y+cropHeight > srcHeight {
+		y = srcHeight - cropHeight
+	}
+
+	return image.Rect(x, y, x+cropWidth, y+cropHeight)
+}
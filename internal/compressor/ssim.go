@@ -0,0 +1,90 @@
+package compressor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	ssimWindow = 8
+	ssimStride = 4
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// SSIM returns the mean structural similarity of the luma planes of two
+// equally sized images, computed over 8x8 windows. 1.0 means identical.
+func SSIM(a, b image.Image) (float64, error) {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return 0, fmt.Errorf("image sizes differ: %dx%d vs %dx%d", ab.Dx(), ab.Dy(), bb.Dx(), bb.Dy())
+	}
+
+	width, height := ab.Dx(), ab.Dy()
+	la, lb := lumaPlane(a), lumaPlane(b)
+
+	if width < ssimWindow || height < ssimWindow {
+		return ssimWindowScore(la, lb, width, 0, 0, width, height), nil
+	}
+
+	var total float64
+	var count int
+	for y := 0; y+ssimWindow <= height; y += ssimStride {
+		for x := 0; x+ssimWindow <= width; x += ssimStride {
+			total += ssimWindowScore(la, lb, width, x, y, ssimWindow, ssimWindow)
+			count++
+		}
+	}
+
+	return total / float64(count), nil
+}
+
+func ssimWindowScore(a, b []float64, stride, x0, y0, w, h int) float64 {
+	n := float64(w * h)
+
+	var sumA, sumB float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			sumA += a[y*stride+x]
+			sumB += b[y*stride+x]
+		}
+	}
+	meanA, meanB := sumA/n, sumB/n
+
+	var varA, varB, cov float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			da := a[y*stride+x] - meanA
+			db := b[y*stride+x] - meanB
+			varA += da * da
+			varB += db * db
+			cov += da * db
+		}
+	}
+	varA /= n - 1
+	varB /= n - 1
+	cov /= n - 1
+
+	return ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
+
+// lumaPlane flattens an image onto white and returns its luma values.
+func lumaPlane(img image.Image) []float64 {
+	bounds := img.Bounds()
+	out := make([]float64, bounds.Dx()*bounds.Dy())
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			alpha := float64(c.A) / 255
+			r := float64(c.R)*alpha + 255*(1-alpha)
+			g := float64(c.G)*alpha + 255*(1-alpha)
+			b := float64(c.B)*alpha + 255*(1-alpha)
+			out[i] = 0.299*r + 0.587*g + 0.114*b
+			i++
+		}
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package compressor
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// noisy adds a fixed pattern of noise with the given amplitude to the luma
+// of img.
+func noisy(img *image.NRGBA, amplitude float64) *image.NRGBA {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			delta := amplitude * float64((x*7+y*13)%11-5) / 5
+			shift := func(v uint8) uint8 {
+				return uint8(math.Max(0, math.Min(255, float64(v)+delta)))
+			}
+			out.SetNRGBA(x, y, color.NRGBA{shift(c.R), shift(c.G), shift(c.B), c.A})
+		}
+	}
+	return out
+}
+
+func TestSSIM(t *testing.T) {
+	reference := gradient(32, 24)
+
+	if score, err := SSIM(reference, reference); err != nil || math.Abs(score-1) > 1e-9 {
+		t.Errorf("SSIM of an image with itself = %v, %v, want 1", score, err)
+	}
+
+	previous := 1.0
+	for _, amplitude := range []float64{2, 8, 32} {
+		score, err := SSIM(reference, noisy(reference, amplitude))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if score >= previous {
+			t.Errorf("SSIM with noise %v = %v, not below %v for less noise", amplitude, score, previous)
+		}
+		previous = score
+	}
+
+	// Transparent pixels are compared as white.
+	white := solid(8, 8, color.NRGBA{255, 255, 255, 255})
+	clear := solid(8, 8, color.NRGBA{0, 0, 0, 0})
+	if score, _ := SSIM(white, clear); math.Abs(score-1) > 1e-9 {
+		t.Errorf("SSIM of white and transparent = %v, want 1", score)
+	}
+
+	// Images smaller than a window are compared as a whole.
+	if score, err := SSIM(gradient(4, 4), gradient(4, 4)); err != nil || math.Abs(score-1) > 1e-9 {
+		t.Errorf("SSIM of a small image with itself = %v, %v, want 1", score, err)
+	}
+
+	if _, err := SSIM(reference, gradient(24, 32)); err == nil {
+		t.Error("SSIM of images of different sizes did not fail")
+	}
+}
@@ -5,25 +5,28 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
 
 	"github.com/yourusername/video-compressor/internal/models"
 )
 
+// VideoCompressor runs ffmpeg. Outputs are written under fixed names to the
+// outputDir each call is given, so every job passes a directory of its own.
 type VideoCompressor struct {
-	ffmpegPath string
-	tempDir    string
+	ffmpegPath  string
+	ffprobePath string
+	limits      VideoLimits
 }
 
-func NewVideoCompressor(ffmpegPath, tempDir string) *VideoCompressor {
+func NewVideoCompressor(ffmpegPath, ffprobePath string, limits VideoLimits) *VideoCompressor {
 	return &VideoCompressor{
-		ffmpegPath: ffmpegPath,
-		tempDir:    tempDir,
+		ffmpegPath:  ffmpegPath,
+		ffprobePath: ffprobePath,
+		limits:      limits,
 	}
 }
 
-func (v *VideoCompressor) Compress(inputPath string, quality models.VideoQuality) (string, error) {
-	outputPath := filepath.Join(v.tempDir, fmt.Sprintf("compressed_%d.mp4", time.Now().Unix()))
+func (v *VideoCompressor) Compress(inputPath, outputDir string, quality models.VideoQuality) (string, error) {
+	outputPath := filepath.Join(outputDir, "compressed.mp4")
 
 	var args []string
 	args = append(args, "-i", inputPath)
@@ -52,8 +55,8 @@ func (v *VideoCompressor) Compress(inputPath string, quality models.VideoQuality
 	return outputPath, nil
 }
 
-func (v *VideoCompressor) GenerateHLS(inputPath string, variants []string) (string, map[string]string, error) {
-	hlsDir := filepath.Join(v.tempDir, fmt.Sprintf("hls_%d", time.Now().Unix()))
+func (v *VideoCompressor) GenerateHLS(inputPath, outputDir string, variants []string) (string, map[string]string, error) {
+	hlsDir := filepath.Join(outputDir, "hls")
 	if err := os.MkdirAll(hlsDir, 0755); err != nil {
 		return "", nil, fmt.Errorf("failed to create HLS directory: %w", err)
 	}
@@ -122,6 +125,91 @@ func (v *VideoCompressor) GenerateHLS(inputPath string, variants []string) (stri
 	return masterPlaylist, variantURLs, nil
 }
 
+// ConvertAnimation turns an animated image into a muted video or an animated
+// WebP. Supported formats are "mp4", "webm" and "webp". inputPath may also be
+// an ffconcat list written by ImageCompressor.ExtractFrames.
+func (v *VideoCompressor) ConvertAnimation(inputPath, outputDir, format string) (string, error) {
+	outputPath := filepath.Join(outputDir, "animation."+format)
+
+	args := []string{"-i", inputPath}
+	if filepath.Ext(inputPath) == ".ffconcat" {
+		args = []string{"-f", "concat", "-safe", "0", "-i", inputPath}
+	}
+	switch format {
+	case "mp4":
+		args = append(args, "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-pix_fmt", "yuv420p",
+			"-c:v", "libx264", "-crf", "23", "-preset", "slow", "-movflags", "+faststart")
+	case "webm":
+		args = append(args, "-pix_fmt", "yuva420p", "-c:v", "libvpx-vp9", "-crf", "35", "-b:v", "0")
+	case "webp":
+		args = append(args, "-c:v", "libwebp_anim", "-lossless", "0", "-q:v", "75", "-loop", "0")
+	default:
+		return "", fmt.Errorf("unsupported animation format: %s", format)
+	}
+	args = append(args, "-an", "-y", outputPath)
+
+	cmd := exec.Command(v.ffmpegPath, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg animation conversion failed: %w, output: %s", err, string(output))
+	}
+
+	return outputPath, nil
+}
+
+func (v *VideoCompressor) ExtractPoster(inputPath, outputDir string) (string, error) {
+	outputPath := filepath.Join(outputDir, "poster.jpg")
+
+	// Seek a second in to skip black or fade-in frames; very short clips fall
+	// back to the first frame.
+	for _, offset := range []string{"1", "0"} {
+		cmd := exec.Command(v.ffmpegPath, "-ss", offset, "-i", inputPath, "-frames:v", "1", "-q:v", "2", "-y", outputPath)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("ffmpeg poster failed: %w, output: %s", err, string(output))
+		}
+		if info, err := os.Stat(outputPath); err == nil && info.Size() > 0 {
+			return outputPath, nil
+		}
+	}
+
+	return "", fmt.Errorf("ffmpeg produced no poster frame")
+}
+
+// ExtractKeyframes saves the first keyframe at or after each of count evenly
+// spaced points in the video, as small JPEGs for hashing.
+func (v *VideoCompressor) ExtractKeyframes(inputPath, outputDir string, duration float64, count int) ([]string, error) {
+	var frames []string
+	for i := 0; i < count; i++ {
+		offset := duration * (float64(i) + 0.5) / float64(count)
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("keyframe_%d.jpg", i))
+
+		cmd := exec.Command(v.ffmpegPath,
+			"-skip_frame", "nokey",
+			"-ss", fmt.Sprintf("%.3f", offset),
+			"-i", inputPath,
+			"-frames:v", "1",
+			"-vf", "scale=128:-2",
+			"-y", outputPath,
+		)
+		output, err := cmd.CombinedOutput()
+		if err == nil {
+			if info, statErr := os.Stat(outputPath); statErr != nil || info.Size() == 0 {
+				err = fmt.Errorf("no frame at %.1fs", offset)
+			}
+		}
+		if err != nil {
+			for _, frame := range frames {
+				os.Remove(frame)
+			}
+			return nil, fmt.Errorf("ffmpeg keyframe extraction failed: %w, output: %s", err, string(output))
+		}
+		frames = append(frames, outputPath)
+	}
+
+	return frames, nil
+}
+
 func (v *VideoCompressor) GetVideoInfo(inputPath string) (int64, error) {
 	info, err := os.Stat(inputPath)
 	if err != nil {
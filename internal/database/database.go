@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/yourusername/video-compressor/internal/models"
 )
 
@@ -38,28 +38,59 @@ func (d *Database) Close() error {
 func (d *Database) CreateJob(job *models.Job) error {
 	query := `
 		INSERT INTO jobs (
-			job_id, post_id, user_id, compression_type,
-			video_file_url, video_quality, video_hls_enabled, video_hls_variants,
-			image_file_url, image_quality, image_variants,
+			job_id, tenant_id, post_id, user_id, compression_type,
+			video_file_url, video_quality, video_hls_enabled, video_hls_variants, video_title, video_sha256, video_upload_id,
+			image_file_url, image_quality, image_variants, image_formats,
+			image_variant_specs, image_srcset_widths, image_focal_x, image_focal_y,
+			image_quality_mode, image_target_score, image_title, image_alt_text, image_sha256, image_upload_id,
+			reuse_duplicate, duplicate_max_distance, storage_backend, storage_mode, attachment_id, output_naming, source_size,
 			priority, status, video_status, image_status,
 			scheduled_time, max_retries
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38, $39)
 		RETURNING id, created_at, updated_at
 	`
 
-	var videoFileURL, videoQuality *string
+	var videoFileURL, videoQuality, videoTitle, videoSHA256, videoUploadID *string
 	var videoHLSEnabled *bool
 	var videoHLSVariants interface{}
 	var imageFileURL, imageQuality *string
-	var imageVariants interface{}
-
+	var imageVariants, imageFormats, imageVariantSpecs, imageSrcsetWidths interface{}
+	var imageFocalX, imageFocalY, imageTargetScore *float64
+	var imageQualityMode, imageTitle, imageAltText, imageSHA256, imageUploadID *string
+	var tenantID, storageBackend, storageMode, outputNaming *string
+	var sourceSize *int64
+	if job.SourceSize > 0 {
+		sourceSize = &job.SourceSize
+	}
+	if job.TenantID != "" {
+		tenantID = &job.TenantID
+	}
+	if job.OutputNaming != "" {
+		outputNaming = &job.OutputNaming
+	}
+	if job.StorageBackend != "" {
+		storageBackend = &job.StorageBackend
+	}
+	if job.StorageMode != "" {
+		mode := string(job.StorageMode)
+		storageMode = &mode
+	}
 	if job.VideoData != nil {
 		videoFileURL = &job.VideoData.FileURL
 		q := string(job.VideoData.Quality)
 		videoQuality = &q
 		videoHLSEnabled = &job.VideoData.HLSEnabled
+		if job.VideoData.Title != "" {
+			videoTitle = &job.VideoData.Title
+		}
+		if job.VideoData.SHA256 != "" {
+			videoSHA256 = &job.VideoData.SHA256
+		}
+		if job.VideoData.UploadID != "" {
+			videoUploadID = &job.VideoData.UploadID
+		}
 		if len(job.VideoData.HLSVariants) > 0 {
-			videoHLSVariants = job.VideoData.HLSVariants
+			videoHLSVariants = pq.Array(job.VideoData.HLSVariants)
 		}
 	}
 
@@ -68,15 +99,61 @@ func (d *Database) CreateJob(job *models.Job) error {
 		q := string(job.ImageData.Quality)
 		imageQuality = &q
 		if len(job.ImageData.Variants) > 0 {
-			imageVariants = job.ImageData.Variants
+			names := make([]string, len(job.ImageData.Variants))
+			for i, v := range job.ImageData.Variants {
+				names[i] = v.Name
+			}
+			imageVariants = pq.Array(names)
+
+			specsJSON, err := json.Marshal(job.ImageData.Variants)
+			if err != nil {
+				return fmt.Errorf("failed to encode image variants: %w", err)
+			}
+			imageVariantSpecs = specsJSON
+		}
+		if len(job.ImageData.SrcsetWidths) > 0 {
+			imageSrcsetWidths = pq.Array(job.ImageData.SrcsetWidths)
+		}
+		if job.ImageData.FocalPoint != nil {
+			imageFocalX = &job.ImageData.FocalPoint.X
+			imageFocalY = &job.ImageData.FocalPoint.Y
+		}
+		if job.ImageData.QualityMode != "" {
+			mode := string(job.ImageData.QualityMode)
+			imageQualityMode = &mode
+		}
+		if job.ImageData.TargetScore != 0 {
+			imageTargetScore = &job.ImageData.TargetScore
+		}
+		if job.ImageData.Title != "" {
+			imageTitle = &job.ImageData.Title
+		}
+		if job.ImageData.AltText != "" {
+			imageAltText = &job.ImageData.AltText
+		}
+		if job.ImageData.SHA256 != "" {
+			imageSHA256 = &job.ImageData.SHA256
+		}
+		if job.ImageData.UploadID != "" {
+			imageUploadID = &job.ImageData.UploadID
+		}
+		if len(job.ImageData.Formats) > 0 {
+			formats := make([]string, len(job.ImageData.Formats))
+			for i, f := range job.ImageData.Formats {
+				formats[i] = string(f)
+			}
+			imageFormats = pq.Array(formats)
 		}
 	}
 
 	err := d.db.QueryRow(
 		query,
-		job.JobID, job.PostID, job.UserID, job.CompressionType,
-		videoFileURL, videoQuality, videoHLSEnabled, videoHLSVariants,
-		imageFileURL, imageQuality, imageVariants,
+		job.JobID, tenantID, job.PostID, job.UserID, job.CompressionType,
+		videoFileURL, videoQuality, videoHLSEnabled, videoHLSVariants, videoTitle, videoSHA256, videoUploadID,
+		imageFileURL, imageQuality, imageVariants, imageFormats,
+		imageVariantSpecs, imageSrcsetWidths, imageFocalX, imageFocalY,
+		imageQualityMode, imageTargetScore, imageTitle, imageAltText, imageSHA256, imageUploadID,
+		job.ReuseDuplicate, job.DuplicateMaxDistance, storageBackend, storageMode, job.AttachmentID, outputNaming, sourceSize,
 		job.Priority, job.Status, job.VideoStatus, job.ImageStatus,
 		job.ScheduledTime, job.MaxRetries,
 	).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
@@ -91,10 +168,14 @@ func (d *Database) CreateJob(job *models.Job) error {
 func (d *Database) GetJobByID(jobID string) (*models.Job, error) {
 	query := `
 		SELECT 
-			id, job_id, post_id, user_id, compression_type,
-			video_file_url, video_quality, video_hls_enabled, video_hls_variants,
-			image_file_url, image_quality, image_variants,
+			id, job_id, tenant_id, post_id, user_id, compression_type,
+			video_file_url, video_quality, video_hls_enabled, video_hls_variants, video_title, video_sha256, video_upload_id,
+			image_file_url, image_quality, image_variants, image_formats,
+			image_variant_specs, image_srcset_widths, image_focal_x, image_focal_y,
+			image_quality_mode, image_target_score, image_title, image_alt_text, image_sha256, image_upload_id,
+			reuse_duplicate, duplicate_max_distance, storage_backend, storage_mode, attachment_id, output_naming, source_size,
 			priority, status, video_status, image_status,
+			video_step, image_step, video_upload_progress, image_upload_progress,
 			video_result, image_result, error_message,
 			created_at, updated_at, started_at, completed_at, scheduled_time,
 			retry_count, max_retries, processing_time
@@ -103,17 +184,30 @@ func (d *Database) GetJobByID(jobID string) (*models.Job, error) {
 
 	job := &models.Job{}
 	var videoFileURL, videoQuality, videoResult, imageFileURL, imageQuality, imageResult, errorMessage sql.NullString
+	var imageVariantSpecs sql.NullString
+	var imageSrcsetWidths pq.Int64Array
+	var imageFocalX, imageFocalY, imageTargetScore sql.NullFloat64
+	var tenantID, imageQualityMode, storageBackend, storageMode, outputNaming sql.NullString
+	var attachmentID, sourceSize sql.NullInt64
+	var videoTitle, imageTitle, imageAltText sql.NullString
+	var videoSHA256, imageSHA256, videoUploadID, imageUploadID sql.NullString
 	var videoHLSEnabled sql.NullBool
-	var videoHLSVariants, imageVariants interface{}
-	var userID, processingTime sql.NullInt64
+	var videoHLSVariants, imageVariants, imageFormats []string
+	var userID, processingTime, duplicateMaxDistance sql.NullInt64
+	var reuseDuplicate sql.NullBool
 	var startedAt, completedAt, scheduledTime sql.NullTime
-	var videoStatus, imageStatus sql.NullString
+	var videoStatus, imageStatus, videoStep, imageStep sql.NullString
+	var videoUploadProgress, imageUploadProgress sql.NullInt64
 
 	err := d.db.QueryRow(query, jobID).Scan(
-		&job.ID, &job.JobID, &job.PostID, &userID, &job.CompressionType,
-		&videoFileURL, &videoQuality, &videoHLSEnabled, &videoHLSVariants,
-		&imageFileURL, &imageQuality, &imageVariants,
+		&job.ID, &job.JobID, &tenantID, &job.PostID, &userID, &job.CompressionType,
+		&videoFileURL, &videoQuality, &videoHLSEnabled, pq.Array(&videoHLSVariants), &videoTitle, &videoSHA256, &videoUploadID,
+		&imageFileURL, &imageQuality, pq.Array(&imageVariants), pq.Array(&imageFormats),
+		&imageVariantSpecs, &imageSrcsetWidths, &imageFocalX, &imageFocalY,
+		&imageQualityMode, &imageTargetScore, &imageTitle, &imageAltText, &imageSHA256, &imageUploadID,
+		&reuseDuplicate, &duplicateMaxDistance, &storageBackend, &storageMode, &attachmentID, &outputNaming, &sourceSize,
 		&job.Priority, &job.Status, &videoStatus, &imageStatus,
+		&videoStep, &imageStep, &videoUploadProgress, &imageUploadProgress,
 		&videoResult, &imageResult, &errorMessage,
 		&job.CreatedAt, &job.UpdatedAt, &startedAt, &completedAt, &scheduledTime,
 		&job.RetryCount, &job.MaxRetries, &processingTime,
@@ -130,17 +224,59 @@ func (d *Database) GetJobByID(jobID string) (*models.Job, error) {
 		uid := int(userID.Int64)
 		job.UserID = &uid
 	}
+	job.TenantID = tenantID.String
+	job.ReuseDuplicate = reuseDuplicate.Bool
+	job.StorageBackend = storageBackend.String
+	job.StorageMode = models.StorageMode(storageMode.String)
+	job.OutputNaming = outputNaming.String
+	job.SourceSize = sourceSize.Int64
+	if attachmentID.Valid {
+		id := int(attachmentID.Int64)
+		job.AttachmentID = &id
+	}
+	if duplicateMaxDistance.Valid {
+		distance := int(duplicateMaxDistance.Int64)
+		job.DuplicateMaxDistance = &distance
+	}
 	if videoFileURL.Valid {
 		job.VideoData = &models.VideoData{
-			FileURL:    videoFileURL.String,
-			Quality:    models.VideoQuality(videoQuality.String),
-			HLSEnabled: videoHLSEnabled.Bool,
+			FileURL:     videoFileURL.String,
+			Quality:     models.VideoQuality(videoQuality.String),
+			HLSEnabled:  videoHLSEnabled.Bool,
+			HLSVariants: videoHLSVariants,
+			Title:       videoTitle.String,
+			SHA256:      videoSHA256.String,
+			UploadID:    videoUploadID.String,
 		}
 	}
 	if imageFileURL.Valid {
 		job.ImageData = &models.ImageData{
-			FileURL: imageFileURL.String,
-			Quality: models.ImageQuality(imageQuality.String),
+			FileURL:     imageFileURL.String,
+			Quality:     models.ImageQuality(imageQuality.String),
+			QualityMode: models.QualityMode(imageQualityMode.String),
+			TargetScore: imageTargetScore.Float64,
+			Title:       imageTitle.String,
+			AltText:     imageAltText.String,
+			SHA256:      imageSHA256.String,
+			UploadID:    imageUploadID.String,
+		}
+		if imageVariantSpecs.Valid {
+			if err := json.Unmarshal([]byte(imageVariantSpecs.String), &job.ImageData.Variants); err != nil {
+				return nil, fmt.Errorf("failed to decode image variants: %w", err)
+			}
+		} else {
+			for _, name := range imageVariants {
+				job.ImageData.Variants = append(job.ImageData.Variants, models.ImageVariantPresets[name])
+			}
+		}
+		for _, w := range imageSrcsetWidths {
+			job.ImageData.SrcsetWidths = append(job.ImageData.SrcsetWidths, int(w))
+		}
+		if imageFocalX.Valid && imageFocalY.Valid {
+			job.ImageData.FocalPoint = &models.FocalPoint{X: imageFocalX.Float64, Y: imageFocalY.Float64}
+		}
+		for _, f := range imageFormats {
+			job.ImageData.Formats = append(job.ImageData.Formats, models.ImageFormat(f))
 		}
 	}
 	if videoStatus.Valid {
@@ -151,6 +287,22 @@ func (d *Database) GetJobByID(jobID string) (*models.Job, error) {
 		is := models.JobStatus(imageStatus.String)
 		job.ImageStatus = &is
 	}
+	if videoStep.Valid {
+		step := models.JobStep(videoStep.String)
+		job.VideoStep = &step
+	}
+	if imageStep.Valid {
+		step := models.JobStep(imageStep.String)
+		job.ImageStep = &step
+	}
+	if videoUploadProgress.Valid {
+		progress := int(videoUploadProgress.Int64)
+		job.VideoUploadProgress = &progress
+	}
+	if imageUploadProgress.Valid {
+		progress := int(imageUploadProgress.Int64)
+		job.ImageUploadProgress = &progress
+	}
 	if videoResult.Valid {
 		var vr models.VideoResult
 		if err := json.Unmarshal([]byte(videoResult.String), &vr); err == nil {
@@ -205,6 +357,17 @@ func (d *Database) UpdateImageStatus(jobID string, status models.JobStatus) erro
 	return err
 }
 
+// UpdateProgress records the step a media part is at. uploadProgress is the
+// percentage uploaded so far and is only set while uploading.
+func (d *Database) UpdateProgress(jobID string, mediaType models.CompressionType, step models.JobStep, uploadProgress *int) error {
+	query := `UPDATE jobs SET video_step = $1, video_upload_progress = $2, updated_at = CURRENT_TIMESTAMP WHERE job_id = $3`
+	if mediaType == models.CompressionTypeImage {
+		query = `UPDATE jobs SET image_step = $1, image_upload_progress = $2, updated_at = CURRENT_TIMESTAMP WHERE job_id = $3`
+	}
+	_, err := d.db.Exec(query, step, uploadProgress, jobID)
+	return err
+}
+
 func (d *Database) UpdateVideoResult(jobID string, result *models.VideoResult) error {
 	resultJSON, err := json.Marshal(result)
 	if err != nil {
@@ -325,3 +488,352 @@ func (d *Database) GetPendingJobs(limit int) ([]*models.Job, error) {
 
 	return jobs, nil
 }
+
+func (d *Database) SaveMediaHashes(jobID string, mediaType models.CompressionType, hashes []uint64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM media_hashes WHERE job_id = $1 AND media_type = $2`, jobID, mediaType); err != nil {
+		return fmt.Errorf("failed to clear media hashes: %w", err)
+	}
+
+	for i, hash := range hashes {
+		query := `INSERT INTO media_hashes (job_id, media_type, frame_index, phash) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(query, jobID, mediaType, i, int64(hash)); err != nil {
+			return fmt.Errorf("failed to save media hash: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) GetMediaHashes(jobID string, mediaType models.CompressionType) ([]uint64, error) {
+	rows, err := d.db.Query(`SELECT phash FROM media_hashes WHERE job_id = $1 AND media_type = $2 ORDER BY frame_index`, jobID, mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media hashes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []uint64
+	for rows.Next() {
+		var hash int64
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan media hash: %w", err)
+		}
+		hashes = append(hashes, uint64(hash))
+	}
+	return hashes, rows.Err()
+}
+
+// FindDuplicates returns other jobs whose hashes for mediaType are within
+// maxDistance of hashes, closest first. Videos are compared keyframe by
+// keyframe and must have the same number of sampled frames; the distance is
+// the mean over all frames.
+// FindDuplicates only matches jobs of the same tenant, or jobs without a
+// tenant when tenantID is empty.
+func (d *Database) FindDuplicates(jobID, tenantID string, mediaType models.CompressionType, hashes []uint64, maxDistance, limit int) ([]models.DuplicateMatch, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT h.job_id, j.post_id, j.status, j.created_at,
+			AVG(length(replace(((h.phash # q.hash)::bit(64))::text, '0', ''))) AS distance
+		FROM media_hashes h
+		JOIN unnest($3::bigint[]) WITH ORDINALITY AS q(hash, idx) ON h.frame_index = q.idx - 1
+		JOIN jobs j ON j.job_id = h.job_id
+		WHERE h.media_type = $2 AND h.job_id <> $1 AND COALESCE(j.tenant_id, '') = $7
+			AND (SELECT COUNT(*) FROM media_hashes c WHERE c.job_id = h.job_id AND c.media_type = $2) = $4
+		GROUP BY h.job_id, j.post_id, j.status, j.created_at
+		HAVING COUNT(*) = $4
+			AND AVG(length(replace(((h.phash # q.hash)::bit(64))::text, '0', ''))) <= $5
+		ORDER BY distance ASC, j.created_at ASC
+		LIMIT $6
+	`
+
+	signed := make([]int64, len(hashes))
+	for i, hash := range hashes {
+		signed[i] = int64(hash)
+	}
+
+	rows, err := d.db.Query(query, jobID, mediaType, pq.Array(signed), len(hashes), maxDistance, limit, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []models.DuplicateMatch
+	for rows.Next() {
+		match := models.DuplicateMatch{MediaType: mediaType}
+		if err := rows.Scan(&match.JobID, &match.PostID, &match.Status, &match.CreatedAt, &match.Distance); err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate: %w", err)
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// GetCachedResult loads a cached result into dest and returns the job that
+// produced it. Entries older than maxAge are ignored; found is false on a
+// miss.
+func (d *Database) GetCachedResult(mediaType models.CompressionType, contentHash, paramsHash string, maxAge time.Duration, dest interface{}) (string, bool, error) {
+	query := `
+		UPDATE result_cache
+		SET hit_count = hit_count + 1, last_hit_at = CURRENT_TIMESTAMP
+		WHERE media_type = $1 AND content_hash = $2 AND params_hash = $3
+			AND created_at > CURRENT_TIMESTAMP - $4 * INTERVAL '1 second'
+		RETURNING job_id, result
+	`
+
+	var jobID string
+	var result []byte
+	err := d.db.QueryRow(query, mediaType, contentHash, paramsHash, int64(maxAge.Seconds())).Scan(&jobID, &result)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get cached result: %w", err)
+	}
+
+	if err := json.Unmarshal(result, dest); err != nil {
+		return "", false, fmt.Errorf("failed to decode cached result: %w", err)
+	}
+	return jobID, true, nil
+}
+
+func (d *Database) SaveCachedResult(mediaType models.CompressionType, contentHash, paramsHash, jobID string, result interface{}) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO result_cache (media_type, content_hash, params_hash, job_id, result)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (media_type, content_hash, params_hash) DO UPDATE
+		SET job_id = EXCLUDED.job_id, result = EXCLUDED.result, hit_count = 0,
+			created_at = CURRENT_TIMESTAMP, last_hit_at = NULL
+	`
+	_, err = d.db.Exec(query, mediaType, contentHash, paramsHash, jobID, resultJSON)
+	return err
+}
+
+func (d *Database) CreateUpload(upload *models.Upload) error {
+	query := `
+		INSERT INTO uploads (
+			upload_id, tenant_id, filename, content_type, size, upload_offset, sha256, status, expires_at, completed_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING created_at
+	`
+
+	var tenantID, sha256 *string
+	if upload.TenantID != "" {
+		tenantID = &upload.TenantID
+	}
+	if upload.SHA256 != "" {
+		sha256 = &upload.SHA256
+	}
+
+	err := d.db.QueryRow(
+		query,
+		upload.UploadID, tenantID, upload.Filename, upload.ContentType, upload.Size, upload.Offset, sha256,
+		upload.Status, upload.ExpiresAt, upload.CompletedAt,
+	).Scan(&upload.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetUpload(uploadID string) (*models.Upload, error) {
+	query := `
+		SELECT upload_id, tenant_id, filename, content_type, size, upload_offset, sha256, status,
+			created_at, expires_at, completed_at
+		FROM uploads
+		WHERE upload_id = $1
+	`
+
+	upload := &models.Upload{}
+	var tenantID, sha256 sql.NullString
+	var completedAt sql.NullTime
+	err := d.db.QueryRow(query, uploadID).Scan(
+		&upload.UploadID, &tenantID, &upload.Filename, &upload.ContentType, &upload.Size, &upload.Offset, &sha256, &upload.Status,
+		&upload.CreatedAt, &upload.ExpiresAt, &completedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("upload not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+
+	upload.TenantID = tenantID.String
+	upload.SHA256 = sha256.String
+	if completedAt.Valid {
+		upload.CompletedAt = &completedAt.Time
+	}
+	return upload, nil
+}
+
+// AdvanceUpload moves an upload's offset from oldOffset to newOffset. It
+// returns false when the offset in the database is no longer oldOffset.
+func (d *Database) AdvanceUpload(uploadID string, oldOffset, newOffset int64, expiresAt time.Time) (bool, error) {
+	result, err := d.db.Exec(`
+		UPDATE uploads SET upload_offset = $3, expires_at = $4
+		WHERE upload_id = $1 AND upload_offset = $2 AND status = $5
+	`, uploadID, oldOffset, newOffset, expiresAt, models.UploadStatusUploading)
+	if err != nil {
+		return false, fmt.Errorf("failed to update upload: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update upload: %w", err)
+	}
+	return rows == 1, nil
+}
+
+func (d *Database) CompleteUpload(uploadID, sha256 string, expiresAt time.Time) error {
+	_, err := d.db.Exec(`
+		UPDATE uploads SET status = $2, sha256 = $3, expires_at = $4, completed_at = CURRENT_TIMESTAMP
+		WHERE upload_id = $1
+	`, uploadID, models.UploadStatusComplete, sha256, expiresAt)
+	return err
+}
+
+func (d *Database) DeleteUpload(uploadID string) error {
+	_, err := d.db.Exec("DELETE FROM uploads WHERE upload_id = $1", uploadID)
+	return err
+}
+
+// DeleteExpiredUploads removes uploads past their expiry and returns their
+// IDs so the files can be removed too.
+func (d *Database) DeleteExpiredUploads() ([]string, error) {
+	rows, err := d.db.Query("DELETE FROM uploads WHERE expires_at < CURRENT_TIMESTAMP RETURNING upload_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (d *Database) CreateTenant(tenant *models.Tenant) error {
+	query := `
+		INSERT INTO tenants (tenant_id, name, api_key_hash, storage, source_hosts, url_rewrites)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at, updated_at
+	`
+
+	err := d.db.QueryRow(
+		query,
+		tenant.TenantID, tenant.Name, tenant.APIKeyHash, tenant.SealedStorage, pq.Array(tenant.SourceHosts),
+		tenant.SealedURLRewrites,
+	).Scan(&tenant.CreatedAt, &tenant.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create tenant: %w", err)
+	}
+	return nil
+}
+
+const tenantColumns = `tenant_id, name, api_key_hash, storage, source_hosts, url_rewrites, created_at, updated_at`
+
+func (d *Database) GetTenant(tenantID string) (*models.Tenant, error) {
+	return d.getTenant("SELECT "+tenantColumns+" FROM tenants WHERE tenant_id = $1", tenantID)
+}
+
+func (d *Database) GetTenantByAPIKeyHash(hash string) (*models.Tenant, error) {
+	return d.getTenant("SELECT "+tenantColumns+" FROM tenants WHERE api_key_hash = $1", hash)
+}
+
+func (d *Database) getTenant(query string, arg string) (*models.Tenant, error) {
+	tenant, err := scanTenant(d.db.QueryRow(query, arg))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("tenant not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+func (d *Database) ListTenants() ([]*models.Tenant, error) {
+	rows, err := d.db.Query("SELECT " + tenantColumns + " FROM tenants ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer rows.Close()
+
+	var tenants []*models.Tenant
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+func scanTenant(row interface{ Scan(...interface{}) error }) (*models.Tenant, error) {
+	tenant := &models.Tenant{}
+	var sourceHosts []string
+	err := row.Scan(
+		&tenant.TenantID, &tenant.Name, &tenant.APIKeyHash, &tenant.SealedStorage, pq.Array(&sourceHosts),
+		&tenant.SealedURLRewrites, &tenant.CreatedAt, &tenant.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	tenant.SourceHosts = sourceHosts
+	return tenant, nil
+}
+
+func (d *Database) UpdateTenant(tenant *models.Tenant) error {
+	query := `
+		UPDATE tenants SET name = $2, storage = $3, source_hosts = $4, url_rewrites = $5
+		WHERE tenant_id = $1
+		RETURNING updated_at
+	`
+
+	err := d.db.QueryRow(query, tenant.TenantID, tenant.Name, tenant.SealedStorage, pq.Array(tenant.SourceHosts), tenant.SealedURLRewrites).Scan(&tenant.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("tenant not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update tenant: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) UpdateTenantAPIKey(tenantID, hash string) error {
+	result, err := d.db.Exec("UPDATE tenants SET api_key_hash = $2 WHERE tenant_id = $1", tenantID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant api key: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+	return nil
+}
+
+func (d *Database) DeleteTenant(tenantID string) error {
+	result, err := d.db.Exec("DELETE FROM tenants WHERE tenant_id = $1", tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tenant: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("tenant not found")
+	}
+	return nil
+}
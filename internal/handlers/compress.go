@@ -1,27 +1,45 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/yourusername/video-compressor/internal/database"
+	"github.com/yourusername/video-compressor/internal/middleware"
 	"github.com/yourusername/video-compressor/internal/models"
 	"github.com/yourusername/video-compressor/internal/queue"
+	"github.com/yourusername/video-compressor/internal/storage"
+	"github.com/yourusername/video-compressor/internal/tenants"
+	"github.com/yourusername/video-compressor/internal/uploads"
+	"github.com/yourusername/video-compressor/internal/worker"
 	"github.com/yourusername/video-compressor/pkg/config"
 )
 
 type CompressHandler struct {
-	db     *database.Database
-	queue  *queue.RedisQueue
-	config *config.Config
+	db      *database.Database
+	queue   *queue.RedisQueue
+	tenants *tenants.Store
+	uploads *uploads.Store
+	signer  *storage.URLSigner
+	worker  *worker.Worker
+	config  *config.Config
 }
 
-func NewCompressHandler(db *database.Database, q *queue.RedisQueue, cfg *config.Config) *CompressHandler {
+func NewCompressHandler(db *database.Database, q *queue.RedisQueue, tenantStore *tenants.Store, store *uploads.Store, signer *storage.URLSigner, w *worker.Worker, cfg *config.Config) *CompressHandler {
 	return &CompressHandler{
-		db:     db,
-		queue:  q,
-		config: cfg,
+		db:      db,
+		queue:   q,
+		tenants: tenantStore,
+		uploads: store,
+		signer:  signer,
+		worker:  w,
+		config:  cfg,
 	}
 }
 
@@ -40,7 +58,9 @@ func (h *CompressHandler) Compress(c *gin.Context) {
 		req.JobID = uuid.New().String()
 	}
 
-	if err := h.validateRequest(&req); err != nil {
+	tenantID := middleware.TenantID(c)
+	sourceSize, err := h.validateRequest(&req, tenantID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
@@ -48,16 +68,24 @@ func (h *CompressHandler) Compress(c *gin.Context) {
 	}
 
 	job := &models.Job{
-		JobID:           req.JobID,
-		PostID:          req.PostID,
-		UserID:          req.UserID,
-		CompressionType: req.CompressionType,
-		VideoData:       req.VideoData,
-		ImageData:       req.ImageData,
-		Priority:        req.Priority,
-		Status:          models.JobStatusPending,
-		ScheduledTime:   req.ScheduledTime,
-		MaxRetries:      h.config.MaxRetries,
+		JobID:                req.JobID,
+		TenantID:             tenantID,
+		PostID:               req.PostID,
+		UserID:               req.UserID,
+		CompressionType:      req.CompressionType,
+		VideoData:            req.VideoData,
+		ImageData:            req.ImageData,
+		Priority:             req.Priority,
+		Status:               models.JobStatusPending,
+		ScheduledTime:        req.ScheduledTime,
+		MaxRetries:           h.config.MaxRetries,
+		ReuseDuplicate:       req.ReuseDuplicate,
+		DuplicateMaxDistance: req.DuplicateMaxDistance,
+		StorageBackend:       req.StorageBackend,
+		StorageMode:          req.StorageMode,
+		AttachmentID:         req.AttachmentID,
+		OutputNaming:         req.OutputNaming,
+		SourceSize:           sourceSize,
 	}
 
 	if job.Priority == 0 {
@@ -101,22 +129,229 @@ func (h *CompressHandler) Compress(c *gin.Context) {
 	})
 }
 
-func (h *CompressHandler) validateRequest(req *models.CompressRequest) error {
+func (h *CompressHandler) validateRequest(req *models.CompressRequest, tenantID string) (int64, error) {
+	if !jobIDPattern.MatchString(req.JobID) {
+		return 0, ErrInvalidJobID
+	}
+
 	switch req.CompressionType {
 	case models.CompressionTypeVideo:
 		if req.VideoData == nil {
-			return ErrVideoDataRequired
+			return 0, ErrVideoDataRequired
 		}
 	case models.CompressionTypeImage:
 		if req.ImageData == nil {
-			return ErrImageDataRequired
+			return 0, ErrImageDataRequired
 		}
 	case models.CompressionTypeBoth:
 		if req.VideoData == nil || req.ImageData == nil {
-			return ErrBothDataRequired
+			return 0, ErrBothDataRequired
+		}
+	default:
+		return 0, ErrInvalidCompressionType
+	}
+
+	if d := req.DuplicateMaxDistance; d != nil && (*d < 0 || *d > 32) {
+		return 0, ErrInvalidDuplicateDistance
+	}
+
+	if req.VideoData != nil && !validSHA256(req.VideoData.SHA256) {
+		return 0, ErrInvalidChecksum
+	}
+	if req.ImageData != nil && !validSHA256(req.ImageData.SHA256) {
+		return 0, ErrInvalidChecksum
+	}
+
+	backend, err := h.tenants.Backend(tenantID, req.StorageBackend)
+	if err != nil {
+		return 0, &ValidationError{fmt.Sprintf("storage_backend %q is not configured", req.StorageBackend)}
+	}
+
+	sourceSize, err := h.validateSources(req, backend, tenantID)
+	if err != nil {
+		return 0, err
+	}
+
+	switch req.StorageMode {
+	case "", models.StorageModeNew:
+	case models.StorageModeReplace:
+		if err := validateReplace(req, backend); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, &ValidationError{"storage_mode must be 'new' or 'replace'"}
+	}
+
+	if len(req.OutputNaming) > 255 {
+		return 0, &ValidationError{"output_naming must be at most 255 characters"}
+	}
+	if _, err := storage.ParseKeyTemplate(req.OutputNaming); err != nil {
+		return 0, &ValidationError{fmt.Sprintf("invalid output_naming: %v", err)}
+	}
+
+	if req.ImageData != nil {
+		if err := validateImageVariants(req.ImageData); err != nil {
+			return 0, err
+		}
+		for _, format := range req.ImageData.Formats {
+			switch format {
+			case models.ImageFormatOriginal, models.ImageFormatWebP, models.ImageFormatAVIF:
+			default:
+				return 0, ErrInvalidImageFormat
+			}
+		}
+	}
+
+	return sourceSize, nil
+}
+
+// validateSources rejects sources the worker would refuse, so callers find
+// out before the job is queued. It returns the combined size of the
+// uploads among them, which the worker reserves temp space by; the size of
+// a file_url is only learned when the worker downloads it.
+func (h *CompressHandler) validateSources(req *models.CompressRequest, backend storage.Backend, tenantID string) (int64, error) {
+	sources, err := h.tenants.Sources(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	backend = h.tenants.SourceBackend(tenantID, backend)
+	var total int64
+	if req.VideoData != nil {
+		size, err := h.validateSource(backend, sources, tenantID, "video_data", req.VideoData.FileURL, req.VideoData.UploadID)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	if req.ImageData != nil {
+		size, err := h.validateSource(backend, sources, tenantID, "image_data", req.ImageData.FileURL, req.ImageData.UploadID)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+func (h *CompressHandler) validateSource(backend storage.Backend, sources *storage.SourcePolicy, tenantID, field, fileURL, uploadID string) (int64, error) {
+	switch {
+	case fileURL == "" && uploadID == "":
+		return 0, &ValidationError{field + " needs file_url or upload_id"}
+	case fileURL != "" && uploadID != "":
+		return 0, &ValidationError{field + " takes file_url or upload_id, not both"}
+	case uploadID != "":
+		upload, err := h.uploads.Get(uploadID)
+		if err != nil || upload.TenantID != tenantID {
+			return 0, &ValidationError{fmt.Sprintf("%s.upload_id: upload %s not found", field, uploadID)}
 		}
+		if upload.Status != models.UploadStatusComplete {
+			return 0, &ValidationError{fmt.Sprintf("%s.upload_id: upload %s is not complete", field, uploadID)}
+		}
+		return upload.Size, nil
+	}
+
+	if err := storage.CheckSource(backend, fileURL, sources); err != nil {
+		return 0, &ValidationError{field + ".file_url: " + err.Error()}
+	}
+	return 0, nil
+}
+
+func validateReplace(req *models.CompressRequest, backend storage.Backend) error {
+	if _, ok := backend.(storage.AttachmentReplacer); !ok {
+		return &ValidationError{fmt.Sprintf("storage backend %q cannot replace attachments", backend.Name())}
+	}
+	if req.CompressionType == models.CompressionTypeBoth {
+		return &ValidationError{"storage_mode 'replace' needs compression_type 'video' or 'image'"}
+	}
+	if req.VideoData != nil && req.VideoData.HLSEnabled {
+		return &ValidationError{"storage_mode 'replace' cannot be combined with hls_enabled"}
+	}
+	if req.ImageData != nil && !replacesOriginal(req.ImageData) {
+		return &ValidationError{"storage_mode 'replace' needs an 'original' image variant"}
+	}
+	if req.AttachmentID != nil && *req.AttachmentID <= 0 {
+		return &ValidationError{"attachment_id must be positive"}
+	}
+	return nil
+}
+
+func validSHA256(sum string) bool {
+	return sum == "" || sha256Pattern.MatchString(sum)
+}
+
+// replacesOriginal reports whether the job produces the "original" variant,
+// which is what overwrites the attachment in replace mode.
+func replacesOriginal(data *models.ImageData) bool {
+	if len(data.Variants) == 0 {
+		return len(data.SrcsetWidths) == 0
+	}
+	for _, v := range data.Variants {
+		if v.Name == "original" {
+			return true
+		}
+	}
+	return false
+}
+
+func validateImageVariants(data *models.ImageData) error {
+	seen := make(map[string]bool)
+	for _, v := range data.Variants {
+		if !variantNamePattern.MatchString(v.Name) {
+			return &ValidationError{fmt.Sprintf("invalid variant name %q", v.Name)}
+		}
+		if reservedVariantNames[v.Name] || strings.HasPrefix(v.Name, srcsetVariantPrefix) {
+			return &ValidationError{fmt.Sprintf("variant name %q is reserved", v.Name)}
+		}
+		if seen[v.Name] {
+			return &ValidationError{fmt.Sprintf("duplicate variant name %q", v.Name)}
+		}
+		seen[v.Name] = true
+
+		if v.Width < 0 || v.Height < 0 || v.Width > maxVariantDimension || v.Height > maxVariantDimension {
+			return &ValidationError{fmt.Sprintf("variant %q has invalid dimensions", v.Name)}
+		}
+		if v.Width == 0 && v.Height == 0 && v.Name != "original" {
+			return &ValidationError{fmt.Sprintf("variant %q needs a width or height", v.Name)}
+		}
+		if v.Quality < 0 || v.Quality > 100 {
+			return &ValidationError{fmt.Sprintf("variant %q quality must be between 1 and 100", v.Name)}
+		}
+
+		switch v.Fit {
+		case models.FitInside, "":
+		case models.FitCover, models.FitContain, models.FitFill:
+			if v.Width == 0 || v.Height == 0 {
+				return &ValidationError{fmt.Sprintf("variant %q needs both width and height for fit %q", v.Name, v.Fit)}
+			}
+		default:
+			return &ValidationError{fmt.Sprintf("variant %q has unsupported fit %q", v.Name, v.Fit)}
+		}
+	}
+
+	switch data.QualityMode {
+	case "", models.QualityModeFixed, models.QualityModePerceptual:
 	default:
-		return ErrInvalidCompressionType
+		return &ValidationError{"quality_mode must be 'fixed' or 'perceptual'"}
+	}
+	if data.TargetScore != 0 && (data.TargetScore < 0.5 || data.TargetScore >= 1) {
+		return &ValidationError{"target_score must be between 0.5 and 1"}
+	}
+
+	if fp := data.FocalPoint; fp != nil {
+		if fp.X < 0 || fp.X > 100 || fp.Y < 0 || fp.Y > 100 {
+			return &ValidationError{"focal_point x and y must be percentages between 0 and 100"}
+		}
+	}
+
+	widths := make(map[int]bool)
+	for _, width := range data.SrcsetWidths {
+		if width <= 0 || width > maxVariantDimension {
+			return &ValidationError{fmt.Sprintf("invalid srcset width %d", width)}
+		}
+		if widths[width] {
+			return &ValidationError{fmt.Sprintf("duplicate srcset width %d", width)}
+		}
+		widths[width] = true
 	}
 
 	return nil
@@ -125,19 +360,25 @@ func (h *CompressHandler) validateRequest(req *models.CompressRequest) error {
 func (h *CompressHandler) GetStatus(c *gin.Context) {
 	jobID := c.Param("job_id")
 
+	// The cached status does not say whose job it is, so tenants go
+	// through the database first.
 	cached, _ := h.queue.GetCachedJobStatus(jobID)
-	if cached != nil {
+	if cached != nil && middleware.Tenant(c) == nil {
 		c.JSON(http.StatusOK, cached)
 		return
 	}
 
 	job, err := h.db.GetJobByID(jobID)
-	if err != nil {
+	if err != nil || !canAccess(c, job.TenantID) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Job not found",
 		})
 		return
 	}
+	if cached != nil {
+		c.JSON(http.StatusOK, cached)
+		return
+	}
 
 	response := &models.StatusResponse{
 		JobID:           job.JobID,
@@ -151,12 +392,20 @@ func (h *CompressHandler) GetStatus(c *gin.Context) {
 		response.VideoStatus = job.VideoStatus
 		progress := h.calculateVideoProgress(job)
 		response.VideoProgress = &progress
+		if *job.VideoStatus == models.JobStatusProcessing && job.VideoStep != nil {
+			response.VideoCurrentStep = string(*job.VideoStep)
+			response.VideoUploadProgress = job.VideoUploadProgress
+		}
 	}
 
 	if job.ImageStatus != nil {
 		response.ImageStatus = job.ImageStatus
 		progress := h.calculateImageProgress(job)
 		response.ImageProgress = &progress
+		if *job.ImageStatus == models.JobStatusProcessing && job.ImageStep != nil {
+			response.ImageCurrentStep = string(*job.ImageStep)
+			response.ImageUploadProgress = job.ImageUploadProgress
+		}
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -166,7 +415,7 @@ func (h *CompressHandler) GetResult(c *gin.Context) {
 	jobID := c.Param("job_id")
 
 	job, err := h.db.GetJobByID(jobID)
-	if err != nil {
+	if err != nil || !canAccess(c, job.TenantID) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Job not found",
 		})
@@ -182,6 +431,11 @@ func (h *CompressHandler) GetResult(c *gin.Context) {
 		ErrorMessage:    job.ErrorMessage,
 	}
 
+	if rewrite := resultURLs(c, h.signer, h.tenants, job, h.config.FileBaseURL); rewrite != nil {
+		rewriteVideoResult(response.VideoResult, rewrite)
+		rewriteImageResult(response.ImageResult, rewrite)
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -193,6 +447,7 @@ func (h *CompressHandler) GetQueueStats(c *gin.Context) {
 		})
 		return
 	}
+	stats.TempDisk = h.worker.TempUsage()
 
 	c.JSON(http.StatusOK, stats)
 }
@@ -201,7 +456,7 @@ func (h *CompressHandler) CancelJob(c *gin.Context) {
 	jobID := c.Param("job_id")
 
 	job, err := h.db.GetJobByID(jobID)
-	if err != nil {
+	if err != nil || !canAccess(c, job.TenantID) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Job not found",
 		})
@@ -242,6 +497,93 @@ func (h *CompressHandler) CancelJob(c *gin.Context) {
 	})
 }
 
+// RevertJob restores the attachment a replace-mode job overwrote to the file
+// it had before the job ran.
+func (h *CompressHandler) RevertJob(c *gin.Context) {
+	jobID := c.Param("job_id")
+
+	job, err := h.db.GetJobByID(jobID)
+	if err != nil || !canAccess(c, job.TenantID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found",
+		})
+		return
+	}
+
+	var attachmentID int
+	var revertedAt *time.Time
+	switch {
+	case job.VideoResult != nil && job.VideoResult.ReplacedAttachmentID != 0:
+		attachmentID, revertedAt = job.VideoResult.ReplacedAttachmentID, job.VideoResult.RevertedAt
+	case job.ImageResult != nil && job.ImageResult.ReplacedAttachmentID != 0:
+		attachmentID, revertedAt = job.ImageResult.ReplacedAttachmentID, job.ImageResult.RevertedAt
+	default:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Job did not replace an attachment",
+		})
+		return
+	}
+
+	if revertedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Job was already reverted",
+		})
+		return
+	}
+
+	backend, err := h.tenants.Backend(job.TenantID, job.StorageBackend)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	replacer, ok := backend.(storage.AttachmentReplacer)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("Storage backend %s cannot revert attachments", backend.Name()),
+		})
+		return
+	}
+
+	restored, err := replacer.RevertAttachment(attachmentID, job.JobID)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, storage.ErrConflict):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"error": fmt.Sprintf("Failed to revert attachment: %v", err),
+		})
+		return
+	}
+
+	now := time.Now()
+	if job.VideoResult != nil && job.VideoResult.ReplacedAttachmentID == attachmentID {
+		job.VideoResult.RevertedAt = &now
+		err = h.db.UpdateVideoResult(job.JobID, job.VideoResult)
+	} else {
+		job.ImageResult.RevertedAt = &now
+		err = h.db.UpdateImageResult(job.JobID, job.ImageResult)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update job result",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":        job.JobID,
+		"attachment_id": attachmentID,
+		"url":           restored.URL,
+		"reverted_at":   now,
+	})
+}
+
 func (h *CompressHandler) calculateProgress(job *models.Job) int {
 	if job.Status == models.JobStatusCompleted {
 		return 100
@@ -279,7 +621,7 @@ func (h *CompressHandler) calculateVideoProgress(job *models.Job) int {
 	case models.JobStatusCompleted:
 		return 100
 	case models.JobStatusProcessing:
-		return 50
+		return stepProgress(job.VideoStep, job.VideoUploadProgress)
 	case models.JobStatusPending:
 		return 0
 	default:
@@ -296,7 +638,7 @@ func (h *CompressHandler) calculateImageProgress(job *models.Job) int {
 	case models.JobStatusCompleted:
 		return 100
 	case models.JobStatusProcessing:
-		return 50
+		return stepProgress(job.ImageStep, job.ImageUploadProgress)
 	case models.JobStatusPending:
 		return 0
 	default:
@@ -304,6 +646,28 @@ func (h *CompressHandler) calculateImageProgress(job *models.Job) int {
 	}
 }
 
+// stepProgress maps the step of a processing media part to a percentage.
+// Uploading covers 60 to 100 percent.
+func stepProgress(step *models.JobStep, uploadProgress *int) int {
+	if step == nil {
+		return 50
+	}
+
+	switch *step {
+	case models.JobStepDownloading:
+		return 10
+	case models.JobStepCompressing:
+		return 30
+	case models.JobStepUploading:
+		if uploadProgress != nil {
+			return 60 + *uploadProgress*40/100
+		}
+		return 60
+	default:
+		return 50
+	}
+}
+
 func (h *CompressHandler) estimateTime(job *models.Job) int {
 	if job.Status == models.JobStatusCompleted || job.Status == models.JobStatusFailed {
 		return 0
@@ -322,11 +686,38 @@ func (h *CompressHandler) estimateTime(job *models.Job) int {
 	return estimatedTime
 }
 
+const maxVariantDimension = 10000
+
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// reservedVariantNames name the other outputs of a job in output_naming
+// templates, so image variants cannot use them.
+var reservedVariantNames = map[string]bool{
+	"compressed": true,
+	"hls":        true,
+	"poster":     true,
+	"animated":   true,
+}
+
+// srcsetVariantPrefix starts the names of the variants generated for
+// srcset_widths.
+const srcsetVariantPrefix = "srcset-"
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// jobIDPattern keeps job IDs usable as a single path segment, since the
+// worker names its temp directories after them.
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 var (
-	ErrVideoDataRequired       = &ValidationError{"video_data is required for video compression"}
-	ErrImageDataRequired       = &ValidationError{"image_data is required for image compression"}
-	ErrBothDataRequired        = &ValidationError{"both video_data and image_data are required"}
-	ErrInvalidCompressionType  = &ValidationError{"compression_type must be 'video', 'image', or 'both'"}
+	ErrVideoDataRequired        = &ValidationError{"video_data is required for video compression"}
+	ErrImageDataRequired        = &ValidationError{"image_data is required for image compression"}
+	ErrBothDataRequired         = &ValidationError{"both video_data and image_data are required"}
+	ErrInvalidCompressionType   = &ValidationError{"compression_type must be 'video', 'image', or 'both'"}
+	ErrInvalidImageFormat       = &ValidationError{"image_data.formats may only contain 'original', 'webp' or 'avif'"}
+	ErrInvalidDuplicateDistance = &ValidationError{"duplicate_max_distance must be between 0 and 32"}
+	ErrInvalidChecksum          = &ValidationError{"sha256 must be 64 hexadecimal characters"}
+	ErrInvalidJobID             = &ValidationError{"job_id may only contain letters, digits, '-' and '_' and be at most 64 characters"}
 )
 
 type ValidationError struct {
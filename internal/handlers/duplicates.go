@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/video-compressor/internal/database"
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+type DuplicateHandler struct {
+	db *database.Database
+}
+
+func NewDuplicateHandler(db *database.Database) *DuplicateHandler {
+	return &DuplicateHandler{
+		db: db,
+	}
+}
+
+func (h *DuplicateHandler) GetDuplicates(c *gin.Context) {
+	jobID := c.Param("job_id")
+
+	job, err := h.db.GetJobByID(jobID)
+	if err != nil || !canAccess(c, job.TenantID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found",
+		})
+		return
+	}
+
+	maxDistance := models.DefaultDuplicateMaxDistance
+	if value := c.Query("max_distance"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > 32 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "max_distance must be between 0 and 32",
+			})
+			return
+		}
+		maxDistance = parsed
+	}
+
+	limit := 20
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "limit must be between 1 and 100",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	mediaTypes := []models.CompressionType{models.CompressionTypeVideo, models.CompressionTypeImage}
+	switch mediaType := models.CompressionType(c.Query("media_type")); mediaType {
+	case "":
+	case models.CompressionTypeVideo, models.CompressionTypeImage:
+		mediaTypes = []models.CompressionType{mediaType}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "media_type must be 'video' or 'image'",
+		})
+		return
+	}
+
+	duplicates := []models.DuplicateMatch{}
+	hashed := false
+	for _, mediaType := range mediaTypes {
+		hashes, err := h.db.GetMediaHashes(jobID, mediaType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load media hashes",
+			})
+			return
+		}
+		if len(hashes) == 0 {
+			continue
+		}
+		hashed = true
+
+		matches, err := h.db.FindDuplicates(jobID, job.TenantID, mediaType, hashes, maxDistance, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to find duplicates",
+			})
+			return
+		}
+		duplicates = append(duplicates, matches...)
+	}
+
+	if !hashed {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Job has not been hashed yet",
+		})
+		return
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].Distance < duplicates[j].Distance
+	})
+	if len(duplicates) > limit {
+		duplicates = duplicates[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":       jobID,
+		"max_distance": maxDistance,
+		"duplicates":   duplicates,
+	})
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/storage"
+	"github.com/yourusername/video-compressor/internal/tenants"
+)
+
+type FileHandler struct {
+	tenants *tenants.Store
+	signer  *storage.URLSigner
+}
+
+func NewFileHandler(tenantStore *tenants.Store, signer *storage.URLSigner) *FileHandler {
+	return &FileHandler{
+		tenants: tenantStore,
+		signer:  signer,
+	}
+}
+
+// ServeFile serves a stored output behind a signed URL. Range and
+// conditional requests are handled by http.ServeContent.
+func (h *FileHandler) ServeFile(c *gin.Context) {
+	backendName := c.Param("backend")
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	expires := c.Param("expires")
+	now := time.Now()
+
+	err := h.signer.Verify(backendName, key, expires, c.Param("signature"), now)
+	if errors.Is(err, storage.ErrSignatureExpired) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "URL has expired",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Invalid signature",
+		})
+		return
+	}
+
+	var server storage.ObjectServer
+	if backend, err := h.tenants.BackendByStorageName(backendName); err == nil {
+		server, _ = backend.(storage.ObjectServer)
+	}
+	if server == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+
+	content, info, err := server.Open(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open %s on %s: %v", key, backendName, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to read file",
+		})
+		return
+	}
+	defer content.Close()
+
+	// The URL stops working at its expiry, so caches may keep the response
+	// until then.
+	expiresAt, _ := strconv.ParseInt(expires, 10, 64)
+	header := c.Writer.Header()
+	header.Set("Content-Type", info.ContentType)
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", expiresAt-now.Unix()))
+	if info.ETag != "" {
+		header.Set("ETag", info.ETag)
+	}
+
+	http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime, content)
+}
+
+// resultSigner returns a function that swaps URLs the job's backend handed
+// out for signed file URLs. It returns nil when the service does not serve
+// files itself or the backend cannot be served from.
+func resultSigner(c *gin.Context, signer *storage.URLSigner, tenantStore *tenants.Store, job *models.Job, baseURL string) func(string) string {
+	if signer == nil {
+		return nil
+	}
+	backend, err := tenantStore.Backend(job.TenantID, job.StorageBackend)
+	if err != nil {
+		return nil
+	}
+	server, ok := backend.(storage.ObjectServer)
+	if !ok {
+		return nil
+	}
+
+	if baseURL == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + c.Request.Host
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	storageName := tenantStore.StorageName(job.TenantID, job.StorageBackend)
+	now := time.Now()
+	return func(rawURL string) string {
+		key, ok := server.KeyForURL(rawURL)
+		if !ok {
+			return rawURL
+		}
+		return baseURL + signer.Path(storageName, key, now)
+	}
+}
+
+// resultURLs returns the function every URL of a result goes through
+// before it is returned: signing first, so a CDN can front the signed file
+// URLs, then the URL rewrite rules. It returns nil when neither applies.
+func resultURLs(c *gin.Context, signer *storage.URLSigner, tenantStore *tenants.Store, job *models.Job, baseURL string) func(string) string {
+	sign := resultSigner(c, signer, tenantStore, job, baseURL)
+	rewriter := tenantStore.URLRewriter(job.TenantID, tenantStore.StorageName(job.TenantID, job.StorageBackend), time.Now())
+	switch {
+	case rewriter == nil:
+		return sign
+	case sign == nil:
+		return rewriter.Rewrite
+	}
+	return func(rawURL string) string {
+		return rewriter.Rewrite(sign(rawURL))
+	}
+}
+
+func rewriteVideoResult(result *models.VideoResult, rewrite func(string) string) {
+	if result == nil {
+		return
+	}
+	result.CompressedURL = rewriteURL(result.CompressedURL, rewrite)
+	result.HLSPlaylistURL = rewriteURL(result.HLSPlaylistURL, rewrite)
+	result.PosterURL = rewriteURL(result.PosterURL, rewrite)
+	for name, url := range result.HLSVariants {
+		result.HLSVariants[name] = rewriteURL(url, rewrite)
+	}
+}
+
+func rewriteImageResult(result *models.ImageResult, rewrite func(string) string) {
+	if result == nil {
+		return
+	}
+	for name, variant := range result.Variants {
+		variant.URL = rewriteURL(variant.URL, rewrite)
+		for format, url := range variant.Formats {
+			variant.Formats[format] = rewriteURL(url, rewrite)
+		}
+		result.Variants[name] = variant
+	}
+	result.Srcset = rewriteSrcset(result.Srcset, rewrite)
+	for format, srcset := range result.SrcsetFormats {
+		result.SrcsetFormats[format] = rewriteSrcset(srcset, rewrite)
+	}
+	for name, animation := range result.Animation {
+		animation.URL = rewriteURL(animation.URL, rewrite)
+		result.Animation[name] = animation
+	}
+}
+
+func rewriteURL(url string, rewrite func(string) string) string {
+	if url == "" {
+		return url
+	}
+	return rewrite(url)
+}
+
+// rewriteSrcset rewrites each candidate of a "url 480w, url 768w" list.
+func rewriteSrcset(srcset string, rewrite func(string) string) string {
+	if srcset == "" {
+		return srcset
+	}
+	candidates := strings.Split(srcset, ", ")
+	for i, candidate := range candidates {
+		url, descriptor, _ := strings.Cut(candidate, " ")
+		candidates[i] = strings.TrimSpace(rewrite(url) + " " + descriptor)
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -6,17 +6,20 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/yourusername/video-compressor/internal/database"
 	"github.com/yourusername/video-compressor/internal/queue"
+	"github.com/yourusername/video-compressor/internal/worker"
 )
 
 type HealthHandler struct {
-	db    *database.Database
-	queue *queue.RedisQueue
+	db     *database.Database
+	queue  *queue.RedisQueue
+	worker *worker.Worker
 }
 
-func NewHealthHandler(db *database.Database, q *queue.RedisQueue) *HealthHandler {
+func NewHealthHandler(db *database.Database, q *queue.RedisQueue, w *worker.Worker) *HealthHandler {
 	return &HealthHandler{
-		db:    db,
-		queue: q,
+		db:     db,
+		queue:  q,
+		worker: w,
 	}
 }
 
@@ -37,8 +40,25 @@ func (h *HealthHandler) Ready(c *gin.Context) {
 		return
 	}
 
+	// The API keeps accepting jobs while a storage target is failing or the
+	// temp disk is full; they wait in the queue until the worker can take
+	// them.
+	status := "ready"
+	tempDisk := h.worker.TempUsage()
+	if !tempDisk.Admitting {
+		status = "degraded"
+	}
+	storage := h.worker.StorageStatus()
+	for _, breaker := range storage {
+		if breaker.State != worker.BreakerClosed {
+			status = "degraded"
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status":       "ready",
+		"status":       status,
 		"queue_length": queueLength,
+		"storage":      storage,
+		"temp_disk":    tempDisk,
 	})
 }
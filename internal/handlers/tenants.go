@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/video-compressor/internal/middleware"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/tenants"
+)
+
+type TenantHandler struct {
+	tenants *tenants.Store
+}
+
+func NewTenantHandler(store *tenants.Store) *TenantHandler {
+	return &TenantHandler{
+		tenants: store,
+	}
+}
+
+func (h *TenantHandler) CreateTenant(c *gin.Context) {
+	var req models.TenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	tenant, apiKey, err := h.tenants.Create(&req)
+	if err != nil {
+		tenantError(c, err, "Failed to create tenant")
+		return
+	}
+
+	c.JSON(http.StatusCreated, tenantResponse(tenant, apiKey))
+}
+
+func (h *TenantHandler) ListTenants(c *gin.Context) {
+	list, err := h.tenants.List()
+	if err != nil {
+		tenantError(c, err, "Failed to list tenants")
+		return
+	}
+
+	response := make([]*models.TenantResponse, len(list))
+	for i, tenant := range list {
+		response[i] = tenantResponse(tenant, "")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tenants": response,
+	})
+}
+
+func (h *TenantHandler) GetTenant(c *gin.Context) {
+	tenant, err := h.tenants.Get(c.Param("tenant_id"))
+	if err != nil {
+		tenantError(c, err, "Failed to get tenant")
+		return
+	}
+
+	c.JSON(http.StatusOK, tenantResponse(tenant, ""))
+}
+
+func (h *TenantHandler) UpdateTenant(c *gin.Context) {
+	var req models.TenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request format",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	tenant, err := h.tenants.Update(c.Param("tenant_id"), &req)
+	if err != nil {
+		tenantError(c, err, "Failed to update tenant")
+		return
+	}
+
+	c.JSON(http.StatusOK, tenantResponse(tenant, ""))
+}
+
+func (h *TenantHandler) RotateKey(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	apiKey, err := h.tenants.RotateKey(tenantID)
+	if err != nil {
+		tenantError(c, err, "Failed to rotate api key")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id": tenantID,
+		"api_key":   apiKey,
+	})
+}
+
+func (h *TenantHandler) DeleteTenant(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	if err := h.tenants.Delete(tenantID); err != nil {
+		tenantError(c, err, "Failed to delete tenant")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "deleted",
+		"tenant_id": tenantID,
+	})
+}
+
+func tenantResponse(tenant *models.Tenant, apiKey string) *models.TenantResponse {
+	redacted := *tenant
+	redacted.Storage = tenant.Storage.Redacted()
+	redacted.URLRewrites = models.RedactedURLRewrites(tenant.URLRewrites)
+	return &models.TenantResponse{Tenant: &redacted, APIKey: apiKey}
+}
+
+func tenantError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, tenants.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Tenant not found",
+		})
+	case errors.Is(err, tenants.ErrInvalid), errors.Is(err, tenants.ErrNoEncryptionKey):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		log.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": message,
+		})
+	}
+}
+
+// canAccess reports whether the request may see a job or upload owned by
+// tenantID. The deployment's key sees everything.
+func canAccess(c *gin.Context, tenantID string) bool {
+	tenant := middleware.Tenant(c)
+	return tenant == nil || tenant.TenantID == tenantID
+}
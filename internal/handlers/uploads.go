@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/video-compressor/internal/middleware"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/uploads"
+)
+
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,creation-with-upload,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+type UploadHandler struct {
+	uploads *uploads.Store
+}
+
+func NewUploadHandler(store *uploads.Store) *UploadHandler {
+	return &UploadHandler{
+		uploads: store,
+	}
+}
+
+// Upload stores the "file" part of a multipart/form-data request in one go.
+func (h *UploadHandler) Upload(c *gin.Context) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Expected a multipart/form-data request",
+		})
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid multipart body",
+			})
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		upload, err := h.uploads.Save(middleware.TenantID(c), part.FileName(), part.Header.Get("Content-Type"), part)
+		part.Close()
+		if errors.Is(err, uploads.ErrTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "File is larger than the upload limit",
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to store upload: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to store upload",
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, upload)
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Request has no file part",
+	})
+}
+
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	upload, err := h.get(c, c.Param("upload_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+func (h *UploadHandler) DeleteUpload(c *gin.Context) {
+	uploadID := c.Param("upload_id")
+	if _, err := h.get(c, uploadID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload not found",
+		})
+		return
+	}
+
+	if err := h.uploads.Delete(uploadID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete upload",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "deleted",
+		"upload_id": uploadID,
+	})
+}
+
+// TusOptions answers tus discovery requests.
+func (h *UploadHandler) TusOptions(c *gin.Context) {
+	header := c.Writer.Header()
+	header.Set("Tus-Resumable", tusVersion)
+	header.Set("Tus-Version", tusVersion)
+	header.Set("Tus-Extension", tusExtensions)
+	if max := h.uploads.MaxSize(); max > 0 {
+		header.Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreate starts a tus upload. A body sent with the request is written
+// as the first chunk.
+func (h *UploadHandler) TusCreate(c *gin.Context) {
+	if !tusRequest(c) {
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		tusError(c, http.StatusBadRequest, "Upload-Defer-Length is not supported")
+		return
+	}
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		tusError(c, http.StatusBadRequest, "Upload-Length is required")
+		return
+	}
+
+	metadata := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = metadata["type"]
+	}
+
+	upload, err := h.uploads.Create(middleware.TenantID(c), filename, contentType, size)
+	if errors.Is(err, uploads.ErrTooLarge) {
+		tusError(c, http.StatusRequestEntityTooLarge, "Upload-Length is larger than the upload limit")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create upload: %v", err)
+		tusError(c, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+
+	if c.Request.ContentLength != 0 && c.ContentType() == tusContentType && size > 0 {
+		if written, err := h.uploads.Append(upload.UploadID, 0, c.Request.Body); err != nil {
+			log.Printf("Failed to write first chunk of upload %s: %v", upload.UploadID, err)
+		} else {
+			upload = written
+		}
+	}
+
+	header := c.Writer.Header()
+	header.Set("Location", tusLocation(c, upload.UploadID))
+	setTusUploadHeaders(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+// TusHead reports how much of an upload the service has.
+func (h *UploadHandler) TusHead(c *gin.Context) {
+	if !tusRequest(c) {
+		return
+	}
+
+	upload, err := h.get(c, c.Param("upload_id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	setTusUploadHeaders(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// TusPatch appends a chunk at Upload-Offset.
+func (h *UploadHandler) TusPatch(c *gin.Context) {
+	if !tusRequest(c) {
+		return
+	}
+
+	if c.ContentType() != tusContentType {
+		tusError(c, http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		tusError(c, http.StatusBadRequest, "Upload-Offset is required")
+		return
+	}
+
+	if _, err := h.get(c, c.Param("upload_id")); err != nil {
+		tusError(c, http.StatusNotFound, "Upload not found")
+		return
+	}
+
+	upload, err := h.uploads.Append(c.Param("upload_id"), offset, c.Request.Body)
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		tusError(c, http.StatusNotFound, "Upload not found")
+		return
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		tusError(c, http.StatusConflict, "Upload-Offset does not match the upload")
+		return
+	case errors.Is(err, uploads.ErrBusy):
+		tusError(c, http.StatusLocked, "Upload is being written by another request")
+		return
+	case err != nil && upload == nil:
+		log.Printf("Failed to append to upload %s: %v", c.Param("upload_id"), err)
+		tusError(c, http.StatusInternalServerError, "Failed to write upload")
+		return
+	}
+
+	// A broken chunk still moves the offset; the client resumes from there.
+	setTusUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// TusDelete implements the termination extension.
+func (h *UploadHandler) TusDelete(c *gin.Context) {
+	if !tusRequest(c) {
+		return
+	}
+
+	uploadID := c.Param("upload_id")
+	if _, err := h.get(c, uploadID); err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err := h.uploads.Delete(uploadID); err != nil {
+		tusError(c, http.StatusInternalServerError, "Failed to delete upload")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// get returns an upload the request's tenant owns.
+func (h *UploadHandler) get(c *gin.Context, uploadID string) (*models.Upload, error) {
+	upload, err := h.uploads.Get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if !canAccess(c, upload.TenantID) {
+		return nil, uploads.ErrNotFound
+	}
+	return upload, nil
+}
+
+// tusRequest checks the protocol version and sets the headers every tus
+// response carries. It answers the request itself when the version is wrong.
+func tusRequest(c *gin.Context) bool {
+	c.Writer.Header().Set("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Writer.Header().Set("Tus-Version", tusVersion)
+		c.Status(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func tusError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{
+		"error": message,
+	})
+}
+
+func setTusUploadHeaders(c *gin.Context, upload *models.Upload) {
+	header := c.Writer.Header()
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Status == models.UploadStatusUploading {
+		header.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+func tusLocation(c *gin.Context, uploadID string) string {
+	return strings.TrimSuffix(c.Request.URL.Path, "/") + "/" + uploadID
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2". Values that
+// are not valid base64 are dropped.
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
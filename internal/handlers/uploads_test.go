@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/uploads"
+)
+
+// memoryUploadDB keeps upload state in memory the way the uploads table
+// does.
+type memoryUploadDB struct {
+	mu      sync.Mutex
+	uploads map[string]models.Upload
+}
+
+func (db *memoryUploadDB) CreateUpload(upload *models.Upload) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	upload.CreatedAt = time.Now()
+	db.uploads[upload.UploadID] = *upload
+	return nil
+}
+
+func (db *memoryUploadDB) GetUpload(uploadID string) (*models.Upload, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	upload, ok := db.uploads[uploadID]
+	if !ok {
+		return nil, fmt.Errorf("upload not found")
+	}
+	return &upload, nil
+}
+
+func (db *memoryUploadDB) AdvanceUpload(uploadID string, oldOffset, newOffset int64, expiresAt time.Time) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	upload, ok := db.uploads[uploadID]
+	if !ok || upload.Offset != oldOffset || upload.Status != models.UploadStatusUploading {
+		return false, nil
+	}
+	upload.Offset = newOffset
+	upload.ExpiresAt = expiresAt
+	db.uploads[uploadID] = upload
+	return true, nil
+}
+
+func (db *memoryUploadDB) CompleteUpload(uploadID, sha256 string, expiresAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	upload := db.uploads[uploadID]
+	now := time.Now()
+	upload.Status = models.UploadStatusComplete
+	upload.SHA256 = sha256
+	upload.ExpiresAt = expiresAt
+	upload.CompletedAt = &now
+	db.uploads[uploadID] = upload
+	return nil
+}
+
+func (db *memoryUploadDB) DeleteUpload(uploadID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.uploads, uploadID)
+	return nil
+}
+
+func (db *memoryUploadDB) DeleteExpiredUploads() ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var ids []string
+	for id, upload := range db.uploads {
+		if upload.ExpiresAt.Before(time.Now()) {
+			delete(db.uploads, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (db *memoryUploadDB) expire(uploadID string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	upload := db.uploads[uploadID]
+	upload.ExpiresAt = time.Now().Add(-time.Second)
+	db.uploads[uploadID] = upload
+}
+
+type tusTest struct {
+	t      *testing.T
+	db     *memoryUploadDB
+	router *gin.Engine
+}
+
+func newTusTest(t *testing.T, maxSize int64) *tusTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := &memoryUploadDB{uploads: make(map[string]models.Upload)}
+	store, err := uploads.NewStore(db, t.TempDir(), time.Hour, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewUploadHandler(store)
+
+	router := gin.New()
+	router.POST("/api/tus", h.TusCreate)
+	router.HEAD("/api/tus/:upload_id", h.TusHead)
+	router.PATCH("/api/tus/:upload_id", h.TusPatch)
+	router.DELETE("/api/tus/:upload_id", h.TusDelete)
+	return &tusTest{t: t, db: db, router: router}
+}
+
+func (tt *tusTest) do(method, target string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+	tt.t.Helper()
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	tt.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// create starts an upload of size bytes and returns its URL.
+func (tt *tusTest) create(size int) string {
+	tt.t.Helper()
+	rec := tt.do("POST", "/api/tus", nil, map[string]string{"Upload-Length": strconv.Itoa(size)})
+	if rec.Code != http.StatusCreated {
+		tt.t.Fatalf("create = %d %s", rec.Code, rec.Body)
+	}
+	return rec.Header().Get("Location")
+}
+
+func (tt *tusTest) patch(location string, offset int, body io.Reader) *httptest.ResponseRecorder {
+	tt.t.Helper()
+	return tt.do("PATCH", location, body, map[string]string{
+		"Content-Type":  tusContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func (tt *tusTest) offset(location string) string {
+	tt.t.Helper()
+	rec := tt.do("HEAD", location, nil, nil)
+	if rec.Code != http.StatusOK {
+		tt.t.Fatalf("HEAD = %d", rec.Code)
+	}
+	return rec.Header().Get("Upload-Offset")
+}
+
+func TestTusPatchOffsetMismatch(t *testing.T) {
+	tt := newTusTest(t, 0)
+	location := tt.create(10)
+
+	if rec := tt.patch(location, 0, strings.NewReader("0123")); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("first chunk = %d with offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// A repeated or skipped chunk is refused without touching the upload.
+	for _, offset := range []int{0, 2, 6} {
+		if rec := tt.patch(location, offset, strings.NewReader("xxxx")); rec.Code != http.StatusConflict {
+			t.Errorf("chunk at %d = %d, want 409", offset, rec.Code)
+		}
+	}
+	if got := tt.offset(location); got != "4" {
+		t.Errorf("offset after refused chunks = %s, want 4", got)
+	}
+
+	rec := tt.patch(location, 4, strings.NewReader("456789"))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("last chunk = %d with offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if rec.Header().Get("Upload-Expires") != "" {
+		t.Error("a complete upload still reports an expiry for its chunks")
+	}
+	if rec := tt.patch(location, 10, strings.NewReader("x")); rec.Code != http.StatusConflict {
+		t.Errorf("chunk after completion = %d, want 409", rec.Code)
+	}
+}
+
+func TestTusUploadLength(t *testing.T) {
+	tt := newTusTest(t, 100)
+
+	tests := []struct {
+		length string
+		want   int
+	}{
+		{"100", http.StatusCreated},
+		{"0", http.StatusCreated},
+		{"101", http.StatusRequestEntityTooLarge},
+		{"99999999999999999999", http.StatusBadRequest},
+		{"-1", http.StatusBadRequest},
+		{"ten", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := tt.do("POST", "/api/tus", nil, map[string]string{"Upload-Length": test.length})
+		if rec.Code != test.want {
+			t.Errorf("Upload-Length %q = %d, want %d", test.length, rec.Code, test.want)
+		}
+	}
+
+	// Bytes past Upload-Length are not stored.
+	location := tt.create(10)
+	rec := tt.patch(location, 0, strings.NewReader("0123456789overflow"))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "10" {
+		t.Errorf("oversized chunk = %d with offset %q, want 204 at 10", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+}
+
+func TestTusConcurrentPatch(t *testing.T) {
+	tt := newTusTest(t, 0)
+	location := tt.create(8)
+
+	body, writer := io.Pipe()
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- tt.patch(location, 0, body)
+	}()
+	// The write returns once the first request is copying its body.
+	if _, err := writer.Write([]byte("0123")); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := tt.patch(location, 0, strings.NewReader("abcdefgh")); rec.Code != http.StatusLocked {
+		t.Errorf("concurrent chunk = %d, want 423", rec.Code)
+	}
+
+	writer.Write([]byte("4567"))
+	writer.Close()
+	if rec := <-first; rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "8" {
+		t.Errorf("first chunk = %d with offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+}
+
+func TestTusExpiry(t *testing.T) {
+	tt := newTusTest(t, 0)
+	rec := tt.do("POST", "/api/tus", nil, map[string]string{"Upload-Length": "10"})
+	expires, err := http.ParseTime(rec.Header().Get("Upload-Expires"))
+	if err != nil || !expires.After(time.Now()) {
+		t.Fatalf("Upload-Expires = %q, want a time in the future", rec.Header().Get("Upload-Expires"))
+	}
+	location := rec.Header().Get("Location")
+	if rec := tt.patch(location, 0, strings.NewReader("0123")); rec.Code != http.StatusNoContent {
+		t.Fatalf("chunk = %d", rec.Code)
+	}
+
+	tt.db.expire(location[strings.LastIndex(location, "/")+1:])
+	if rec := tt.do("HEAD", location, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD of an expired upload = %d, want 404", rec.Code)
+	}
+	if rec := tt.patch(location, 4, strings.NewReader("456789")); rec.Code != http.StatusNotFound {
+		t.Errorf("chunk for an expired upload = %d, want 404", rec.Code)
+	}
+}
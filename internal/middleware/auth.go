@@ -1,20 +1,28 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/tenants"
 )
 
-func APIKeyAuth(apiKey string) gin.HandlerFunc {
+const tenantKey = "tenant"
+
+// APIKeyAuth accepts the deployment's API key, which acts for no tenant and
+// may manage tenants, or a tenant's key, which scopes the request to that
+// tenant.
+func APIKeyAuth(apiKey string, store *tenants.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if apiKey == "" {
+		providedKey := c.GetHeader("X-API-Key")
+		if apiKey == "" && providedKey == "" {
 			c.Next()
 			return
 		}
 
-		providedKey := c.GetHeader("X-API-Key")
 		if providedKey == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "API key is required",
@@ -23,7 +31,13 @@ func APIKeyAuth(apiKey string) gin.HandlerFunc {
 			return
 		}
 
-		if providedKey != apiKey {
+		if apiKey != "" && subtle.ConstantTimeCompare([]byte(providedKey), []byte(apiKey)) == 1 {
+			c.Next()
+			return
+		}
+
+		tenant, err := store.Authenticate(providedKey)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Invalid API key",
 			})
@@ -31,10 +45,42 @@ func APIKeyAuth(apiKey string) gin.HandlerFunc {
 			return
 		}
 
+		c.Set(tenantKey, tenant)
 		c.Next()
 	}
 }
 
+// AdminOnly rejects requests authenticated with a tenant's key.
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Tenant(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Requires the deployment API key",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Tenant returns the tenant the request was authenticated as, or nil for
+// the deployment's key.
+func Tenant(c *gin.Context) *models.Tenant {
+	if tenant, ok := c.Get(tenantKey); ok {
+		return tenant.(*models.Tenant)
+	}
+	return nil
+}
+
+// TenantID is the ID of Tenant(c), empty for the deployment's key.
+func TenantID(c *gin.Context) string {
+	if tenant := Tenant(c); tenant != nil {
+		return tenant.TenantID
+	}
+	return ""
+}
+
 func DomainWhitelist(allowedDomains []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if len(allowedDomains) == 0 {
@@ -88,11 +134,14 @@ func CORS(allowedDomains []string) gin.HandlerFunc {
 			}
 		}
 
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, HEAD, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Defer-Length")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Upload-Expires, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
-		if c.Request.Method == "OPTIONS" {
+		// Only preflights are answered here; tus discovery is a plain OPTIONS
+		// request that has to reach its handler.
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
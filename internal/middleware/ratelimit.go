@@ -42,8 +42,6 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 		clientIP := c.ClientIP()
 
 		rl.mu.Lock()
-		defer rl.mu.Unlock()
-
 		client, exists := rl.requests[clientIP]
 		now := time.Now()
 
@@ -52,11 +50,13 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 				count:     1,
 				resetTime: now.Add(rl.window),
 			}
+			rl.mu.Unlock()
 			c.Next()
 			return
 		}
 
 		if client.count >= rl.limit {
+			rl.mu.Unlock()
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":      "Rate limit exceeded",
 				"retry_after": client.resetTime.Sub(now).Seconds(),
@@ -66,6 +66,7 @@ func (rl *RateLimiter) Middleware() gin.HandlerFunc {
 		}
 
 		client.count++
+		rl.mu.Unlock()
 		c.Next()
 	}
 }
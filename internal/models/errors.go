@@ -0,0 +1,17 @@
+package models
+
+import "errors"
+
+// ErrLimitExceeded marks input that breaks a configured size, resolution or
+// duration limit. Retrying cannot help, so jobs failing with it are not
+// requeued.
+var ErrLimitExceeded = errors.New("input exceeds limit")
+
+// ErrChecksumMismatch marks a source file whose SHA-256 differs from the one
+// given in the request. The download itself already resumes and retries, so
+// these jobs are not requeued either.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrSourceNotAllowed marks a source URL whose scheme, host or address the
+// source policy refuses. Jobs failing with it are not requeued.
+var ErrSourceNotAllowed = errors.New("source not allowed")
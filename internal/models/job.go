@@ -24,6 +24,24 @@ const (
 	JobStatusCancelled  JobStatus = "cancelled"
 )
 
+// JobStep is what a processing media part is doing at the moment.
+type JobStep string
+
+const (
+	JobStepDownloading JobStep = "downloading"
+	JobStepCompressing JobStep = "compressing"
+	JobStepUploading   JobStep = "uploading"
+)
+
+// StorageMode says whether outputs are added as new media items or take over
+// an existing WordPress attachment.
+type StorageMode string
+
+const (
+	StorageModeNew     StorageMode = "new"
+	StorageModeReplace StorageMode = "replace"
+)
+
 type VideoQuality string
 
 const (
@@ -43,79 +61,207 @@ const (
 	ImageQualityUltra  ImageQuality = "ultra"
 )
 
+type QualityMode string
+
+const (
+	QualityModeFixed      QualityMode = "fixed"
+	QualityModePerceptual QualityMode = "perceptual"
+)
+
+type ImageFormat string
+
+const (
+	ImageFormatOriginal ImageFormat = "original"
+	ImageFormatWebP     ImageFormat = "webp"
+	ImageFormatAVIF     ImageFormat = "avif"
+)
+
+type FitMode string
+
+const (
+	FitCover   FitMode = "cover"
+	FitContain FitMode = "contain"
+	FitFill    FitMode = "fill"
+	FitInside  FitMode = "inside"
+)
+
+type ImageVariantSpec struct {
+	Name    string  `json:"name"`
+	Width   int     `json:"width,omitempty"`
+	Height  int     `json:"height,omitempty"`
+	Fit     FitMode `json:"fit,omitempty"`
+	Quality int     `json:"quality,omitempty"`
+}
+
+// FocalPoint marks the subject of an image in percent of its width (X) and
+// height (Y), measured from the top-left corner.
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+var ImageVariantPresets = map[string]ImageVariantSpec{
+	"thumbnail": {Name: "thumbnail", Width: 150, Height: 150, Fit: FitCover},
+	"medium":    {Name: "medium", Width: 400, Height: 300, Fit: FitInside},
+	"large":     {Name: "large", Width: 800, Height: 600, Fit: FitInside},
+	"original":  {Name: "original"},
+}
+
+var DefaultImageVariants = []string{"thumbnail", "medium", "large", "original"}
+
 type VideoData struct {
-	FileURL     string       `json:"file_url" binding:"required"`
+	FileURL     string       `json:"file_url,omitempty"`
+	UploadID    string       `json:"upload_id,omitempty"`
 	Quality     VideoQuality `json:"quality" binding:"required"`
 	HLSEnabled  bool         `json:"hls_enabled"`
 	HLSVariants []string     `json:"hls_variants"`
+	Title       string       `json:"title,omitempty"`
+	SHA256      string       `json:"sha256,omitempty"`
 }
 
 type ImageData struct {
-	FileURL  string       `json:"file_url" binding:"required"`
-	Quality  ImageQuality `json:"quality" binding:"required"`
-	Variants []string     `json:"variants"`
+	FileURL      string             `json:"file_url,omitempty"`
+	UploadID     string             `json:"upload_id,omitempty"`
+	Quality      ImageQuality       `json:"quality" binding:"required"`
+	Variants     []ImageVariantSpec `json:"variants"`
+	Formats      []ImageFormat      `json:"formats"`
+	SrcsetWidths []int              `json:"srcset_widths"`
+	FocalPoint   *FocalPoint        `json:"focal_point,omitempty"`
+	QualityMode  QualityMode        `json:"quality_mode,omitempty"`
+	TargetScore  float64            `json:"target_score,omitempty"`
+	Title        string             `json:"title,omitempty"`
+	AltText      string             `json:"alt_text,omitempty"`
+	SHA256       string             `json:"sha256,omitempty"`
 }
 
 type Job struct {
-	ID              int              `json:"id"`
-	JobID           string           `json:"job_id"`
-	PostID          int              `json:"post_id"`
-	UserID          *int             `json:"user_id"`
-	CompressionType CompressionType  `json:"compression_type"`
-	VideoData       *VideoData       `json:"video_data,omitempty"`
-	ImageData       *ImageData       `json:"image_data,omitempty"`
-	Priority        int              `json:"priority"`
-	Status          JobStatus        `json:"status"`
-	VideoStatus     *JobStatus       `json:"video_status,omitempty"`
-	ImageStatus     *JobStatus       `json:"image_status,omitempty"`
-	VideoResult     *VideoResult     `json:"video_result,omitempty"`
-	ImageResult     *ImageResult     `json:"image_result,omitempty"`
-	ErrorMessage    string           `json:"error_message,omitempty"`
-	CreatedAt       time.Time        `json:"created_at"`
-	UpdatedAt       time.Time        `json:"updated_at"`
-	StartedAt       *time.Time       `json:"started_at,omitempty"`
-	CompletedAt     *time.Time       `json:"completed_at,omitempty"`
-	ScheduledTime   *time.Time       `json:"scheduled_time,omitempty"`
-	RetryCount      int              `json:"retry_count"`
-	MaxRetries      int              `json:"max_retries"`
-	ProcessingTime  *int             `json:"processing_time,omitempty"`
+	ID                   int             `json:"id"`
+	JobID                string          `json:"job_id"`
+	TenantID             string          `json:"tenant_id,omitempty"`
+	PostID               int             `json:"post_id"`
+	UserID               *int            `json:"user_id"`
+	CompressionType      CompressionType `json:"compression_type"`
+	VideoData            *VideoData      `json:"video_data,omitempty"`
+	ImageData            *ImageData      `json:"image_data,omitempty"`
+	ReuseDuplicate       bool            `json:"reuse_duplicate"`
+	DuplicateMaxDistance *int            `json:"duplicate_max_distance,omitempty"`
+	StorageBackend       string          `json:"storage_backend,omitempty"`
+	StorageMode          StorageMode     `json:"storage_mode,omitempty"`
+	AttachmentID         *int            `json:"attachment_id,omitempty"`
+	OutputNaming         string          `json:"output_naming,omitempty"`
+	SourceSize           int64           `json:"source_size,omitempty"`
+	Priority             int             `json:"priority"`
+	Status               JobStatus       `json:"status"`
+	VideoStatus          *JobStatus      `json:"video_status,omitempty"`
+	ImageStatus          *JobStatus      `json:"image_status,omitempty"`
+	VideoStep            *JobStep        `json:"video_step,omitempty"`
+	ImageStep            *JobStep        `json:"image_step,omitempty"`
+	VideoUploadProgress  *int            `json:"video_upload_progress,omitempty"`
+	ImageUploadProgress  *int            `json:"image_upload_progress,omitempty"`
+	VideoResult          *VideoResult    `json:"video_result,omitempty"`
+	ImageResult          *ImageResult    `json:"image_result,omitempty"`
+	ErrorMessage         string          `json:"error_message,omitempty"`
+	CreatedAt            time.Time       `json:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at"`
+	StartedAt            *time.Time      `json:"started_at,omitempty"`
+	CompletedAt          *time.Time      `json:"completed_at,omitempty"`
+	ScheduledTime        *time.Time      `json:"scheduled_time,omitempty"`
+	RetryCount           int             `json:"retry_count"`
+	MaxRetries           int             `json:"max_retries"`
+	ProcessingTime       *int            `json:"processing_time,omitempty"`
 }
 
 type VideoResult struct {
-	Status            string            `json:"status"`
-	OriginalSize      int64             `json:"original_size"`
-	CompressedSize    int64             `json:"compressed_size"`
-	CompressionRatio  float64           `json:"compression_ratio"`
-	ProcessingTime    int               `json:"processing_time"`
-	CompressedURL     string            `json:"compressed_url,omitempty"`
-	HLSPlaylistURL    string            `json:"hls_playlist_url,omitempty"`
-	HLSVariants       map[string]string `json:"hls_variants,omitempty"`
+	Status               string            `json:"status"`
+	OriginalSize         int64             `json:"original_size"`
+	CompressedSize       int64             `json:"compressed_size"`
+	CompressionRatio     float64           `json:"compression_ratio"`
+	ProcessingTime       int               `json:"processing_time"`
+	CompressedURL        string            `json:"compressed_url,omitempty"`
+	HLSPlaylistURL       string            `json:"hls_playlist_url,omitempty"`
+	HLSVariants          map[string]string `json:"hls_variants,omitempty"`
+	MediaID              int               `json:"media_id,omitempty"`
+	PosterURL            string            `json:"poster_url,omitempty"`
+	PosterMediaID        int               `json:"poster_media_id,omitempty"`
+	Placeholder          *ImagePlaceholder `json:"placeholder,omitempty"`
+	DuplicateOf          string            `json:"duplicate_of,omitempty"`
+	Cached               bool              `json:"cached,omitempty"`
+	CachedFrom           string            `json:"cached_from,omitempty"`
+	ReplacedAttachmentID int               `json:"replaced_attachment_id,omitempty"`
+	RevertedAt           *time.Time        `json:"reverted_at,omitempty"`
 }
 
 type ImageResult struct {
-	Status           string                    `json:"status"`
-	OriginalSize     int64                     `json:"original_size"`
-	CompressedSize   int64                     `json:"compressed_size"`
-	CompressionRatio float64                   `json:"compression_ratio"`
-	ProcessingTime   int                       `json:"processing_time"`
-	Variants         map[string]ImageVariant   `json:"variants"`
+	Status               string                    `json:"status"`
+	OriginalSize         int64                     `json:"original_size"`
+	CompressedSize       int64                     `json:"compressed_size"`
+	CompressionRatio     float64                   `json:"compression_ratio"`
+	ProcessingTime       int                       `json:"processing_time"`
+	Variants             map[string]ImageVariant   `json:"variants"`
+	Srcset               string                    `json:"srcset,omitempty"`
+	SrcsetFormats        map[ImageFormat]string    `json:"srcset_formats,omitempty"`
+	Placeholder          *ImagePlaceholder         `json:"placeholder,omitempty"`
+	Animation            map[string]AnimatedOutput `json:"animation,omitempty"`
+	DuplicateOf          string                    `json:"duplicate_of,omitempty"`
+	Cached               bool                      `json:"cached,omitempty"`
+	CachedFrom           string                    `json:"cached_from,omitempty"`
+	ReplacedAttachmentID int                       `json:"replaced_attachment_id,omitempty"`
+	RevertedAt           *time.Time                `json:"reverted_at,omitempty"`
+}
+
+// DefaultDuplicateMaxDistance is the largest pHash Hamming distance, out of
+// 64 bits, at which two media files count as the same.
+const DefaultDuplicateMaxDistance = 8
+
+type DuplicateMatch struct {
+	JobID     string          `json:"job_id"`
+	PostID    int             `json:"post_id"`
+	MediaType CompressionType `json:"media_type"`
+	Distance  float64         `json:"distance"`
+	Status    JobStatus       `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type AnimatedOutput struct {
+	URL      string `json:"url"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+	MediaID  int    `json:"media_id,omitempty"`
+}
+
+type ImagePlaceholder struct {
+	BlurHash      string `json:"blurhash"`
+	LQIP          string `json:"lqip"`
+	DominantColor string `json:"dominant_color"`
+	AverageColor  string `json:"average_color"`
 }
 
 type ImageVariant struct {
-	URL        string `json:"url"`
-	Size       int64  `json:"size"`
-	Dimensions string `json:"dimensions"`
+	URL            string                 `json:"url"`
+	Size           int64                  `json:"size"`
+	Dimensions     string                 `json:"dimensions"`
+	Formats        map[ImageFormat]string `json:"formats,omitempty"`
+	Quality        int                    `json:"quality,omitempty"`
+	Score          float64                `json:"score,omitempty"`
+	MediaID        int                    `json:"media_id,omitempty"`
+	FormatMediaIDs map[ImageFormat]int    `json:"format_media_ids,omitempty"`
 }
 
 type CompressRequest struct {
-	JobID           string           `json:"job_id"`
-	PostID          int              `json:"post_id" binding:"required"`
-	UserID          *int             `json:"user_id"`
-	CompressionType CompressionType  `json:"compression_type" binding:"required"`
-	VideoData       *VideoData       `json:"video_data,omitempty"`
-	ImageData       *ImageData       `json:"image_data,omitempty"`
-	Priority        int              `json:"priority"`
-	ScheduledTime   *time.Time       `json:"scheduled_time,omitempty"`
+	JobID                string          `json:"job_id"`
+	PostID               int             `json:"post_id" binding:"required"`
+	UserID               *int            `json:"user_id"`
+	CompressionType      CompressionType `json:"compression_type" binding:"required"`
+	VideoData            *VideoData      `json:"video_data,omitempty"`
+	ImageData            *ImageData      `json:"image_data,omitempty"`
+	Priority             int             `json:"priority"`
+	ScheduledTime        *time.Time      `json:"scheduled_time,omitempty"`
+	ReuseDuplicate       bool            `json:"reuse_duplicate"`
+	DuplicateMaxDistance *int            `json:"duplicate_max_distance,omitempty"`
+	StorageBackend       string          `json:"storage_backend,omitempty"`
+	StorageMode          StorageMode     `json:"storage_mode,omitempty"`
+	AttachmentID         *int            `json:"attachment_id,omitempty"`
+	OutputNaming         string          `json:"output_naming,omitempty"`
 }
 
 type CompressResponse struct {
@@ -127,16 +273,19 @@ type CompressResponse struct {
 }
 
 type StatusResponse struct {
-	JobID              string          `json:"job_id"`
-	CompressionType    CompressionType `json:"compression_type"`
-	OverallStatus      JobStatus       `json:"overall_status"`
-	OverallProgress    int             `json:"overall_progress"`
-	VideoStatus        *JobStatus      `json:"video_status,omitempty"`
-	VideoProgress      *int            `json:"video_progress,omitempty"`
-	VideoCurrentStep   string          `json:"video_current_step,omitempty"`
-	ImageStatus        *JobStatus      `json:"image_status,omitempty"`
-	ImageProgress      *int            `json:"image_progress,omitempty"`
-	EstimatedTime      int             `json:"estimated_time"`
+	JobID               string          `json:"job_id"`
+	CompressionType     CompressionType `json:"compression_type"`
+	OverallStatus       JobStatus       `json:"overall_status"`
+	OverallProgress     int             `json:"overall_progress"`
+	VideoStatus         *JobStatus      `json:"video_status,omitempty"`
+	VideoProgress       *int            `json:"video_progress,omitempty"`
+	VideoCurrentStep    string          `json:"video_current_step,omitempty"`
+	VideoUploadProgress *int            `json:"video_upload_progress,omitempty"`
+	ImageStatus         *JobStatus      `json:"image_status,omitempty"`
+	ImageProgress       *int            `json:"image_progress,omitempty"`
+	ImageCurrentStep    string          `json:"image_current_step,omitempty"`
+	ImageUploadProgress *int            `json:"image_upload_progress,omitempty"`
+	EstimatedTime       int             `json:"estimated_time"`
 }
 
 type ResultResponse struct {
@@ -159,6 +308,51 @@ type QueueStats struct {
 	VideoJobs          int     `json:"video_jobs"`
 	ImageJobs          int     `json:"image_jobs"`
 	CombinedJobs       int     `json:"combined_jobs"`
+	TempDisk           *TempDiskUsage `json:"temp_disk,omitempty"`
+}
+
+// TempDiskUsage describes the disk the worker keeps temporary files on.
+// ReservedBytes is the space estimated for the jobs running now, which is
+// held back when new jobs are admitted. UsedBytes is the size of TempDir as
+// of MeasuredAt, which is nil until it was first measured.
+type TempDiskUsage struct {
+	Path          string     `json:"path"`
+	UsedBytes     int64      `json:"used_bytes"`
+	MeasuredAt    *time.Time `json:"measured_at,omitempty"`
+	FreeBytes     int64      `json:"free_bytes"`
+	TotalBytes    int64      `json:"total_bytes"`
+	ReservedBytes int64      `json:"reserved_bytes"`
+	MinFreeBytes  int64      `json:"min_free_bytes"`
+	Admitting     bool       `json:"admitting"`
+}
+
+// UnmarshalJSON accepts either a preset name such as "thumbnail" or a full
+// variant object.
+func (s *ImageVariantSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		if preset, ok := ImageVariantPresets[name]; ok {
+			*s = preset
+			return nil
+		}
+		*s = ImageVariantSpec{Name: name}
+		return nil
+	}
+
+	type Alias ImageVariantSpec
+	var spec Alias
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+	*s = ImageVariantSpec(spec)
+
+	if preset, ok := ImageVariantPresets[s.Name]; ok && s.Width == 0 && s.Height == 0 {
+		s.Width, s.Height = preset.Width, preset.Height
+		if s.Fit == "" {
+			s.Fit = preset.Fit
+		}
+	}
+	return nil
 }
 
 func (v *VideoData) MarshalJSON() ([]byte, error) {
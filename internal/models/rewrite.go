@@ -0,0 +1,62 @@
+package models
+
+// URLRewriteRule changes the URLs in a job's result when it is fetched,
+// e.g. to serve outputs from a CDN. It applies to URLs starting with
+// Prefix, or to every URL when Prefix is empty.
+type URLRewriteRule struct {
+	// Backend limits a tenant's rule to results stored on that backend.
+	Backend string `json:"backend,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	// BaseURL replaces Prefix, or the scheme and host when Prefix is empty.
+	BaseURL string `json:"base_url,omitempty"`
+	// PathPrefix is put in front of the rest of the path.
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// Query is added to the query string of every URL.
+	Query map[string]string `json:"query,omitempty"`
+	Token *URLToken         `json:"token,omitempty"`
+}
+
+// URLToken adds an expiring HMAC-SHA256 token to the query string, for CDNs
+// that check tokens at the edge.
+type URLToken struct {
+	Secret string `json:"secret,omitempty"`
+	// Param and ExpiresParam name the query parameters (default "token"
+	// and "expires").
+	Param        string `json:"param,omitempty"`
+	ExpiresParam string `json:"expires_param,omitempty"`
+	// TTL is how many seconds the token is valid (default 3600).
+	TTL int `json:"ttl,omitempty"`
+	// Placement is where the token of an HLS playlist goes: "query" (the
+	// default) like every other file, or "path". The variant playlists and
+	// segments a playlist references by relative path lose its query
+	// string, so with "query" the CDN can only check the playlist itself.
+	// "path" puts /{expires}/{token} in front of the playlist's path, with
+	// the token computed over its directory, so those references carry it
+	// too. Only choose it when the CDN strips those two segments before
+	// fetching from the origin and accepts a token that matches any
+	// directory above the requested file.
+	Placement string `json:"placement,omitempty"`
+}
+
+const (
+	TokenPlacementQuery = "query"
+	TokenPlacementPath  = "path"
+)
+
+// RedactedURLRewrites returns a copy of rules without the token secrets,
+// for API responses.
+func RedactedURLRewrites(rules []URLRewriteRule) []URLRewriteRule {
+	if rules == nil {
+		return nil
+	}
+	redacted := make([]URLRewriteRule, len(rules))
+	for i, rule := range rules {
+		if rule.Token != nil {
+			token := *rule.Token
+			token.Secret = ""
+			rule.Token = &token
+		}
+		redacted[i] = rule
+	}
+	return redacted
+}
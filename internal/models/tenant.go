@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Tenant is a site that talks to the service with its own API key. Its jobs
+// go to its own storage destination and fetch sources only from its hosts.
+type Tenant struct {
+	TenantID    string           `json:"tenant_id"`
+	Name        string           `json:"name"`
+	Storage     *TenantStorage   `json:"storage,omitempty"`
+	SourceHosts []string         `json:"source_hosts,omitempty"`
+	URLRewrites []URLRewriteRule `json:"url_rewrites,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+
+	// APIKeyHash is the hex SHA-256 of the tenant's API key. The key itself
+	// is only shown when it is created.
+	APIKeyHash string `json:"-"`
+	// SealedStorage is Storage as kept in the database, encrypted with the
+	// tenant encryption key.
+	SealedStorage []byte `json:"-"`
+	// SealedURLRewrites is URLRewrites as kept in the database, encrypted
+	// like the storage config since rules can hold token secrets.
+	SealedURLRewrites []byte `json:"-"`
+}
+
+// TenantStorage is a tenant's storage destination and its credentials.
+// Only the fields of the chosen backend are used.
+type TenantStorage struct {
+	Backend              string `json:"backend"`
+	WordPressAPIURL      string `json:"wordpress_api_url,omitempty"`
+	WordPressUsername    string `json:"wordpress_username,omitempty"`
+	WordPressAppPassword string `json:"wordpress_app_password,omitempty"`
+	LocalPath            string `json:"local_path,omitempty"`
+	LocalBaseURL         string `json:"local_base_url,omitempty"`
+	S3Endpoint           string `json:"s3_endpoint,omitempty"`
+	S3Region             string `json:"s3_region,omitempty"`
+	S3Bucket             string `json:"s3_bucket,omitempty"`
+	S3AccessKey          string `json:"s3_access_key,omitempty"`
+	S3SecretKey          string `json:"s3_secret_key,omitempty"`
+	S3PublicURL          string `json:"s3_public_url,omitempty"`
+	S3PathStyle          bool   `json:"s3_path_style,omitempty"`
+	SFTPAddr             string `json:"sftp_addr,omitempty"`
+	SFTPUsername         string `json:"sftp_username,omitempty"`
+	SFTPPassword         string `json:"sftp_password,omitempty"`
+	SFTPPrivateKey       string `json:"sftp_private_key,omitempty"`
+	SFTPHostKey          string `json:"sftp_host_key,omitempty"`
+	SFTPRoot             string `json:"sftp_root,omitempty"`
+	SFTPPublicURL        string `json:"sftp_public_url,omitempty"`
+	WebDAVURL            string `json:"webdav_url,omitempty"`
+	WebDAVUsername       string `json:"webdav_username,omitempty"`
+	WebDAVPassword       string `json:"webdav_password,omitempty"`
+	WebDAVPublicURL      string `json:"webdav_public_url,omitempty"`
+}
+
+// Redacted returns a copy without the secrets, for API responses.
+func (s *TenantStorage) Redacted() *TenantStorage {
+	if s == nil {
+		return nil
+	}
+	redacted := *s
+	redacted.WordPressAppPassword = ""
+	redacted.S3SecretKey = ""
+	redacted.SFTPPassword = ""
+	redacted.SFTPPrivateKey = ""
+	redacted.WebDAVPassword = ""
+	return &redacted
+}
+
+type TenantRequest struct {
+	Name        string           `json:"name" binding:"required"`
+	Storage     *TenantStorage   `json:"storage,omitempty"`
+	SourceHosts []string         `json:"source_hosts,omitempty"`
+	URLRewrites []URLRewriteRule `json:"url_rewrites,omitempty"`
+}
+
+// TenantResponse carries the API key, which is only returned when a tenant
+// is created or its key is rotated.
+type TenantResponse struct {
+	*Tenant
+	APIKey string `json:"api_key,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type UploadStatus string
+
+const (
+	UploadStatusUploading UploadStatus = "uploading"
+	UploadStatusComplete  UploadStatus = "complete"
+)
+
+// Upload is a source file pushed to the service instead of fetched from a
+// URL. Jobs reference it by UploadID in VideoData or ImageData.
+type Upload struct {
+	UploadID    string       `json:"upload_id"`
+	TenantID    string       `json:"tenant_id,omitempty"`
+	Filename    string       `json:"filename,omitempty"`
+	ContentType string       `json:"content_type,omitempty"`
+	Size        int64        `json:"size"`
+	Offset      int64        `json:"offset"`
+	SHA256      string       `json:"sha256,omitempty"`
+	Status      UploadStatus `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+}
@@ -14,6 +14,11 @@ const (
 	QueueKey          = "compression:queue"
 	ProcessingKey     = "compression:processing"
 	ProcessingJobsKey = "compression:processing:jobs"
+	// HeldKey maps the jobs held back for a paused storage target to that
+	// target. The jobs themselves wait in HeldQueuePrefix+target, with the
+	// score they had in the queue.
+	HeldKey         = "compression:held"
+	HeldQueuePrefix = "compression:held:"
 )
 
 type RedisQueue struct {
@@ -62,44 +67,131 @@ func (q *RedisQueue) Enqueue(jobID string, priority int) error {
 	return nil
 }
 
-func (q *RedisQueue) Dequeue() (string, error) {
-	result, err := q.client.ZPopMin(q.ctx, QueueKey, 1).Result()
+// Peek returns the job at the front of the queue without taking it, or ""
+// when the queue is empty.
+func (q *RedisQueue) Peek() (string, error) {
+	result, err := q.client.ZRange(q.ctx, QueueKey, 0, 0).Result()
 	if err != nil {
-		return "", fmt.Errorf("failed to dequeue job: %w", err)
+		return "", fmt.Errorf("failed to read queue: %w", err)
 	}
-
 	if len(result) == 0 {
 		return "", nil
 	}
+	return result[0], nil
+}
+
+var claimScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('SADD', KEYS[2], ARGV[1])
+return 1
+`)
+
+// Claim takes a job off the queue and marks it as processing. It returns
+// false when the job is no longer queued, because it was cancelled or
+// another worker claimed it first.
+func (q *RedisQueue) Claim(jobID string) (bool, error) {
+	claimed, err := claimScript.Run(q.ctx, q.client, []string{QueueKey, ProcessingJobsKey}, jobID).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim job: %w", err)
+	}
+	return claimed == 1, nil
+}
+
+var holdScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], score, ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+return 1
+`)
+
+// Hold moves a queued job aside while its storage target is paused. It
+// keeps its score, so Release puts it back in the place it had.
+func (q *RedisQueue) Hold(jobID, target string) error {
+	err := holdScript.Run(q.ctx, q.client, []string{QueueKey, HeldQueuePrefix + target, HeldKey}, jobID, target).Err()
+	if err != nil {
+		return fmt.Errorf("failed to hold job: %w", err)
+	}
+	return nil
+}
 
-	jobID := result[0].Member.(string)
+var releaseScript = redis.NewScript(`
+local jobs = redis.call('ZRANGE', KEYS[2], 0, -1, 'WITHSCORES')
+for i = 1, #jobs, 2 do
+	redis.call('ZADD', KEYS[1], jobs[i + 1], jobs[i])
+	redis.call('HDEL', KEYS[3], jobs[i])
+end
+redis.call('DEL', KEYS[2])
+return #jobs / 2
+`)
+
+// Release returns the jobs held for target to the queue.
+func (q *RedisQueue) Release(target string) (int, error) {
+	released, err := releaseScript.Run(q.ctx, q.client, []string{QueueKey, HeldQueuePrefix + target, HeldKey}).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to release jobs: %w", err)
+	}
+	return released, nil
+}
 
-	err = q.client.SAdd(q.ctx, ProcessingJobsKey, jobID).Err()
+// HeldTargets lists the storage targets that have jobs held back.
+func (q *RedisQueue) HeldTargets() ([]string, error) {
+	targets, err := q.client.HVals(q.ctx, HeldKey).Result()
 	if err != nil {
-		return "", fmt.Errorf("failed to mark job as processing: %w", err)
+		return nil, err
 	}
 
-	return jobID, nil
+	seen := make(map[string]bool)
+	unique := targets[:0]
+	for _, target := range targets {
+		if !seen[target] {
+			seen[target] = true
+			unique = append(unique, target)
+		}
+	}
+	return unique, nil
 }
 
 func (q *RedisQueue) MarkComplete(jobID string) error {
 	return q.client.SRem(q.ctx, ProcessingJobsKey, jobID).Err()
 }
 
+// GetQueueLength counts queued jobs, including those held back.
 func (q *RedisQueue) GetQueueLength() (int64, error) {
-	return q.client.ZCard(q.ctx, QueueKey).Result()
+	queued, err := q.client.ZCard(q.ctx, QueueKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	held, err := q.client.HLen(q.ctx, HeldKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	return queued + held, nil
 }
 
 func (q *RedisQueue) GetProcessingCount() (int64, error) {
 	return q.client.SCard(q.ctx, ProcessingJobsKey).Result()
 }
 
+var removeScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+local target = redis.call('HGET', KEYS[3], ARGV[1])
+if target then
+	redis.call('ZREM', ARGV[2] .. target, ARGV[1])
+	redis.call('HDEL', KEYS[3], ARGV[1])
+end
+return 1
+`)
+
+// RemoveJob takes a job out of the queue, wherever it waits.
 func (q *RedisQueue) RemoveJob(jobID string) error {
-	err := q.client.ZRem(q.ctx, QueueKey, jobID).Err()
-	if err != nil {
-		return err
-	}
-	return q.client.SRem(q.ctx, ProcessingJobsKey, jobID).Err()
+	return removeScript.Run(q.ctx, q.client, []string{QueueKey, ProcessingJobsKey, HeldKey}, jobID, HeldQueuePrefix).Err()
 }
 
 func (q *RedisQueue) CacheJobStatus(jobID string, status *models.StatusResponse, ttl time.Duration) error {
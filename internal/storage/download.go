@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const (
+	downloadRetryBase = time.Second
+	downloadRetryMax  = 30 * time.Second
+)
+
+// requestFunc builds the request for one download attempt. Each attempt gets
+// a fresh request so signed requests are signed again.
+type requestFunc func() (*http.Request, error)
+
+func getRequest(url string) requestFunc {
+	return func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		return req, nil
+	}
+}
+
+// downloadHTTP saves the response body to destPath. When a transfer breaks
+// off it is resumed from the last byte written with a Range request, up to
+// opts.Retries times with jittered exponential backoff.
+func downloadHTTP(client *http.Client, newRequest requestFunc, destPath string, opts DownloadOptions) error {
+	d, err := newDownload(destPath, opts)
+	if err != nil {
+		return err
+	}
+	defer d.out.Close()
+
+	for attempt := 0; ; attempt++ {
+		err := d.fetch(client, newRequest)
+		if err == nil {
+			break
+		}
+
+		if !IsRetryable(err) || attempt >= opts.Retries {
+			return err
+		}
+
+		delay := retryDelay(attempt)
+		log.Printf("Download to %s interrupted at %d bytes, retrying in %s: %v", destPath, d.written, delay, err)
+		time.Sleep(delay)
+	}
+
+	return d.finish()
+}
+
+// saveReader copies r to destPath with the same size and checksum checks as
+// downloadHTTP.
+func saveReader(r io.Reader, destPath string, opts DownloadOptions) error {
+	d, err := newDownload(destPath, opts)
+	if err != nil {
+		return err
+	}
+	defer d.out.Close()
+
+	if _, err := io.Copy(d, r); err != nil {
+		if d.writeErr != nil {
+			return d.writeErr
+		}
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	return d.finish()
+}
+
+// download is a file being written to disk. It hashes and counts the bytes
+// as they are written so a resumed transfer does not have to re-read them.
+type download struct {
+	out  *os.File
+	opts DownloadOptions
+	hash hash.Hash
+
+	written int64
+	// total is the full size of the file, -1 while unknown.
+	total int64
+	// validator is the ETag or Last-Modified of the first response. Resumed
+	// requests send it in If-Range so a changed file is fetched from scratch;
+	// without one a transfer cannot be resumed safely and starts over.
+	validator string
+	writeErr  error
+}
+
+func newDownload(destPath string, opts DownloadOptions) (*download, error) {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	return &download{
+		out:   out,
+		opts:  opts,
+		hash:  sha256.New(),
+		total: -1,
+	}, nil
+}
+
+func (d *download) fetch(client *http.Client, newRequest requestFunc) error {
+	req, err := newRequest()
+	if err != nil {
+		return err
+	}
+	if d.written > 0 && d.validator == "" {
+		if err := d.reset(); err != nil {
+			return err
+		}
+	}
+	if d.written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.written))
+		req.Header.Set("If-Range", d.validator)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, models.ErrSourceNotAllowed) {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+		return retryable(fmt.Errorf("failed to download file: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// Either the first attempt or the server ignored the range, in
+		// which case the file starts over.
+		if d.written > 0 {
+			if err := d.reset(); err != nil {
+				return err
+			}
+		}
+		d.total = resp.ContentLength
+		d.validator = validatorFor(resp)
+	case resp.StatusCode == http.StatusPartialContent && d.written > 0:
+		// A server that ignores If-Range may send part of a changed file.
+		if validator := validatorFor(resp); validator != "" && validator != d.validator {
+			if err := d.reset(); err != nil {
+				return err
+			}
+			return retryable(fmt.Errorf("failed to resume download: file changed"))
+		}
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != d.written {
+			if err := d.reset(); err != nil {
+				return err
+			}
+			return retryable(fmt.Errorf("failed to resume download: unexpected content range %q", resp.Header.Get("Content-Range")))
+		}
+		d.total = total
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && d.written > 0:
+		if d.written == d.total {
+			return nil
+		}
+		if err := d.reset(); err != nil {
+			return err
+		}
+		return retryable(fmt.Errorf("failed to resume download: status code %d", resp.StatusCode))
+	case retryableStatus(resp.StatusCode):
+		return retryable(fmt.Errorf("failed to download file: status code %d", resp.StatusCode))
+	default:
+		return fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+	}
+
+	if d.opts.MaxSize > 0 && d.total > d.opts.MaxSize {
+		return fmt.Errorf("%w: file is %d bytes, the limit is %d bytes", models.ErrLimitExceeded, d.total, d.opts.MaxSize)
+	}
+
+	if _, err := io.Copy(d, resp.Body); err != nil {
+		if d.writeErr != nil {
+			return d.writeErr
+		}
+		return retryable(fmt.Errorf("failed to download file: %w", err))
+	}
+	if d.total >= 0 && d.written < d.total {
+		return retryable(fmt.Errorf("failed to download file: got %d of %d bytes: %w", d.written, d.total, io.ErrUnexpectedEOF))
+	}
+	return nil
+}
+
+// Write enforces the size limit as bytes arrive, so an oversized file is
+// cut off even when the server does not announce its length.
+func (d *download) Write(p []byte) (int, error) {
+	if d.opts.MaxSize > 0 && d.written+int64(len(p)) > d.opts.MaxSize {
+		d.writeErr = fmt.Errorf("%w: file is larger than the limit of %d bytes", models.ErrLimitExceeded, d.opts.MaxSize)
+		return 0, d.writeErr
+	}
+
+	n, err := d.out.Write(p)
+	d.hash.Write(p[:n])
+	d.written += int64(n)
+	if err != nil {
+		d.writeErr = fmt.Errorf("failed to write file: %w", err)
+		return n, d.writeErr
+	}
+	return n, nil
+}
+
+func (d *download) reset() error {
+	if err := d.out.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	if _, err := d.out.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	d.hash.Reset()
+	d.written = 0
+	d.total = -1
+	d.validator = ""
+	return nil
+}
+
+func (d *download) finish() error {
+	if d.opts.SHA256 != "" {
+		sum := fmt.Sprintf("%x", d.hash.Sum(nil))
+		if !strings.EqualFold(sum, d.opts.SHA256) {
+			return fmt.Errorf("%w: expected sha256 %s, got %s", models.ErrChecksumMismatch, strings.ToLower(d.opts.SHA256), sum)
+		}
+	}
+	if err := d.out.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// validatorFor returns the value to send in If-Range. Weak ETags are not
+// allowed there, so those fall back to Last-Modified.
+func validatorFor(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// parseContentRange parses "bytes start-end/total". total is -1 when the
+// server sends "*".
+func parseContentRange(header string) (start, total int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+func retryDelay(attempt int) time.Duration {
+	delay := downloadRetryBase << attempt
+	if delay <= 0 || delay > downloadRetryMax {
+		delay = downloadRetryMax
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryableError marks failures a later attempt can recover from, such as
+// dropped connections and 5xx responses.
+type retryableError struct {
+	err error
+}
+
+func retryable(err error) error {
+	return &retryableError{err}
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// IsRetryable reports whether err is a transfer failure a later attempt can
+// recover from, as opposed to one the request or file itself causes.
+func IsRetryable(err error) bool {
+	var retry *retryableError
+	return errors.As(err, &retry)
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// httpObject reads an object over HTTP with ranged GETs starting at the
+// current offset, so seeking does not fetch what is skipped.
+type httpObject struct {
+	client     *http.Client
+	newRequest requestFunc
+	size       int64
+	offset     int64
+	body       io.ReadCloser
+}
+
+func newHTTPObject(client *http.Client, newRequest requestFunc, size int64) *httpObject {
+	return &httpObject{client: client, newRequest: newRequest, size: size}
+}
+
+func (o *httpObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		req, err := o.newRequest()
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.offset))
+
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read object: %w", err)
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.offset == 0) {
+			resp.Body.Close()
+			return 0, fmt.Errorf("failed to read object: status code %d", resp.StatusCode)
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *httpObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid seek offset %d", offset)
+	}
+
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *httpObject) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
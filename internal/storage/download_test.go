@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// testDownloadServer answers the nth request with the nth step and records
+// the Range and If-Range headers of each request.
+type testDownloadServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+type downloadStep func(w http.ResponseWriter, r *http.Request)
+
+func newTestDownloadServer(t *testing.T, steps ...downloadStep) *testDownloadServer {
+	t.Helper()
+	srv := &testDownloadServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		n := len(srv.requests)
+		srv.requests = append(srv.requests, "Range="+r.Header.Get("Range")+" If-Range="+r.Header.Get("If-Range"))
+		srv.mu.Unlock()
+		if n >= len(steps) {
+			http.Error(w, "unexpected request", http.StatusTeapot)
+			return
+		}
+		steps[n](w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *testDownloadServer) takeRequests() []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.requests
+}
+
+// broken announces the whole body but sends only its first n bytes, then
+// drops the connection.
+func broken(body string, n int, header map[string]string) downloadStep {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body[:n]))
+	}
+}
+
+func full(body string, header map[string]string) downloadStep {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}
+}
+
+func partial(body string, start int, header map[string]string) downloadStep {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(body)-1)+"/"+strconv.Itoa(len(body)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)-start))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body[start:]))
+	}
+}
+
+func TestDownloadHTTPResume(t *testing.T) {
+	const v1 = "0123456789abcdefghij"
+	const v2 = "the file was replaced"
+	etag := map[string]string{"ETag": `"v1"`}
+	lastModified := map[string]string{"Last-Modified": "Mon, 15 Jan 2024 10:00:00 GMT"}
+
+	tests := []struct {
+		name  string
+		steps []downloadStep
+		want  string
+		// requests are the Range and If-Range headers the server gets.
+		requests []string
+	}{
+		{
+			name:     "resumed with ETag",
+			steps:    []downloadStep{broken(v1, 8, etag), partial(v1, 8, etag)},
+			want:     v1,
+			requests: []string{"Range= If-Range=", `Range=bytes=8- If-Range="v1"`},
+		},
+		{
+			name:     "resumed with Last-Modified",
+			steps:    []downloadStep{broken(v1, 8, lastModified), partial(v1, 8, lastModified)},
+			want:     v1,
+			requests: []string{"Range= If-Range=", "Range=bytes=8- If-Range=Mon, 15 Jan 2024 10:00:00 GMT"},
+		},
+		{
+			name:     "weak ETag falls back to Last-Modified",
+			steps:    []downloadStep{broken(v1, 8, map[string]string{"ETag": `W/"v1"`, "Last-Modified": lastModified["Last-Modified"]}), partial(v1, 8, lastModified)},
+			want:     v1,
+			requests: []string{"Range= If-Range=", "Range=bytes=8- If-Range=Mon, 15 Jan 2024 10:00:00 GMT"},
+		},
+		{
+			name:     "no validator starts over",
+			steps:    []downloadStep{broken(v1, 8, nil), full(v1, nil)},
+			want:     v1,
+			requests: []string{"Range= If-Range=", "Range= If-Range="},
+		},
+		{
+			name:     "changed file sent whole",
+			steps:    []downloadStep{broken(v1, 8, etag), full(v2, map[string]string{"ETag": `"v2"`})},
+			want:     v2,
+			requests: []string{"Range= If-Range=", `Range=bytes=8- If-Range="v1"`},
+		},
+		{
+			name: "changed file sent in part",
+			steps: []downloadStep{
+				broken(v1, 8, etag),
+				partial(v2, 8, map[string]string{"ETag": `"v2"`}),
+				full(v2, map[string]string{"ETag": `"v2"`}),
+			},
+			want:     v2,
+			requests: []string{"Range= If-Range=", `Range=bytes=8- If-Range="v1"`, "Range= If-Range="},
+		},
+		{
+			name:     "range ignored",
+			steps:    []downloadStep{broken(v1, 8, etag), full(v1, etag)},
+			want:     v1,
+			requests: []string{"Range= If-Range=", `Range=bytes=8- If-Range="v1"`},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := newTestDownloadServer(t, tt.steps...)
+			dest := filepath.Join(t.TempDir(), "source.mp4")
+
+			err := downloadHTTP(srv.Client(), getRequest(srv.URL+"/source.mp4"), dest, DownloadOptions{Retries: 2})
+			if err != nil {
+				t.Fatalf("downloadHTTP: %v", err)
+			}
+			if got, err := os.ReadFile(dest); err != nil || string(got) != tt.want {
+				t.Errorf("downloaded %q, %v, want %q", got, err, tt.want)
+			}
+			requests := srv.takeRequests()
+			if len(requests) != len(tt.requests) {
+				t.Fatalf("requests = %q, want %q", requests, tt.requests)
+			}
+			for i := range requests {
+				if requests[i] != tt.requests[i] {
+					t.Errorf("request %d = %q, want %q", i, requests[i], tt.requests[i])
+				}
+			}
+		})
+	}
+}
+
+// The checksum covers the bytes of every attempt, and only those of the
+// file that was finally downloaded.
+func TestDownloadHTTPChecksumAfterResume(t *testing.T) {
+	const body = "0123456789abcdefghij"
+	const sum = "6bc14bdc4517a7a682c6910de2e2946eb8e1ecd04090728fef6d092a7ceb62c5"
+	etag := map[string]string{"ETag": `"v1"`}
+
+	tests := []struct {
+		name  string
+		steps []downloadStep
+	}{
+		{"resumed", []downloadStep{broken(body, 8, etag), partial(body, 8, etag)}},
+		{"started over", []downloadStep{broken("stale content", 8, nil), full(body, nil)}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv := newTestDownloadServer(t, tt.steps...)
+			dest := filepath.Join(t.TempDir(), "source.mp4")
+			if err := downloadHTTP(srv.Client(), getRequest(srv.URL), dest, DownloadOptions{Retries: 1, SHA256: sum}); err != nil {
+				t.Errorf("downloadHTTP = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage keeps outputs in a directory on the host. baseURL is where
+// that directory is served from, e.g. by nginx.
+type LocalStorage struct {
+	root    string
+	baseURL string
+	client  *http.Client
+}
+
+func NewLocalStorage(root, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return &LocalStorage{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Timeout: 10 * time.Minute,
+		},
+	}, nil
+}
+
+func (l *LocalStorage) Name() string {
+	return BackendLocal
+}
+
+// Download copies URLs under baseURL straight from disk and fetches anything
+// else over HTTP.
+func (l *LocalStorage) Download(url, destPath string, opts DownloadOptions) error {
+	if l.ownsURL(url) {
+		key := strings.TrimPrefix(url, l.baseURL+"/")
+		localPath, err := l.path(key)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+		defer file.Close()
+
+		return saveReader(file, destPath, opts)
+	}
+
+	return downloadHTTP(sourceClient(l.client, opts), getRequest(url), destPath, opts)
+}
+
+func (l *LocalStorage) ownsURL(url string) bool {
+	return l.baseURL != "" && strings.HasPrefix(url, l.baseURL+"/")
+}
+
+func (l *LocalStorage) Upload(filePath, key string, opts UploadOptions) (*UploadedObject, error) {
+	destPath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write next to the destination and rename, so readers never see a
+	// partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, newProgressReader(src, info.Size(), opts.Progress)); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), destPath); err != nil {
+		return nil, fmt.Errorf("failed to store file: %w", err)
+	}
+
+	return &UploadedObject{Key: key, URL: l.PublicURL(key)}, nil
+}
+
+func (l *LocalStorage) UploadTree(localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error) {
+	return uploadTree(l, localDir, prefix, opts)
+}
+
+func (l *LocalStorage) Delete(key string) error {
+	localPath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(localPath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	localPath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(localPath)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	return localObjectInfo(key, info), nil
+}
+
+// KeyForURL accepts URLs under baseURL, or paths starting with "/" when no
+// base URL is configured, as PublicURL returns them.
+func (l *LocalStorage) KeyForURL(rawURL string) (string, bool) {
+	key, ok := strings.CutPrefix(rawURL, l.baseURL+"/")
+	if !ok {
+		return "", false
+	}
+	if _, err := cleanKey(key); err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+func (l *LocalStorage) Open(key string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	localPath, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(localPath)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		if err == nil {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	return file, localObjectInfo(key, info), nil
+}
+
+func localObjectInfo(key string, info os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:         key,
+		Size:        info.Size(),
+		ContentType: contentTypeFor(key),
+		ModTime:     info.ModTime(),
+		ETag:        fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+	}
+}
+
+func (l *LocalStorage) PublicURL(key string) string {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return ""
+	}
+	return l.baseURL + "/" + cleaned
+}
+
+func (l *LocalStorage) path(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
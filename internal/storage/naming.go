@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultKeyTemplate keeps the outputs of every job under a prefix of its
+// own.
+const DefaultKeyTemplate = "{job_id}/{variant}.{ext}"
+
+var (
+	keyPlaceholder  = regexp.MustCompile(`\{[^{}]*\}`)
+	unsafeKeyChars  = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	unsafeSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+var keyPlaceholders = map[string]bool{
+	"{job_id}":  true,
+	"{post_id}": true,
+	"{slug}":    true,
+	"{variant}": true,
+	"{ext}":     true,
+}
+
+// KeyTemplate names the outputs of a job on its storage backend. {variant}
+// tells the outputs of one job apart and {ext} is the file extension
+// without the dot; {job_id}, {post_id} and {slug} describe the job.
+type KeyTemplate struct {
+	pattern string
+	// keys is pattern with a {job_id} directory before the file name when
+	// pattern has none, so two jobs for the same post and title never
+	// overwrite each other's outputs.
+	keys string
+}
+
+// KeyFields are the values a template's job placeholders take.
+type KeyFields struct {
+	JobID  string
+	PostID int
+	// Slug is turned into lower-case words joined by dashes.
+	Slug string
+}
+
+// ParseKeyTemplate checks a caller-supplied template. An empty one gives
+// DefaultKeyTemplate.
+func ParseKeyTemplate(pattern string) (*KeyTemplate, error) {
+	if pattern == "" {
+		pattern = DefaultKeyTemplate
+	}
+
+	for _, placeholder := range keyPlaceholder.FindAllString(pattern, -1) {
+		if !keyPlaceholders[placeholder] {
+			return nil, fmt.Errorf("unknown placeholder %s", placeholder)
+		}
+	}
+	for _, required := range []string{"{variant}", "{ext}"} {
+		if !strings.Contains(pattern, required) {
+			return nil, fmt.Errorf("template must contain {variant} and {ext}")
+		}
+	}
+	if strings.ContainsAny(keyPlaceholder.ReplaceAllString(pattern, ""), "{}\\") {
+		return nil, fmt.Errorf("template has unbalanced braces or a backslash")
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == ".." {
+			return nil, fmt.Errorf("template must not contain '..'")
+		}
+	}
+
+	keys := pattern
+	if !strings.Contains(pattern, "{job_id}") {
+		dir, file := path.Split(pattern)
+		keys = dir + "{job_id}/" + file
+	}
+	return &KeyTemplate{pattern: pattern, keys: keys}, nil
+}
+
+func (t *KeyTemplate) String() string {
+	return t.pattern
+}
+
+// Key renders the key of one output. An empty ext names a directory, such
+// as an HLS package, and drops the dot before it. Values are reduced to
+// characters that are safe in keys on every backend.
+func (t *KeyTemplate) Key(fields KeyFields, variant, ext string) string {
+	slug := strings.Trim(unsafeSlugChars.ReplaceAllString(strings.ToLower(fields.Slug), "-"), "-")
+	if slug == "" {
+		slug = "media"
+	}
+
+	key := keyPlaceholder.ReplaceAllStringFunc(t.keys, func(placeholder string) string {
+		switch placeholder {
+		case "{job_id}":
+			return safeKeyValue(fields.JobID)
+		case "{post_id}":
+			return strconv.Itoa(fields.PostID)
+		case "{slug}":
+			return slug
+		case "{variant}":
+			return safeKeyValue(variant)
+		case "{ext}":
+			if ext == "" {
+				return ""
+			}
+			return safeKeyValue(ext)
+		}
+		return placeholder
+	})
+	if ext == "" {
+		key = strings.TrimSuffix(key, ".")
+	}
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// SharedKey renders the template without the job ID and output. Outputs
+// stored for one job can stand in for another's only when both jobs have
+// the same shared key.
+func (t *KeyTemplate) SharedKey(fields KeyFields) string {
+	return t.Key(KeyFields{PostID: fields.PostID, Slug: fields.Slug}, "", "")
+}
+
+func safeKeyValue(value string) string {
+	value = strings.Trim(unsafeKeyChars.ReplaceAllString(value, "-"), "-.")
+	if value == "" {
+		return "-"
+	}
+	return value
+}
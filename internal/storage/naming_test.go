@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeyTemplate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr string
+	}{
+		{pattern: ""},
+		{pattern: "{job_id}/{variant}.{ext}"},
+		{pattern: "{post_id}/{slug}-{variant}.{ext}"},
+		{pattern: "media/{post_id}/{job_id}-{slug}-{variant}.{ext}"},
+		{pattern: "{job_id}/{name}.{ext}", wantErr: "unknown placeholder {name}"},
+		{pattern: "{job_id}/{slug}.{ext}", wantErr: "must contain {variant} and {ext}"},
+		{pattern: "{job_id}/{variant}", wantErr: "must contain {variant} and {ext}"},
+		{pattern: "{job_id}/{variant}.{ext}}", wantErr: "unbalanced braces"},
+		{pattern: `{job_id}\{variant}.{ext}`, wantErr: "backslash"},
+		{pattern: "../{job_id}/{variant}.{ext}", wantErr: "must not contain '..'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			keys, err := ParseKeyTemplate(tt.pattern)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseKeyTemplate = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyTemplate = %v", err)
+			}
+			if want := tt.pattern; want != "" && keys.String() != want {
+				t.Errorf("String() = %q, want %q", keys.String(), want)
+			}
+		})
+	}
+}
+
+func TestKeyTemplateKey(t *testing.T) {
+	fields := KeyFields{JobID: "a1b2", PostID: 42, Slug: "Beach Day, 2024!"}
+
+	tests := []struct {
+		pattern string
+		fields  KeyFields
+		variant string
+		ext     string
+		want    string
+	}{
+		{"", fields, "medium", "webp", "a1b2/medium.webp"},
+		{"", fields, "hls", "", "a1b2/hls"},
+		{"{post_id}/{job_id}-{slug}-{variant}.{ext}", fields, "medium", "webp", "42/a1b2-beach-day-2024-medium.webp"},
+		{"{post_id}/{slug}-{variant}.{ext}", fields, "medium", "webp", "42/a1b2/beach-day-2024-medium.webp"},
+		{"{slug}-{variant}.{ext}", fields, "compressed", "mp4", "a1b2/beach-day-2024-compressed.mp4"},
+		{"{post_id}/{slug}-{variant}.{ext}", fields, "hls", "", "42/a1b2/beach-day-2024-hls"},
+		{"{job_id}/{slug}.{variant}.{ext}", KeyFields{JobID: "a1b2", Slug: "!!!"}, "thumb", "webp", "a1b2/media.thumb.webp"},
+		{"{job_id}/{variant}.{ext}", KeyFields{JobID: "../x"}, "../../etc", "webp", "x/etc.webp"},
+		{"/{job_id}//{variant}.{ext}", fields, "a b", "webp", "a1b2/a-b.webp"},
+	}
+	for _, tt := range tests {
+		keys, err := ParseKeyTemplate(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParseKeyTemplate(%q) = %v", tt.pattern, err)
+		}
+		if got := keys.Key(tt.fields, tt.variant, tt.ext); got != tt.want {
+			t.Errorf("%q.Key(%+v, %q, %q) = %q, want %q", tt.pattern, tt.fields, tt.variant, tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestKeyTemplateSharedKey(t *testing.T) {
+	job := KeyFields{JobID: "a1b2", PostID: 42, Slug: "Beach"}
+
+	tests := []struct {
+		name    string
+		pattern string
+		other   KeyFields
+		shared  bool
+	}{
+		{"default template, other job", "", KeyFields{JobID: "c3d4", PostID: 7, Slug: "Other"}, true},
+		{"post in template, same post", "{post_id}/{slug}-{variant}.{ext}", KeyFields{JobID: "c3d4", PostID: 42, Slug: "beach"}, true},
+		{"post in template, other post", "{post_id}/{slug}-{variant}.{ext}", KeyFields{JobID: "c3d4", PostID: 7, Slug: "Beach"}, false},
+		{"slug in template, other title", "{job_id}/{slug}-{variant}.{ext}", KeyFields{JobID: "c3d4", PostID: 42, Slug: "Sunset"}, false},
+		{"slug not in template, other title", "{post_id}/{variant}.{ext}", KeyFields{JobID: "c3d4", PostID: 42, Slug: "Sunset"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeyTemplate(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, b := keys.SharedKey(job), keys.SharedKey(tt.other)
+			if (a == b) != tt.shared {
+				t.Errorf("SharedKey = %q and %q, want equal %v", a, b, tt.shared)
+			}
+			if strings.Contains(a, job.JobID) {
+				t.Errorf("SharedKey %q contains the job ID", a)
+			}
+		})
+	}
+}
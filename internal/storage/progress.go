@@ -0,0 +1,48 @@
+package storage
+
+import "io"
+
+// ProgressFunc receives the number of bytes sent so far and the total. It is
+// called from the goroutine sending the request body.
+type ProgressFunc func(sent, total int64)
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func newProgressReader(r io.Reader, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &progressReader{r: r, total: total, progress: progress}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent, p.total)
+	}
+	return n, err
+}
+
+// treeProgress reports a sequence of uploads as a single one.
+type treeProgress struct {
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (t *treeProgress) file(size int64) ProgressFunc {
+	if t.progress == nil {
+		return nil
+	}
+	base := t.sent
+	t.sent += size
+	return func(sent, _ int64) {
+		t.progress(base+sent, t.total)
+	}
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const (
+	defaultTokenParam   = "token"
+	defaultExpiresParam = "expires"
+	defaultTokenTTL     = 3600
+)
+
+// ParseURLRewrites reads the rules of the deployment's backends, given as
+// a JSON object from backend name to a list of rules.
+func ParseURLRewrites(raw string) (map[string][]models.URLRewriteRule, error) {
+	rewrites := make(map[string][]models.URLRewriteRule)
+	if strings.TrimSpace(raw) == "" {
+		return rewrites, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &rewrites); err != nil {
+		return nil, fmt.Errorf("invalid url rewrites: %w", err)
+	}
+	for backend, rules := range rewrites {
+		if err := ValidateURLRewrites(rules); err != nil {
+			return nil, fmt.Errorf("invalid url rewrites for %s: %w", backend, err)
+		}
+	}
+	return rewrites, nil
+}
+
+func ValidateURLRewrites(rules []models.URLRewriteRule) error {
+	for i, rule := range rules {
+		if rule.BaseURL != "" {
+			u, err := url.Parse(rule.BaseURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("rule %d: base_url must be an http or https URL", i)
+			}
+			if u.RawQuery != "" || u.Fragment != "" {
+				return fmt.Errorf("rule %d: base_url must not have a query or fragment", i)
+			}
+		}
+		if rule.PathPrefix != "" {
+			if _, err := cleanKey(rule.PathPrefix); err != nil || strings.ContainsAny(rule.PathPrefix, "?#") {
+				return fmt.Errorf("rule %d: invalid path_prefix %q", i, rule.PathPrefix)
+			}
+		}
+		if rule.Token != nil {
+			if rule.Token.Secret == "" {
+				return fmt.Errorf("rule %d: token needs a secret", i)
+			}
+			if rule.Token.TTL < 0 {
+				return fmt.Errorf("rule %d: token ttl must not be negative", i)
+			}
+			switch rule.Token.Placement {
+			case "", models.TokenPlacementQuery, models.TokenPlacementPath:
+			default:
+				return fmt.Errorf("rule %d: token placement must be 'query' or 'path'", i)
+			}
+		}
+		if rule.BaseURL == "" && rule.PathPrefix == "" && len(rule.Query) == 0 && rule.Token == nil {
+			return fmt.Errorf("rule %d changes nothing", i)
+		}
+	}
+	return nil
+}
+
+// URLRewriter rewrites result URLs with the first rule that matches each
+// one. Tokens expire relative to the time it was created for.
+type URLRewriter struct {
+	rules []models.URLRewriteRule
+	now   time.Time
+}
+
+// NewURLRewriter combines rule sets in order of precedence, keeping rules
+// that apply to backend. It returns nil when no rule is left.
+func NewURLRewriter(backend string, now time.Time, ruleSets ...[]models.URLRewriteRule) *URLRewriter {
+	var rules []models.URLRewriteRule
+	for _, set := range ruleSets {
+		for _, rule := range set {
+			if rule.Backend == "" || rule.Backend == backend {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &URLRewriter{rules: rules, now: now}
+}
+
+func (r *URLRewriter) Rewrite(rawURL string) string {
+	for _, rule := range r.rules {
+		if rewritten, ok := r.apply(rule, rawURL); ok {
+			return rewritten
+		}
+	}
+	return rawURL
+}
+
+func (r *URLRewriter) apply(rule models.URLRewriteRule, rawURL string) (string, bool) {
+	var base, rest string
+	if rule.Prefix != "" {
+		var ok bool
+		if rest, ok = strings.CutPrefix(rawURL, rule.Prefix); !ok {
+			return "", false
+		}
+		base = rule.Prefix
+	} else {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" {
+			return "", false
+		}
+		base = u.Scheme + "://" + u.Host
+		rest = strings.TrimPrefix(rawURL, base)
+	}
+	if rule.BaseURL != "" {
+		base = rule.BaseURL
+	}
+
+	joined := strings.TrimSuffix(base, "/")
+	if rule.PathPrefix != "" {
+		joined += "/" + strings.Trim(rule.PathPrefix, "/")
+	}
+	if rest != "" && !strings.HasPrefix(rest, "?") {
+		joined += "/" + strings.TrimPrefix(rest, "/")
+	} else {
+		joined += rest
+	}
+
+	u, err := url.Parse(joined)
+	if err != nil {
+		return "", false
+	}
+	if len(rule.Query) == 0 && rule.Token == nil {
+		return u.String(), true
+	}
+
+	query := u.Query()
+	for name, value := range rule.Query {
+		query.Set(name, value)
+	}
+	if token := rule.Token; token != nil {
+		ttl := token.TTL
+		if ttl == 0 {
+			ttl = defaultTokenTTL
+		}
+		expires := strconv.FormatInt(r.now.Add(time.Duration(ttl)*time.Second).Unix(), 10)
+		escapedPath := u.EscapedPath()
+		if scope, ok := playlistScope(escapedPath); ok && token.Placement == models.TokenPlacementPath {
+			prefix := "/" + expires + "/" + urlToken(token.Secret, scope, expires)
+			u.Path = prefix + u.Path
+			u.RawPath = prefix + escapedPath
+		} else {
+			query.Set(orDefault(token.ExpiresParam, defaultExpiresParam), expires)
+			query.Set(orDefault(token.Param, defaultTokenParam), urlToken(token.Secret, escapedPath, expires))
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), true
+}
+
+// urlToken is the token a CDN checks: the unpadded base64url
+// HMAC-SHA256 of the escaped URL path and the expiry, joined by a newline.
+func urlToken(secret, escapedPath, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(escapedPath + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// playlistScope returns the directory a token for an HLS playlist covers,
+// which holds the variant playlists and segments it references, as
+// URLSigner.Path does for signed file URLs.
+func playlistScope(escapedPath string) (string, bool) {
+	if !strings.EqualFold(path.Ext(escapedPath), ".m3u8") {
+		return "", false
+	}
+	dir := path.Dir(escapedPath)
+	if dir == "/" || dir == "." {
+		return "", false
+	}
+	return dir + "/", true
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
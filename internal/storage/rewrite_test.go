@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+func TestURLRewriterToken(t *testing.T) {
+	now := time.Unix(1705318200, 0)
+	rewriter := func(placement string) *URLRewriter {
+		return NewURLRewriter(BackendS3, now, []models.URLRewriteRule{{
+			Prefix:  "https://media.s3.amazonaws.com/",
+			BaseURL: "https://cdn.example.com/media/",
+			Token:   &models.URLToken{Secret: "secret", Placement: placement},
+		}})
+	}
+	const expires = "1705321800"
+
+	tests := []struct {
+		name      string
+		placement string
+		url       string
+		want      string
+	}{
+		{
+			name: "file",
+			url:  "https://media.s3.amazonaws.com/a1b2/medium.webp",
+			want: "https://cdn.example.com/media/a1b2/medium.webp?expires=" + expires + "&token=" +
+				urlToken("secret", "/media/a1b2/medium.webp", expires),
+		},
+		{
+			name: "playlist",
+			url:  "https://media.s3.amazonaws.com/a1b2/hls/master.m3u8",
+			want: "https://cdn.example.com/media/a1b2/hls/master.m3u8?expires=" + expires + "&token=" +
+				urlToken("secret", "/media/a1b2/hls/master.m3u8", expires),
+		},
+		{
+			name:      "file with path placement",
+			placement: models.TokenPlacementPath,
+			url:       "https://media.s3.amazonaws.com/a1b2/medium.webp",
+			want: "https://cdn.example.com/media/a1b2/medium.webp?expires=" + expires + "&token=" +
+				urlToken("secret", "/media/a1b2/medium.webp", expires),
+		},
+		{
+			name:      "playlist with path placement",
+			placement: models.TokenPlacementPath,
+			url:       "https://media.s3.amazonaws.com/a1b2/hls/master.m3u8",
+			want: "https://cdn.example.com/" + expires + "/" + urlToken("secret", "/media/a1b2/hls/", expires) +
+				"/media/a1b2/hls/master.m3u8",
+		},
+		{
+			name:      "playlist with escaped path and path placement",
+			placement: models.TokenPlacementPath,
+			url:       "https://media.s3.amazonaws.com/a1b2/my%20clip/master.M3U8",
+			want: "https://cdn.example.com/" + expires + "/" + urlToken("secret", "/media/a1b2/my%20clip/", expires) +
+				"/media/a1b2/my%20clip/master.M3U8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriter(tt.placement).Rewrite(tt.url); got != tt.want {
+				t.Errorf("Rewrite(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateURLRewritesTokenPlacement(t *testing.T) {
+	for placement, valid := range map[string]bool{"": true, "query": true, "path": true, "header": false} {
+		err := ValidateURLRewrites([]models.URLRewriteRule{{Token: &models.URLToken{Secret: "secret", Placement: placement}}})
+		if (err == nil) != valid {
+			t.Errorf("placement %q: ValidateURLRewrites = %v, want valid %v", placement, err, valid)
+		}
+	}
+}
+
+// With path placement, the variant playlists and segments a master playlist
+// lists by relative path resolve to URLs that carry its token, and a CDN
+// checking the token against their directories accepts them.
+func TestURLRewriterPlaylistTokenCoversReferences(t *testing.T) {
+	now := time.Unix(1705318200, 0)
+	rules := []models.URLRewriteRule{{Token: &models.URLToken{Secret: "secret", Placement: models.TokenPlacementPath}}}
+	master, err := url.Parse(NewURLRewriter(BackendS3, now, rules).Rewrite("https://cdn.example.com/media/a1b2/hls/master.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(u *url.URL) bool {
+		segments := strings.SplitN(strings.TrimPrefix(u.EscapedPath(), "/"), "/", 3)
+		if len(segments) != 3 {
+			return false
+		}
+		expires, token, rest := segments[0], segments[1], "/"+segments[2]
+		for dir := rest; dir != "/"; {
+			dir = dir[:strings.LastIndex(strings.TrimSuffix(dir, "/"), "/")+1]
+			if urlToken("secret", dir, expires) == token {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		ref   string
+		valid bool
+	}{
+		{"720p/playlist.m3u8", true},
+		{"720p/segment_000.ts", true},
+		{"segment_000.ts", true},
+		{"../other/secret.mp4", false},
+		{"/media/a1b2/hls/720p/segment_000.ts", false},
+	}
+	for _, tt := range tests {
+		resolved := master.ResolveReference(&url.URL{Path: tt.ref})
+		if got := verify(resolved); got != tt.valid {
+			t.Errorf("%s resolved to %s, token valid %v, want %v", tt.ref, resolved, got, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is the base URL objects are served from, e.g. a CDN. When
+	// empty the object's endpoint URL is used.
+	PublicURL string
+	// PathStyle addresses objects as endpoint/bucket/key instead of
+	// bucket.endpoint/key. MinIO and most self-hosted stores need it.
+	PathStyle bool
+}
+
+// S3Storage talks to Amazon S3 or any S3-compatible store (MinIO, R2,
+// Spaces, ...) with SigV4-signed requests.
+type S3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	signer    *sigV4Signer
+	publicURL string
+	pathStyle bool
+	client    *http.Client
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	parsed, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+
+	return &S3Storage{
+		endpoint:  parsed,
+		region:    region,
+		bucket:    cfg.Bucket,
+		signer:    &sigV4Signer{accessKey: cfg.AccessKey, secretKey: cfg.SecretKey, region: region, service: "s3"},
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		pathStyle: cfg.PathStyle,
+		client: &http.Client{
+			Timeout: 10 * time.Minute,
+		},
+	}, nil
+}
+
+func (s *S3Storage) Name() string {
+	return BackendS3
+}
+
+// Download accepts s3://bucket/key URLs and URLs of objects in the
+// configured bucket, which are fetched with signed requests; other URLs are
+// fetched anonymously over HTTP.
+func (s *S3Storage) Download(rawURL, destPath string, opts DownloadOptions) error {
+	if key, ok := s.keyForURL(rawURL); ok {
+		return downloadHTTP(s.client, func() (*http.Request, error) {
+			return s.newRequest("GET", key, nil)
+		}, destPath, opts)
+	}
+	return downloadHTTP(sourceClient(s.client, opts), getRequest(rawURL), destPath, opts)
+}
+
+// Upload puts the object, retrying failed attempts. A PUT to the same key
+// replaces the object, so retries never leave duplicates behind.
+func (s *S3Storage) Upload(filePath, key string, opts UploadOptions) (*UploadedObject, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return retryUpload(key, opts, func(int) (*UploadedObject, error) {
+		return s.put(filePath, key, opts.Progress)
+	})
+}
+
+func (s *S3Storage) put(filePath, key string, progress ProgressFunc) (*UploadedObject, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	req, err := s.newRequest("PUT", key, newProgressReader(file, info.Size(), progress))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentTypeFor(key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, retryable(fmt.Errorf("failed to upload file: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("failed to upload file: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+		if retryableStatus(resp.StatusCode) {
+			return nil, retryable(err)
+		}
+		return nil, err
+	}
+
+	return &UploadedObject{Key: key, URL: s.PublicURL(key)}, nil
+}
+
+func (s *S3Storage) UploadTree(localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error) {
+	return uploadTree(s, localDir, prefix, opts)
+}
+
+func (s *S3Storage) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete object: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.newRequest("HEAD", key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get object info: status code %d", resp.StatusCode)
+	}
+
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+func (s *S3Storage) PublicURL(key string) string {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return ""
+	}
+	if s.publicURL != "" {
+		return s.publicURL + "/" + uriEncode(cleaned, false)
+	}
+	return s.objectURL(cleaned).String()
+}
+
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = ""
+	return &u
+}
+
+func (s *S3Storage) ownsURL(rawURL string) bool {
+	_, ok := s.keyForURL(rawURL)
+	return ok
+}
+
+func (s *S3Storage) keyForURL(rawURL string) (string, bool) {
+	if rest, ok := strings.CutPrefix(rawURL, "s3://"); ok {
+		bucket, key, found := strings.Cut(rest, "/")
+		if found && bucket == s.bucket && key != "" {
+			return key, true
+		}
+		return "", false
+	}
+
+	for _, base := range []string{s.publicURL, strings.TrimSuffix(s.objectURL("").String(), "/")} {
+		if base == "" {
+			continue
+		}
+		if key, ok := strings.CutPrefix(rawURL, base+"/"); ok && key != "" {
+			if unescaped, err := url.PathUnescape(key); err == nil {
+				key = unescaped
+			}
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (s *S3Storage) KeyForURL(rawURL string) (string, bool) {
+	return s.keyForURL(rawURL)
+}
+
+// Open returns a reader that fetches the object with ranged GETs starting
+// at the current offset.
+func (s *S3Storage) Open(key string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.ContentType == "" || info.ContentType == "binary/octet-stream" {
+		info.ContentType = contentTypeFor(info.Key)
+	}
+	return newHTTPObject(s.client, func() (*http.Request, error) {
+		return s.newRequest("GET", info.Key, nil)
+	}, info.Size), info, nil
+}
+
+func (s *S3Storage) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	u := s.objectURL(key)
+	u.RawPath = uriEncode(u.Path, false)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	s.signer.sign(req, unsignedPayload, time.Now())
+	return req, nil
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 stores objects of one bucket in memory and records how each
+// request addressed them.
+type fakeS3 struct {
+	bucket    string
+	pathStyle bool
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	ranges  []string
+	paths   []string
+}
+
+func newFakeS3(bucket string, pathStyle bool) *fakeS3 {
+	return &fakeS3{
+		bucket:    bucket,
+		pathStyle: pathStyle,
+		objects:   make(map[string][]byte),
+		types:     make(map[string]string),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != unsignedPayload {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	var key string
+	var ok bool
+	if f.pathStyle {
+		key, ok = strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+		ok = ok && r.Host == "s3.test"
+	} else {
+		key, ok = strings.CutPrefix(r.URL.Path, "/")
+		ok = ok && r.Host == f.bucket+".s3.test"
+	}
+	if !ok || key == "" {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths = append(f.paths, r.Method+" "+r.URL.EscapedPath())
+
+	switch r.Method {
+	case "PUT":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"etag"`)
+	case "GET", "HEAD":
+		body, found := f.objects[key]
+		if !found {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		if r.Method == "GET" {
+			f.ranges = append(f.ranges, r.Header.Get("Range"))
+		}
+		w.Header().Set("Content-Type", f.types[key])
+		w.Header().Set("ETag", `"etag"`)
+		http.ServeContent(w, r, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(body))
+	case "DELETE":
+		if _, found := f.objects[key]; !found {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// newTestS3 points an S3Storage at fake, resolving every host to the test
+// server so virtual-host URLs reach it too.
+func newTestS3(t *testing.T, fake *fakeS3) *S3Storage {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3Storage(S3Config{
+		Endpoint:  "http://s3.test",
+		Bucket:    fake.bucket,
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		PathStyle: fake.pathStyle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Listener.Addr().String()
+	s.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	return s
+}
+
+func TestS3Storage(t *testing.T) {
+	tests := []struct {
+		name      string
+		pathStyle bool
+		objectURL string
+		wantPath  string
+	}{
+		{
+			name:      "path style",
+			pathStyle: true,
+			objectURL: "http://s3.test/media/videos/clip%20one.mp4",
+			wantPath:  "/media/videos/clip%20one.mp4",
+		},
+		{
+			name:      "virtual host",
+			objectURL: "http://media.s3.test/videos/clip%20one.mp4",
+			wantPath:  "/videos/clip%20one.mp4",
+		},
+	}
+
+	const key = "videos/clip one.mp4"
+	content := []byte("0123456789abcdefghij")
+	localPath := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3("media", tt.pathStyle)
+			s := newTestS3(t, fake)
+
+			object, err := s.Upload(localPath, key, UploadOptions{})
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if object.URL != tt.objectURL {
+				t.Errorf("URL = %q, want %q", object.URL, tt.objectURL)
+			}
+			if got, ok := s.KeyForURL(object.URL); !ok || got != key {
+				t.Errorf("KeyForURL(%q) = %q, %v", object.URL, got, ok)
+			}
+			if got := fake.paths[0]; got != "PUT "+tt.wantPath {
+				t.Errorf("PUT went to %q, want path %q", got, tt.wantPath)
+			}
+			if got := string(fake.objects[key]); got != string(content) {
+				t.Errorf("stored %q", got)
+			}
+
+			info, err := s.Stat(key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Size != int64(len(content)) || info.ContentType != "video/mp4" || info.ETag != `"etag"` || info.ModTime.IsZero() {
+				t.Errorf("Stat = %+v", info)
+			}
+
+			reader, _, err := s.Open(key)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			head := make([]byte, 4)
+			if _, err := io.ReadFull(reader, head); err != nil || string(head) != "0123" {
+				t.Fatalf("read %q, %v", head, err)
+			}
+			if _, err := reader.Seek(-5, io.SeekEnd); err != nil {
+				t.Fatalf("Seek: %v", err)
+			}
+			tail, err := io.ReadAll(reader)
+			if err != nil || string(tail) != "fghij" {
+				t.Fatalf("read after seek %q, %v", tail, err)
+			}
+			reader.Close()
+			if want := []string{"bytes=0-", "bytes=15-"}; strings.Join(fake.ranges, ",") != strings.Join(want, ",") {
+				t.Errorf("ranges = %q, want %q", fake.ranges, want)
+			}
+
+			if err := s.Delete(key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := s.Stat(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat after delete = %v, want ErrNotFound", err)
+			}
+			if err := s.Delete(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second Delete = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestS3StorageRejectsUnsignedRequests(t *testing.T) {
+	fake := newFakeS3("media", true)
+	s := newTestS3(t, fake)
+	s.signer.accessKey = ""
+
+	localPath := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(localPath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Upload(localPath, "clip.mp4", UploadOptions{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Upload = %v, want a 403", err)
+	}
+}
+
+func TestS3KeyForURL(t *testing.T) {
+	s, err := NewS3Storage(S3Config{Bucket: "media", Region: "eu-west-1", PublicURL: "https://cdn.example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		key  string
+		owns bool
+	}{
+		{"s3://media/videos/a.mp4", "videos/a.mp4", true},
+		{"s3://other/videos/a.mp4", "", false},
+		{"s3://media/", "", false},
+		{"https://media.s3.eu-west-1.amazonaws.com/videos/a%20b.mp4", "videos/a b.mp4", true},
+		{"https://cdn.example.com/videos/a.mp4", "videos/a.mp4", true},
+		{"https://cdn.example.com.evil.com/videos/a.mp4", "", false},
+		{"https://s3.eu-west-1.amazonaws.com/media/videos/a.mp4", "", false},
+	}
+	for _, tt := range tests {
+		key, ok := s.KeyForURL(tt.url)
+		if ok != tt.owns || key != tt.key {
+			t.Errorf("KeyForURL(%q) = %q, %v, want %q, %v", tt.url, key, ok, tt.key, tt.owns)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+type SFTPConfig struct {
+	// Addr is host:port of the server; the port defaults to 22.
+	Addr     string
+	Username string
+	// Password and PrivateKey (PEM) are tried in that order; at least one
+	// is required.
+	Password   string
+	PrivateKey string
+	// HostKey pins the server's key, either as an authorized_keys line or
+	// as a SHA256:... fingerprint as printed by ssh-keygen -l.
+	HostKey string
+	// Root is the directory outputs are stored in. Relative paths start at
+	// the login directory.
+	Root string
+	// PublicURL is the base URL Root is served from, e.g. by nginx on the
+	// same host. When empty outputs get sftp:// URLs, which only work
+	// through signed file URLs.
+	PublicURL string
+}
+
+// SFTPStorage stores outputs on a server over SFTP. It keeps one SSH
+// connection with a single SFTP session, which carries every operation, so
+// concurrent jobs and file requests do not wait for each other and never
+// run into the server's session limit. A broken connection is dialled again
+// by the next operation.
+type SFTPStorage struct {
+	addr      string
+	root      string
+	publicURL string
+	config    *ssh.ClientConfig
+	client    *http.Client
+
+	mu   sync.Mutex
+	conn *ssh.Client
+	sftp *sftp.Client
+}
+
+func NewSFTPStorage(cfg SFTPConfig) (*SFTPStorage, error) {
+	if cfg.Addr == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("sftp address and username are required")
+	}
+	addr := cfg.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if cfg.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp password or private key is required")
+	}
+
+	hostKeyCallback, err := pinnedHostKey(cfg.HostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	root := path.Clean(cfg.Root)
+	if cfg.Root == "" {
+		root = "."
+	}
+
+	return &SFTPStorage{
+		addr:      addr,
+		root:      root,
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		config: &ssh.ClientConfig{
+			User:            cfg.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		client: &http.Client{
+			Timeout: 10 * time.Minute,
+		},
+	}, nil
+}
+
+func pinnedHostKey(hostKey string) (ssh.HostKeyCallback, error) {
+	hostKey = strings.TrimSpace(hostKey)
+	if hostKey == "" {
+		return nil, fmt.Errorf("sftp host key is required")
+	}
+
+	if strings.HasPrefix(hostKey, "SHA256:") {
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); fingerprint != hostKey {
+				return fmt.Errorf("sftp host key mismatch: server offered %s", fingerprint)
+			}
+			return nil
+		}, nil
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sftp host key: %w", err)
+	}
+	return ssh.FixedHostKey(key), nil
+}
+
+func (s *SFTPStorage) Name() string {
+	return BackendSFTP
+}
+
+// Download reads URLs of Root over SFTP and fetches anything else over
+// HTTP.
+func (s *SFTPStorage) Download(rawURL, destPath string, opts DownloadOptions) error {
+	key, ok := s.KeyForURL(rawURL)
+	if !ok {
+		return downloadHTTP(sourceClient(s.client, opts), getRequest(rawURL), destPath, opts)
+	}
+
+	file, _, err := s.Open(key)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	defer file.Close()
+
+	return saveReader(file, destPath, opts)
+}
+
+func (s *SFTPStorage) ownsURL(rawURL string) bool {
+	_, ok := s.KeyForURL(rawURL)
+	return ok
+}
+
+// Upload writes the file next to its destination and renames it into
+// place, so readers never see a partial file and a retry simply writes it
+// again.
+func (s *SFTPStorage) Upload(filePath, key string, opts UploadOptions) (*UploadedObject, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return retryUpload(key, opts, func(int) (*UploadedObject, error) {
+		err := s.do(func(client *sftp.Client) error {
+			return putFile(client, filePath, s.path(key), opts.Progress)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload file: %w", err)
+		}
+		return &UploadedObject{Key: key, URL: s.PublicURL(key)}, nil
+	})
+}
+
+func (s *SFTPStorage) UploadTree(localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error) {
+	return uploadTree(s, localDir, prefix, opts)
+}
+
+func (s *SFTPStorage) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.do(func(client *sftp.Client) error {
+		return client.Remove(s.path(key))
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *SFTPStorage) Stat(key string) (*ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var info os.FileInfo
+	err = s.do(func(client *sftp.Client) error {
+		info, err = client.Stat(s.path(key))
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	return sftpObjectInfo(key, info), nil
+}
+
+func (s *SFTPStorage) PublicURL(key string) string {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return ""
+	}
+	if s.publicURL != "" {
+		return s.publicURL + "/" + uriEncode(cleaned, false)
+	}
+	return s.sftpURL() + "/" + uriEncode(cleaned, false)
+}
+
+// KeyForURL accepts URLs under PublicURL and sftp:// URLs of Root on the
+// configured server, as PublicURL returns them.
+func (s *SFTPStorage) KeyForURL(rawURL string) (string, bool) {
+	for _, base := range []string{s.publicURL, s.sftpURL()} {
+		if base == "" {
+			continue
+		}
+		key, ok := strings.CutPrefix(rawURL, base+"/")
+		if !ok || key == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(key); err == nil {
+			key = unescaped
+		}
+		if _, err := cleanKey(key); err != nil {
+			return "", false
+		}
+		return key, true
+	}
+	return "", false
+}
+
+func (s *SFTPStorage) sftpURL() string {
+	return "sftp://" + s.addr
+}
+
+// Open returns the file as a handle on the shared session, so serving it
+// costs no session of its own.
+func (s *SFTPStorage) Open(key string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file *sftp.File
+	var info os.FileInfo
+	err = s.do(func(client *sftp.Client) error {
+		file, err = client.Open(s.path(key))
+		if err != nil {
+			return err
+		}
+		info, err = file.Stat()
+		if err == nil && info.IsDir() {
+			err = os.ErrNotExist
+		}
+		if err != nil {
+			file.Close()
+		}
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, sftpObjectInfo(key, info), nil
+}
+
+func (s *SFTPStorage) path(key string) string {
+	return path.Join(s.root, key)
+}
+
+// do runs fn on the shared session. A connection that broke during fn is
+// dropped so the next operation dials again, and the error is marked
+// retryable.
+func (s *SFTPStorage) do(fn func(client *sftp.Client) error) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	err = fn(client)
+	if err != nil && s.dropIfDead(client) {
+		return retryable(err)
+	}
+	return err
+}
+
+func (s *SFTPStorage) connect() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sftp != nil {
+		return s.sftp, nil
+	}
+
+	conn, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.EOF) {
+			return nil, retryable(fmt.Errorf("failed to connect to sftp server: %w", err))
+		}
+		return nil, fmt.Errorf("failed to connect to sftp server: %w", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, retryable(fmt.Errorf("failed to start sftp session: %w", err))
+	}
+	s.conn = conn
+	s.sftp = client
+	return client, nil
+}
+
+// dropIfDead closes the connection of client unless it still answers, as
+// most failed operations leave the session usable, and reports whether it
+// was dead.
+func (s *SFTPStorage) dropIfDead(client *sftp.Client) bool {
+	if _, err := client.Getwd(); err == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sftp != client {
+		return true
+	}
+	s.sftp.Close()
+	s.conn.Close()
+	s.sftp = nil
+	s.conn = nil
+	return true
+}
+
+// putFile writes localPath to a temporary file beside remotePath and
+// renames it into place, creating missing directories on the way.
+func putFile(client *sftp.Client, localPath, remotePath string, progress ProgressFunc) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	tmpPath := path.Join(path.Dir(remotePath), ".upload-"+uuid.New().String())
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	remote, err := client.OpenFile(tmpPath, flags)
+	if errors.Is(err, os.ErrNotExist) {
+		if err = client.MkdirAll(path.Dir(remotePath)); err == nil {
+			remote, err = client.OpenFile(tmpPath, flags)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = remote.ReadFromWithConcurrency(newProgressReader(file, info.Size(), progress), 0)
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rename(client, tmpPath, remotePath)
+	}
+	if err != nil {
+		client.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// rename replaces newPath. Plain SFTP renames fail when the target exists,
+// so servers without the OpenSSH extension get it removed first.
+func rename(client *sftp.Client, oldPath, newPath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldPath, newPath)
+	}
+
+	if err := client.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(oldPath, newPath)
+}
+
+func sftpObjectInfo(key string, info os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:         key,
+		Size:        info.Size(),
+		ContentType: contentTypeFor(key),
+		ModTime:     info.ModTime(),
+		ETag:        fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size()),
+	}
+}
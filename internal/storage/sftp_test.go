@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSFTPServer is an SSH server with an SFTP subsystem on the loopback
+// interface, serving the local file system.
+type testSFTPServer struct {
+	addr     string
+	hostKey  ssh.PublicKey
+	config   *ssh.ServerConfig
+	sessions atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestSFTPServer(t *testing.T) *testSFTPServer {
+	t.Helper()
+	hostSigner := newTestSigner(t)
+
+	srv := &testSFTPServer{
+		hostKey: hostSigner.PublicKey(),
+		config: &ssh.ServerConfig{
+			PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				if meta.User() == "media" && string(password) == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("access denied")
+			},
+		},
+	}
+	srv.config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.addr = listener.Addr().String()
+	t.Cleanup(func() {
+		listener.Close()
+		srv.closeConns()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, conn)
+			srv.mu.Unlock()
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func (srv *testSFTPServer) serve(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, srv.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		srv.sessions.Add(1)
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+				}()
+			}
+		}()
+	}
+}
+
+// closeConns drops every connection, as a restarted server would.
+func (srv *testSFTPServer) closeConns() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+}
+
+func (srv *testSFTPServer) storage(t *testing.T, hostKey string) (*SFTPStorage, string) {
+	t.Helper()
+	root := t.TempDir()
+	s, err := NewSFTPStorage(SFTPConfig{
+		Addr:      srv.addr,
+		Username:  "media",
+		Password:  "secret",
+		HostKey:   hostKey,
+		Root:      root,
+		PublicURL: "https://media.example.com/files/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, root
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	localPath := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return localPath
+}
+
+func TestSFTPStorage(t *testing.T) {
+	srv := newTestSFTPServer(t)
+	s, root := srv.storage(t, ssh.FingerprintSHA256(srv.hostKey))
+	local := t.TempDir()
+
+	const key = "videos/2024/clip one.mp4"
+	object, err := s.Upload(writeTestFile(t, local, "v1.mp4", "first version"), key, UploadOptions{})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if want := "https://media.example.com/files/videos/2024/clip%20one.mp4"; object.URL != want {
+		t.Errorf("URL = %q, want %q", object.URL, want)
+	}
+	if got, ok := s.KeyForURL(object.URL); !ok || got != key {
+		t.Errorf("KeyForURL(%q) = %q, %v", object.URL, got, ok)
+	}
+
+	const content = "0123456789abcdefghij"
+	if _, err := s.Upload(writeTestFile(t, local, "v2.mp4", content), key, UploadOptions{}); err != nil {
+		t.Fatalf("Upload over an existing file: %v", err)
+	}
+	stored, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(key)))
+	if err != nil || string(stored) != content {
+		t.Fatalf("stored %q, %v", stored, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "videos", "2024"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("upload left %d entries behind: %v", len(entries), err)
+	}
+
+	info, err := s.Stat(key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len(content)) || info.ContentType != "video/mp4" || info.ModTime.IsZero() || info.ETag == "" {
+		t.Errorf("Stat = %+v", info)
+	}
+	if _, err := s.Stat("videos/2024"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a directory = %v, want ErrNotFound", err)
+	}
+
+	file, info, err := s.Open(key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Open size = %d", info.Size)
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(file, head); err != nil || string(head) != "0123" {
+		t.Fatalf("read %q, %v", head, err)
+	}
+	if _, err := file.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	tail, err := io.ReadAll(file)
+	if err != nil || string(tail) != "fghij" {
+		t.Fatalf("read after seek %q, %v", tail, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+	if _, _, err := s.Open(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSFTPStorageUploadTree(t *testing.T) {
+	srv := newTestSFTPServer(t)
+	s, root := srv.storage(t, ssh.FingerprintSHA256(srv.hostKey))
+
+	local := t.TempDir()
+	files := map[string]string{
+		"master.m3u8":           "#EXTM3U\n720p/playlist.m3u8\n480p/playlist.m3u8\n",
+		"720p/playlist.m3u8":    "#EXTM3U\nsegment_000.ts\n",
+		"720p/segment_000.ts":   "720p segment",
+		"480p/playlist.m3u8":    "#EXTM3U\nsegment_000.ts\n",
+		"480p/segment_000.ts":   "480p segment",
+		"480p/keys/enc.keyinfo": "key",
+	}
+	for name, content := range files {
+		writeTestFile(t, local, name, content)
+	}
+
+	objects, err := s.UploadTree(local, "hls/42", UploadOptions{})
+	if err != nil {
+		t.Fatalf("UploadTree: %v", err)
+	}
+	if len(objects) != len(files) {
+		t.Errorf("UploadTree returned %d objects, want %d", len(objects), len(files))
+	}
+	for name, content := range files {
+		object, ok := objects[name]
+		if !ok {
+			t.Errorf("no object for %s", name)
+			continue
+		}
+		if want := "https://media.example.com/files/hls/42/" + name; object.URL != want {
+			t.Errorf("URL of %s = %q, want %q", name, object.URL, want)
+		}
+		stored, err := os.ReadFile(filepath.Join(root, "hls", "42", filepath.FromSlash(name)))
+		if err != nil || string(stored) != content {
+			t.Errorf("stored %s = %q, %v", name, stored, err)
+		}
+	}
+}
+
+// Serving many files at once must not open a session per file, or the
+// server's MaxSessions (10 by default in OpenSSH) is soon used up.
+func TestSFTPStorageSharesOneSession(t *testing.T) {
+	srv := newTestSFTPServer(t)
+	s, root := srv.storage(t, ssh.FingerprintSHA256(srv.hostKey))
+	writeTestFile(t, root, "hls/42/segment.ts", "segment data")
+
+	const readers = 25
+	files := make([]io.ReadSeekCloser, readers)
+	for i := range files {
+		file, _, err := s.Open("hls/42/segment.ts")
+		if err != nil {
+			t.Fatalf("Open %d: %v", i, err)
+		}
+		files[i] = file
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for _, file := range files {
+		wg.Add(1)
+		go func(file io.ReadSeekCloser) {
+			defer wg.Done()
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			if err == nil && string(data) != "segment data" {
+				err = fmt.Errorf("read %q", data)
+			}
+			errs <- err
+		}(file)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got := srv.sessions.Load(); got != 1 {
+		t.Errorf("opened %d sessions, want 1", got)
+	}
+}
+
+func TestSFTPStorageReconnects(t *testing.T) {
+	srv := newTestSFTPServer(t)
+	s, root := srv.storage(t, ssh.FingerprintSHA256(srv.hostKey))
+	writeTestFile(t, root, "a.mp4", "data")
+
+	if _, err := s.Stat("a.mp4"); err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	srv.closeConns()
+
+	_, err := s.Stat("a.mp4")
+	if err != nil && !IsRetryable(err) {
+		t.Fatalf("Stat on a dropped connection = %v, want a retryable error", err)
+	}
+	if _, err := s.Stat("a.mp4"); err != nil {
+		t.Fatalf("Stat after reconnecting: %v", err)
+	}
+}
+
+func TestSFTPStorageHostKeyPinning(t *testing.T) {
+	srv := newTestSFTPServer(t)
+	other := newTestSigner(t).PublicKey()
+	authorizedKey := func(key ssh.PublicKey) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+
+	tests := []struct {
+		name    string
+		hostKey string
+		allowed bool
+	}{
+		{"fingerprint", ssh.FingerprintSHA256(srv.hostKey), true},
+		{"authorized_keys line", authorizedKey(srv.hostKey) + " server", true},
+		{"other fingerprint", ssh.FingerprintSHA256(other), false},
+		{"other authorized_keys line", authorizedKey(other), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := srv.storage(t, tt.hostKey)
+			_, err := s.Upload(writeTestFile(t, t.TempDir(), "a.mp4", "data"), "a.mp4", UploadOptions{Retries: 2})
+			if tt.allowed && err != nil {
+				t.Errorf("Upload = %v, want success", err)
+			}
+			if !tt.allowed && (err == nil || !strings.Contains(err.Error(), "failed to connect to sftp server")) {
+				t.Errorf("Upload = %v, want a rejected host key", err)
+			}
+			if !tt.allowed && IsRetryable(err) {
+				t.Errorf("a rejected host key is retried: %v", err)
+			}
+		})
+	}
+
+	for _, hostKey := range []string{"", "not a key"} {
+		if _, err := NewSFTPStorage(SFTPConfig{Addr: srv.addr, Username: "media", Password: "secret", HostKey: hostKey}); err == nil {
+			t.Errorf("NewSFTPStorage accepted host key %q", hostKey)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFilesPath splits a signed path into the arguments of Verify.
+func parseFilesPath(t *testing.T, signed string) (backend, expires, signature, key string) {
+	t.Helper()
+	rest, ok := strings.CutPrefix(signed, FilesPath+"/")
+	if !ok {
+		t.Fatalf("path %q does not start with %s", signed, FilesPath)
+	}
+	parts := strings.SplitN(rest, "/", 4)
+	if len(parts) != 4 {
+		t.Fatalf("path %q has too few segments", signed)
+	}
+	key, err := url.PathUnescape(parts[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parts[0], parts[1], parts[2], key
+}
+
+func TestURLSignerScope(t *testing.T) {
+	signer := NewURLSigner("secret", time.Hour)
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		signedKey string
+		key       string
+		valid     bool
+	}{
+		{"same key", "videos/clip.mp4", "videos/clip.mp4", true},
+		{"file signs only itself", "videos/clip.mp4", "videos/other.mp4", false},
+		{"file does not cover siblings below it", "videos/clip.mp4", "videos/clip.mp4/x", false},
+		{"playlist covers segments", "hls/42/master.m3u8", "hls/42/720p/segment_001.ts", true},
+		{"playlist covers variant playlists", "hls/42/master.m3u8", "hls/42/720p/playlist.m3u8", true},
+		{"playlist covers itself", "hls/42/master.m3u8", "hls/42/master.m3u8", true},
+		{"playlist does not cover parent", "hls/42/master.m3u8", "hls/secret.mp4", false},
+		{"playlist does not cover sibling directory", "hls/42/master.m3u8", "hls/43/720p/segment_001.ts", false},
+		{"playlist does not cover prefix sibling", "hls/42/master.m3u8", "hls/420/segment_001.ts", false},
+		{"traversal out of scope", "hls/42/master.m3u8", "hls/42/../43/segment_001.ts", false},
+		{"top-level playlist signs only itself", "master.m3u8", "segment_001.ts", false},
+		{"uppercase extension", "hls/42/MASTER.M3U8", "hls/42/segment_001.ts", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, expires, signature, _ := parseFilesPath(t, signer.Path("s3", tt.signedKey, now))
+			err := signer.Verify(backend, tt.key, expires, signature, now)
+			if tt.valid && err != nil {
+				t.Errorf("Verify(%q) = %v, want valid", tt.key, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrSignatureInvalid) {
+				t.Errorf("Verify(%q) = %v, want ErrSignatureInvalid", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestURLSignerVerify(t *testing.T) {
+	signer := NewURLSigner("secret", time.Hour)
+	now := time.Unix(1700000000, 0)
+	backend, expires, signature, key := parseFilesPath(t, signer.Path("s3", "videos/clip one.mp4", now))
+	if key != "videos/clip one.mp4" {
+		t.Fatalf("key in path = %q", key)
+	}
+
+	tests := []struct {
+		name      string
+		signer    *URLSigner
+		backend   string
+		expires   string
+		signature string
+		now       time.Time
+		want      error
+	}{
+		{"valid", signer, backend, expires, signature, now, nil},
+		{"valid until expiry", signer, backend, expires, signature, now.Add(time.Hour), nil},
+		{"expired", signer, backend, expires, signature, now.Add(time.Hour + time.Second), ErrSignatureExpired},
+		{"extended expiry", signer, backend, "1800000000", signature, now, ErrSignatureInvalid},
+		{"bad expiry", signer, backend, "soon", signature, now, ErrSignatureInvalid},
+		{"other backend", signer, "local", expires, signature, now, ErrSignatureInvalid},
+		{"tampered signature", signer, backend, expires, signature[:len(signature)-1] + "A", now, ErrSignatureInvalid},
+		{"empty signature", signer, backend, expires, "", now, ErrSignatureInvalid},
+		{"other secret", NewURLSigner("other", time.Hour), backend, expires, signature, now, ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.signer.Verify(tt.backend, key, tt.expires, tt.signature, tt.now)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := signer.Verify(backend, "../etc/passwd", expires, signature, now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("Verify of an unclean key = %v, want ErrSignatureInvalid", err)
+	}
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+// blockedNetworks are refused on top of what net.IP already classifies as
+// loopback, private, link-local, multicast or unspecified.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // carrier-grade NAT
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved
+	"64:ff9b::/96",   // NAT64, can map to any IPv4 address
+	"64:ff9b:1::/48", // local-use NAT64
+	"2002::/16",      // 6to4, embeds an IPv4 address
+	"fec0::/10",      // deprecated site-local
+	"100::/64",       // discard-only
+	"2001:db8::/32",  // documentation
+)
+
+// SourcePolicy decides which source URLs callers may ask the service to
+// download. Hosts are resolved once per connection and only the checked
+// addresses are dialled, so DNS rebinding cannot swap in an internal
+// address, and every redirect is checked again.
+type SourcePolicy struct {
+	// Schemes are the allowed URL schemes.
+	Schemes []string
+	// Hosts restricts sources to these hosts. "*.example.com" matches any
+	// subdomain. Empty allows any host with a public address.
+	Hosts []string
+	// Networks are private or reserved ranges that may be reached anyway,
+	// e.g. a WordPress container on the Docker network.
+	Networks []*net.IPNet
+
+	// lookupIPAddr resolves hosts; nil uses the default resolver.
+	lookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	once   sync.Once
+	client *http.Client
+}
+
+func NewSourcePolicy(schemes, hosts, networks []string) (*SourcePolicy, error) {
+	p := &SourcePolicy{}
+	for _, scheme := range schemes {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			p.Schemes = append(p.Schemes, scheme)
+		}
+	}
+	if len(p.Schemes) == 0 {
+		p.Schemes = []string{"https", "http"}
+	}
+
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			p.Hosts = append(p.Hosts, host)
+		}
+	}
+
+	for _, cidr := range networks {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		p.Networks = append(p.Networks, network)
+	}
+
+	return p, nil
+}
+
+// WithHosts returns a policy with the same schemes and networks that only
+// allows hosts. Every host has to be allowed by p as well, so a narrower
+// policy can never reach more than the one it came from.
+func (p *SourcePolicy) WithHosts(hosts []string) (*SourcePolicy, error) {
+	narrowed := &SourcePolicy{Schemes: p.Schemes, Networks: p.Networks}
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host == "" {
+			continue
+		}
+		if !p.coversHost(host) {
+			return nil, fmt.Errorf("host %s is not in the deployment's source allowlist", host)
+		}
+		narrowed.Hosts = append(narrowed.Hosts, host)
+	}
+	if len(narrowed.Hosts) == 0 {
+		return p, nil
+	}
+	return narrowed, nil
+}
+
+// coversHost reports whether every host matched by pattern is allowed.
+func (p *SourcePolicy) coversHost(pattern string) bool {
+	suffix, wildcard := strings.CutPrefix(pattern, "*.")
+	if !wildcard {
+		return p.allowsHost(pattern)
+	}
+	if len(p.Hosts) == 0 {
+		return true
+	}
+	for _, allowed := range p.Hosts {
+		if outer, ok := strings.CutPrefix(allowed, "*."); ok && (suffix == outer || strings.HasSuffix(suffix, "."+outer)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL validates the scheme and host of rawURL. Hosts given as IP
+// literals are checked against the blocked ranges too; names are checked
+// when they are resolved.
+func (p *SourcePolicy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid url", models.ErrSourceNotAllowed)
+	}
+	return p.checkURL(u)
+}
+
+func (p *SourcePolicy) checkURL(u *url.URL) error {
+	if !p.allowsScheme(u.Scheme) {
+		return fmt.Errorf("%w: scheme %q is not allowed", models.ErrSourceNotAllowed, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: url has no host", models.ErrSourceNotAllowed)
+	}
+	if !p.allowsHost(host) {
+		return fmt.Errorf("%w: host %s is not in the allowlist", models.ErrSourceNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(ip)
+	}
+	return nil
+}
+
+func (p *SourcePolicy) allowsScheme(scheme string) bool {
+	for _, allowed := range p.Schemes {
+		if strings.EqualFold(scheme, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SourcePolicy) allowsHost(host string) bool {
+	if len(p.Hosts) == 0 {
+		return true
+	}
+	for _, allowed := range p.Hosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SourcePolicy) checkIP(ip net.IP) error {
+	for _, network := range p.Networks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("%w: address %s is not public", models.ErrSourceNotAllowed, ip)
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return fmt.Errorf("%w: address %s is reserved", models.ErrSourceNotAllowed, ip)
+		}
+	}
+	return nil
+}
+
+// Client returns the HTTP client for source downloads. It goes direct, never
+// through a proxy, since a proxy would dial addresses the policy never saw.
+func (p *SourcePolicy) Client() *http.Client {
+	p.once.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = p.dialContext
+
+		p.client = &http.Client{
+			Transport: &sourceTransport{policy: p, base: transport},
+			Timeout:   10 * time.Minute,
+		}
+	})
+	return p.client
+}
+
+func (p *SourcePolicy) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := p.lookupIPAddr
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	lastErr := fmt.Errorf("%w: %s has no addresses", models.ErrSourceNotAllowed, host)
+	for _, ip := range addrs {
+		if err := p.checkIP(ip.IP); err != nil {
+			lastErr = err
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// sourceTransport checks every request, including each redirect hop,
+// before it is sent.
+type sourceTransport struct {
+	policy *SourcePolicy
+	base   http.RoundTripper
+}
+
+func (t *sourceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.checkURL(req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// ownedURLs is implemented by backends that read some URLs straight from
+// their own store instead of fetching them from the URL's host.
+type ownedURLs interface {
+	ownsURL(rawURL string) bool
+}
+
+// sharedSource is a backend shared by several tenants, as one of them
+// sees it when fetching sources. URLs of the backend may name another
+// tenant's files, so they are fetched over HTTP under the tenant's source
+// policy like any other URL instead of being read from the store.
+type sharedSource struct {
+	Backend
+}
+
+// SharedSource returns backend for fetching the sources of a tenant that
+// shares it with other tenants.
+func SharedSource(backend Backend) Backend {
+	return sharedSource{backend}
+}
+
+func (s sharedSource) Download(rawURL, destPath string, opts DownloadOptions) error {
+	policy := opts.Source
+	if policy == nil {
+		policy = publicSources
+	}
+	return downloadHTTP(policy.Client(), getRequest(rawURL), destPath, opts)
+}
+
+// publicSources allows any public HTTP(S) address. It guards shared
+// backends' URLs when no policy is given.
+var publicSources = &SourcePolicy{Schemes: []string{"https", "http"}}
+
+// CheckSource validates a caller-supplied source URL before a job is
+// queued. URLs that backend reads from its own store are always allowed.
+func CheckSource(backend Backend, rawURL string, policy *SourcePolicy) error {
+	if owner, ok := backend.(ownedURLs); ok && owner.ownsURL(rawURL) {
+		return nil
+	}
+	if _, ok := backend.(sharedSource); ok && policy == nil {
+		policy = publicSources
+	}
+	if policy == nil {
+		return nil
+	}
+	return policy.CheckURL(rawURL)
+}
+
+// sourceClient is the client for a URL supplied by an API caller. Backends
+// keep their own client for their configured endpoints.
+func sourceClient(fallback *http.Client, opts DownloadOptions) *http.Client {
+	if opts.Source == nil {
+		return fallback
+	}
+	return opts.Source.Client()
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
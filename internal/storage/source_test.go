@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+func TestSourcePolicyCheckIP(t *testing.T) {
+	p, err := NewSourcePolicy(nil, nil, []string{"172.18.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"172.18.0.5", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.19.0.5", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.1.1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"fec0::1", false},
+		{"ff02::1", false},
+		{"100::1", false},
+		{"2001:db8::1", false},
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2002:7f00:1::", false},
+		// IPv4-mapped IPv6 addresses are judged by the IPv4 address.
+		{"::ffff:8.8.8.8", true},
+		{"::ffff:172.18.0.5", true},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		err := p.checkIP(net.ParseIP(tt.ip))
+		if tt.allowed && err != nil {
+			t.Errorf("checkIP(%s) = %v, want allowed", tt.ip, err)
+		}
+		if !tt.allowed && !errors.Is(err, models.ErrSourceNotAllowed) {
+			t.Errorf("checkIP(%s) = %v, want ErrSourceNotAllowed", tt.ip, err)
+		}
+	}
+}
+
+func TestSourcePolicyCheckURL(t *testing.T) {
+	p, err := NewSourcePolicy([]string{"https"}, []string{"media.example.com", "*.cdn.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://media.example.com/a.mp4", true},
+		{"https://MEDIA.example.com/a.mp4", true},
+		{"https://a.cdn.example.com/a.mp4", true},
+		{"https://a.b.cdn.example.com/a.mp4", true},
+		{"http://media.example.com/a.mp4", false},
+		{"file:///etc/passwd", false},
+		{"https://x.media.example.com/a.mp4", false},
+		{"https://cdn.example.com/a.mp4", false},
+		{"https://evilcdn.example.com/a.mp4", false},
+		{"https://media.example.com.evil.com/a.mp4", false},
+		{"https:///a.mp4", false},
+		{"://bad", false},
+	}
+	for _, tt := range tests {
+		err := p.CheckURL(tt.url)
+		if tt.allowed && err != nil {
+			t.Errorf("CheckURL(%q) = %v, want allowed", tt.url, err)
+		}
+		if !tt.allowed && !errors.Is(err, models.ErrSourceNotAllowed) {
+			t.Errorf("CheckURL(%q) = %v, want ErrSourceNotAllowed", tt.url, err)
+		}
+	}
+
+	open, err := NewSourcePolicy(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rawURL := range []string{
+		"http://127.0.0.1/a.mp4",
+		"http://[::1]/a.mp4",
+		"http://[::ffff:127.0.0.1]/a.mp4",
+		"http://[::ffff:7f00:1]/a.mp4",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		if err := open.CheckURL(rawURL); !errors.Is(err, models.ErrSourceNotAllowed) {
+			t.Errorf("CheckURL(%q) = %v, want ErrSourceNotAllowed", rawURL, err)
+		}
+	}
+}
+
+func TestSourcePolicyWithHosts(t *testing.T) {
+	parent, err := NewSourcePolicy([]string{"https"}, []string{"*.example.com", "media.other.com"}, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := NewSourcePolicy(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		parent  *SourcePolicy
+		hosts   []string
+		allowed bool
+	}{
+		{"host below wildcard", parent, []string{"a.example.com"}, true},
+		{"narrower wildcard", parent, []string{"*.cdn.example.com"}, true},
+		{"same wildcard", parent, []string{"*.example.com"}, true},
+		{"exact host", parent, []string{"media.other.com"}, true},
+		{"apex of wildcard", parent, []string{"example.com"}, false},
+		{"wider wildcard", parent, []string{"*.com"}, false},
+		{"wildcard over exact host", parent, []string{"*.other.com"}, false},
+		{"unrelated host", parent, []string{"evil.com"}, false},
+		{"one of several", parent, []string{"a.example.com", "evil.com"}, false},
+		{"any host allowed", open, []string{"*.anything.org"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			narrowed, err := tt.parent.WithHosts(tt.hosts)
+			if !tt.allowed {
+				if err == nil {
+					t.Fatalf("WithHosts(%q) allowed hosts the parent does not", tt.hosts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WithHosts(%q) = %v", tt.hosts, err)
+			}
+			if len(narrowed.Hosts) != len(tt.hosts) {
+				t.Errorf("Hosts = %q, want %q", narrowed.Hosts, tt.hosts)
+			}
+			if len(narrowed.Schemes) != len(tt.parent.Schemes) || len(narrowed.Networks) != len(tt.parent.Networks) {
+				t.Errorf("narrowed policy lost the parent's schemes or networks")
+			}
+		})
+	}
+
+	same, err := parent.WithHosts([]string{" ", ""})
+	if err != nil || same != parent {
+		t.Errorf("WithHosts with no hosts = %v, %v, want the parent", same, err)
+	}
+}
+
+func TestSourceTransportChecksRedirects(t *testing.T) {
+	var secretHits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/secret", func(w http.ResponseWriter, r *http.Request) {
+		secretHits.Add(1)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+
+	p, err := NewSourcePolicy(nil, []string{"media.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection goes to the test server, so only the checks in
+	// sourceTransport stand between a redirect and its target.
+	client := &http.Client{Transport: &sourceTransport{
+		policy: p,
+		base: &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}},
+	}}
+
+	tests := []struct {
+		to      string
+		allowed bool
+	}{
+		{"http://media.example.com/secret", true},
+		{"http://127.0.0.1:" + port + "/secret", false},
+		{"http://[::ffff:127.0.0.1]:" + port + "/secret", false},
+		{"http://elsewhere.example.org/secret", false},
+		{"ftp://media.example.com/secret", false},
+	}
+	for _, tt := range tests {
+		secretHits.Store(0)
+		resp, err := client.Get("http://media.example.com/redirect?to=" + tt.to)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if tt.allowed {
+			if err != nil || secretHits.Load() != 1 {
+				t.Errorf("redirect to %s: err %v, %d hits, want it followed", tt.to, err, secretHits.Load())
+			}
+			continue
+		}
+		if !errors.Is(err, models.ErrSourceNotAllowed) || secretHits.Load() != 0 {
+			t.Errorf("redirect to %s: err %v, %d hits, want ErrSourceNotAllowed", tt.to, err, secretHits.Load())
+		}
+	}
+}
+
+func TestSourcePolicyDialsOnlyCheckedAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	resolve := func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "internal.test":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.7")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+		case "mapped.test":
+			return []net.IPAddr{{IP: net.ParseIP("::ffff:127.0.0.1")}}, nil
+		case "metadata.test":
+			return []net.IPAddr{{IP: net.ParseIP("169.254.169.254")}}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		networks []string
+		allowed  bool
+	}{
+		{"private addresses", "internal.test", nil, false},
+		{"IPv4-mapped loopback", "mapped.test", nil, false},
+		{"cloud metadata", "metadata.test", nil, false},
+		{"allowed network", "internal.test", []string{"127.0.0.0/8"}, true},
+		{"allowed network, mapped address", "mapped.test", []string{"127.0.0.1/32"}, true},
+		{"other allowed network", "internal.test", []string{"192.168.0.0/16"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewSourcePolicy(nil, nil, tt.networks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.lookupIPAddr = resolve
+
+			resp, err := p.Client().Get("http://" + net.JoinHostPort(tt.host, port) + "/")
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if tt.allowed && err != nil {
+				t.Errorf("Get = %v, want allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, models.ErrSourceNotAllowed) {
+				t.Errorf("Get = %v, want ErrSourceNotAllowed", err)
+			}
+		})
+	}
+}
+
+// A tenant sharing the deployment's backend must not read another tenant's
+// outputs from the store by submitting their URLs as its source.
+func TestSharedSourceCrossTenant(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir(), "https://media.example.com/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := local.Upload(writeTestFile(t, t.TempDir(), "a.mp4", "tenant a"), "a1b2/compressed.mp4", UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantB, err := NewSourcePolicy(nil, []string{"cdn.tenant-b.example"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckSource(local, object.URL, tenantB); err != nil {
+		t.Errorf("CheckSource on the backend itself = %v, want its own URL allowed", err)
+	}
+	dest := filepath.Join(t.TempDir(), "own")
+	if err := local.Download(object.URL, dest, DownloadOptions{Source: tenantB}); err != nil {
+		t.Errorf("Download on the backend itself = %v", err)
+	}
+
+	shared := SharedSource(local)
+	if err := CheckSource(shared, object.URL, tenantB); !errors.Is(err, models.ErrSourceNotAllowed) {
+		t.Errorf("CheckSource on the shared backend = %v, want ErrSourceNotAllowed", err)
+	}
+	dest = filepath.Join(t.TempDir(), "stolen")
+	if err := shared.Download(object.URL, dest, DownloadOptions{Source: tenantB}); !errors.Is(err, models.ErrSourceNotAllowed) {
+		t.Errorf("Download on the shared backend = %v, want ErrSourceNotAllowed", err)
+	}
+	if stolen, _ := os.ReadFile(dest); len(stolen) > 0 {
+		t.Errorf("Download on the shared backend wrote %q", stolen)
+	}
+}
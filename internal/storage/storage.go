@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BackendWordPress = "wordpress"
+	BackendLocal     = "local"
+	BackendS3        = "s3"
+	BackendSFTP      = "sftp"
+	BackendWebDAV    = "webdav"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+	ErrConflict = errors.New("conflicting change")
+)
+
+// Backend stores compression outputs and fetches source media. Keys are
+// slash-separated paths relative to the backend's root. Uploads stream from
+// disk.
+type Backend interface {
+	Name() string
+	Download(url, destPath string, opts DownloadOptions) error
+	Upload(localPath, key string, opts UploadOptions) (*UploadedObject, error)
+	UploadTree(localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error)
+	Delete(key string) error
+	Stat(key string) (*ObjectInfo, error)
+	PublicURL(key string) string
+}
+
+// AttachmentReplacer is implemented by backends that can swap the file of an
+// existing media item in place and undo the swap.
+type AttachmentReplacer interface {
+	ReplaceAttachment(attachmentID int, jobID, localPath, key string, progress ProgressFunc) (*UploadedObject, error)
+	RevertAttachment(attachmentID int, jobID string) (*UploadedObject, error)
+}
+
+type DownloadOptions struct {
+	// MaxSize aborts the download once the file is known to be larger.
+	MaxSize int64
+	// SHA256 is the expected hex digest of the file, checked when set.
+	SHA256 string
+	// Retries is how many times a broken transfer is resumed.
+	Retries int
+	// Source guards URLs that are fetched from their own host. Nil means
+	// no restrictions.
+	Source *SourcePolicy
+}
+
+type UploadOptions struct {
+	Progress ProgressFunc
+	// Retries is how many times an upload that failed on the network or
+	// with a 5xx is tried again.
+	Retries int
+	// IdempotencyKey makes retries safe on backends that create a new item
+	// per upload (WordPress): uploads with the same IdempotencyKey and key
+	// are the same upload, and a retry returns the item an earlier attempt
+	// created instead of adding another one.
+	IdempotencyKey string
+	// Resumed marks uploads an earlier run of the job may already have
+	// made, so they are looked up by IdempotencyKey before the first
+	// attempt as well as before retries.
+	Resumed bool
+	// PostID, Title and AltText are kept by backends with a media library
+	// (WordPress) and ignored by plain object stores.
+	PostID  int
+	Title   string
+	AltText string
+}
+
+type UploadedObject struct {
+	Key string
+	URL string
+	// MediaID is the WordPress attachment ID, zero for other backends.
+	MediaID int
+}
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	// ETag is a quoted entity tag that changes whenever the content does.
+	ETag string
+}
+
+// Registry holds the configured backends. Jobs name the one they want; an
+// empty name selects the deployment default.
+type Registry struct {
+	backends    map[string]Backend
+	defaultName string
+}
+
+func NewRegistry(defaultName string) *Registry {
+	return &Registry{
+		backends:    make(map[string]Backend),
+		defaultName: defaultName,
+	}
+}
+
+func (r *Registry) Register(backend Backend) {
+	r.backends[backend.Name()] = backend
+}
+
+func (r *Registry) Get(name string) (Backend, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+	backend, ok := r.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q is not configured", name)
+	}
+	return backend, nil
+}
+
+func (r *Registry) Has(name string) bool {
+	_, ok := r.backends[name]
+	return ok
+}
+
+func (r *Registry) Default() string {
+	return r.defaultName
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.backends))
+	for name := range r.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// uploadTree uploads every file below localDir under prefix, keeping the
+// relative layout so that relative references between files (HLS playlists
+// and their segments) keep working.
+func uploadTree(backend Backend, localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error) {
+	files, sizes, total, err := listTree(localDir)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := &treeProgress{total: total, progress: opts.Progress}
+	objects := make(map[string]*UploadedObject)
+	for i, rel := range files {
+		fileOpts := opts
+		fileOpts.Progress = tracker.file(sizes[i])
+
+		localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+		object, err := backend.Upload(localPath, path.Join(prefix, rel), fileOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", rel, err)
+		}
+		objects[rel] = object
+	}
+
+	return objects, nil
+}
+
+// retryUpload calls upload until it succeeds, fails in a way a retry cannot
+// fix, or opts.Retries retries are used up, backing off between attempts
+// like downloads do.
+func retryUpload(key string, opts UploadOptions, upload func(attempt int) (*UploadedObject, error)) (*UploadedObject, error) {
+	for attempt := 0; ; attempt++ {
+		object, err := upload(attempt)
+		if err == nil || !IsRetryable(err) || attempt >= opts.Retries {
+			return object, err
+		}
+
+		delay := retryDelay(attempt)
+		log.Printf("Upload of %s failed, retrying in %s: %v", key, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// listTree returns the slash-separated paths of the files below dir with
+// their sizes and the total size.
+func listTree(dir string) ([]string, []int64, int64, error) {
+	var files []string
+	var sizes []int64
+	var total int64
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		sizes = append(sizes, info.Size())
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	return files, sizes, total, nil
+}
+
+// cleanKey normalises a key and rejects ones that would escape the root.
+func cleanKey(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" || cleaned == "." {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return cleaned, nil
+}
+
+func contentTypeFor(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".mp4":
+		return "video/mp4"
+	case ".avif":
+		return "image/avif"
+	case ".webp":
+		return "image/webp"
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type WebDAVConfig struct {
+	// URL is the collection outputs are stored in, e.g.
+	// https://dav.example.com/media.
+	URL      string
+	Username string
+	Password string
+	// PublicURL is the base URL the collection is served from without
+	// credentials, e.g. a CDN. When empty URL is used, which usually needs
+	// the credentials and so only works through signed file URLs.
+	PublicURL string
+}
+
+// WebDAVStorage stores outputs on a WebDAV server with PUT, creating the
+// collections a key needs with MKCOL.
+type WebDAVStorage struct {
+	base      *url.URL
+	username  string
+	password  string
+	publicURL string
+	client    *http.Client
+}
+
+func NewWebDAVStorage(cfg WebDAVConfig) (*WebDAVStorage, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webdav url %q", cfg.URL)
+	}
+
+	return &WebDAVStorage{
+		base:      base,
+		username:  cfg.Username,
+		password:  cfg.Password,
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		client: &http.Client{
+			Timeout: 10 * time.Minute,
+		},
+	}, nil
+}
+
+func (d *WebDAVStorage) Name() string {
+	return BackendWebDAV
+}
+
+// Download fetches URLs of the collection with the credentials and anything
+// else anonymously over HTTP.
+func (d *WebDAVStorage) Download(rawURL, destPath string, opts DownloadOptions) error {
+	if key, ok := d.KeyForURL(rawURL); ok {
+		return downloadHTTP(d.client, func() (*http.Request, error) {
+			return d.newRequest("GET", key, nil)
+		}, destPath, opts)
+	}
+	return downloadHTTP(sourceClient(d.client, opts), getRequest(rawURL), destPath, opts)
+}
+
+func (d *WebDAVStorage) ownsURL(rawURL string) bool {
+	_, ok := d.KeyForURL(rawURL)
+	return ok
+}
+
+// Upload puts the file, retrying failed attempts. A PUT to the same key
+// replaces the file, so retries never leave duplicates behind.
+func (d *WebDAVStorage) Upload(filePath, key string, opts UploadOptions) (*UploadedObject, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return retryUpload(key, opts, func(int) (*UploadedObject, error) {
+		err := d.put(filePath, key, opts.Progress)
+		if err == errMissingCollection {
+			if err = d.mkcolAll(path.Dir(key)); err == nil {
+				err = d.put(filePath, key, opts.Progress)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &UploadedObject{Key: key, URL: d.PublicURL(key)}, nil
+	})
+}
+
+// errMissingCollection is what a PUT into a collection that does not exist
+// yet gets. Collections are only created then, so the files of an HLS
+// package after the first one take a single request each.
+var errMissingCollection = errors.New("parent collection does not exist")
+
+func (d *WebDAVStorage) put(filePath, key string, progress ProgressFunc) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	req, err := d.newRequest("PUT", key, newProgressReader(file, info.Size(), progress))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentTypeFor(key))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return retryable(fmt.Errorf("failed to upload file: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusConflict, http.StatusNotFound:
+		// RFC 4918 answers 409, some servers 404.
+		return errMissingCollection
+	}
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	err = fmt.Errorf("failed to upload file: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+	if retryableStatus(resp.StatusCode) {
+		return retryable(err)
+	}
+	return err
+}
+
+// mkcolAll creates the collection dir and any missing parents, from the
+// top down.
+func (d *WebDAVStorage) mkcolAll(dir string) error {
+	if dir == "." {
+		return nil
+	}
+
+	var current string
+	for _, segment := range strings.Split(dir, "/") {
+		current = path.Join(current, segment)
+		req, err := d.newRequest("MKCOL", current+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return retryable(fmt.Errorf("failed to create collection: %w", err))
+		}
+		resp.Body.Close()
+
+		// 405 means the collection exists already.
+		if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusMethodNotAllowed {
+			continue
+		}
+		err = fmt.Errorf("failed to create collection %s: status code %d", current, resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return retryable(err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *WebDAVStorage) UploadTree(localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error) {
+	return uploadTree(d, localDir, prefix, opts)
+}
+
+func (d *WebDAVStorage) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := d.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete file: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+func (d *WebDAVStorage) Stat(key string) (*ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := d.newRequest("HEAD", key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get file info: status code %d", resp.StatusCode)
+	}
+
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if info.ContentType == "" {
+		info.ContentType = contentTypeFor(key)
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+func (d *WebDAVStorage) PublicURL(key string) string {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return ""
+	}
+	if d.publicURL != "" {
+		return d.publicURL + "/" + uriEncode(cleaned, false)
+	}
+	return d.fileURL(cleaned).String()
+}
+
+// KeyForURL accepts URLs under PublicURL and under the collection URL.
+func (d *WebDAVStorage) KeyForURL(rawURL string) (string, bool) {
+	for _, base := range []string{d.publicURL, d.base.String()} {
+		if base == "" {
+			continue
+		}
+		key, ok := strings.CutPrefix(rawURL, base+"/")
+		if !ok || key == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(key); err == nil {
+			key = unescaped
+		}
+		if _, err := cleanKey(key); err != nil {
+			return "", false
+		}
+		return key, true
+	}
+	return "", false
+}
+
+func (d *WebDAVStorage) Open(key string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	info, err := d.Stat(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newHTTPObject(d.client, func() (*http.Request, error) {
+		return d.newRequest("GET", info.Key, nil)
+	}, info.Size), info, nil
+}
+
+func (d *WebDAVStorage) fileURL(key string) *url.URL {
+	u := *d.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	u.RawPath = ""
+	return &u
+}
+
+func (d *WebDAVStorage) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, d.fileURL(key).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if d.username != "" || d.password != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return req, nil
+}
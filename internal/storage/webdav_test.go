@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+const webdavPrefix = "/dav/media"
+
+// testWebDAVServer serves a directory with x/net/webdav behind basic auth
+// and records the requests it gets. x/net/webdav answers a PUT into a
+// missing collection with 404; missingStatus replaces that, so the 409 of
+// RFC 4918 can be tested too.
+type testWebDAVServer struct {
+	*httptest.Server
+	root string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestWebDAVServer(t *testing.T, missingStatus int) *testWebDAVServer {
+	t.Helper()
+	srv := &testWebDAVServer{root: t.TempDir()}
+	handler := &webdav.Handler{
+		Prefix:     webdavPrefix,
+		FileSystem: webdav.Dir(srv.root),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "media" || password != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, webdavPrefix))
+		srv.mu.Unlock()
+
+		if r.Method == "PUT" {
+			dir := path.Dir(strings.TrimPrefix(r.URL.Path, webdavPrefix))
+			if _, err := os.Stat(filepath.Join(srv.root, filepath.FromSlash(dir))); err != nil {
+				http.Error(w, "Conflict", missingStatus)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *testWebDAVServer) takeRequests() []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	requests := srv.requests
+	srv.requests = nil
+	return requests
+}
+
+func (srv *testWebDAVServer) storage(t *testing.T, password string) *WebDAVStorage {
+	t.Helper()
+	d, err := NewWebDAVStorage(WebDAVConfig{
+		URL:      srv.URL + webdavPrefix + "/",
+		Username: "media",
+		Password: password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestWebDAVStorage(t *testing.T) {
+	for _, missingStatus := range []int{http.StatusConflict, http.StatusNotFound} {
+		t.Run(http.StatusText(missingStatus), func(t *testing.T) {
+			srv := newTestWebDAVServer(t, missingStatus)
+			d := srv.storage(t, "secret")
+			local := t.TempDir()
+
+			const key = "videos/2024/clip one.mp4"
+			object, err := d.Upload(writeTestFile(t, local, "a.mp4", "first version"), key, UploadOptions{})
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			want := []string{
+				"PUT /videos/2024/clip one.mp4",
+				"MKCOL /videos/",
+				"MKCOL /videos/2024/",
+				"PUT /videos/2024/clip one.mp4",
+			}
+			if got := srv.takeRequests(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("requests = %q, want %q", got, want)
+			}
+			if want := srv.URL + webdavPrefix + "/videos/2024/clip%20one.mp4"; object.URL != want {
+				t.Errorf("URL = %q, want %q", object.URL, want)
+			}
+			if got, ok := d.KeyForURL(object.URL); !ok || got != key {
+				t.Errorf("KeyForURL(%q) = %q, %v", object.URL, got, ok)
+			}
+
+			// The collection exists now, so the next file takes one request.
+			const content = "0123456789abcdefghij"
+			if _, err := d.Upload(writeTestFile(t, local, "b.mp4", content), key, UploadOptions{}); err != nil {
+				t.Fatalf("Upload over an existing file: %v", err)
+			}
+			if got := srv.takeRequests(); len(got) != 1 {
+				t.Errorf("second upload made requests %q, want one PUT", got)
+			}
+			stored, err := os.ReadFile(filepath.Join(srv.root, filepath.FromSlash(key)))
+			if err != nil || string(stored) != content {
+				t.Fatalf("stored %q, %v", stored, err)
+			}
+
+			info, err := d.Stat(key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Size != int64(len(content)) || info.ContentType != "video/mp4" || info.ModTime.IsZero() || info.ETag == "" {
+				t.Errorf("Stat = %+v", info)
+			}
+
+			file, _, err := d.Open(key)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			head := make([]byte, 4)
+			if _, err := io.ReadFull(file, head); err != nil || string(head) != "0123" {
+				t.Fatalf("read %q, %v", head, err)
+			}
+			if _, err := file.Seek(-5, io.SeekEnd); err != nil {
+				t.Fatalf("Seek: %v", err)
+			}
+			tail, err := io.ReadAll(file)
+			if err != nil || string(tail) != "fghij" {
+				t.Fatalf("read after seek %q, %v", tail, err)
+			}
+			file.Close()
+
+			if err := d.Delete(key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := d.Stat(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat after delete = %v, want ErrNotFound", err)
+			}
+			if err := d.Delete(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second Delete = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestWebDAVStorageUploadTree(t *testing.T) {
+	srv := newTestWebDAVServer(t, http.StatusConflict)
+	d := srv.storage(t, "secret")
+
+	local := t.TempDir()
+	files := map[string]string{
+		"master.m3u8":         "#EXTM3U\n720p/playlist.m3u8\n480p/playlist.m3u8\n",
+		"720p/playlist.m3u8":  "#EXTM3U\nsegment_000.ts\nsegment_001.ts\n",
+		"720p/segment_000.ts": "720p segment 0",
+		"720p/segment_001.ts": "720p segment 1",
+		"480p/playlist.m3u8":  "#EXTM3U\nsegment_000.ts\n",
+		"480p/segment_000.ts": "480p segment 0",
+	}
+	for name, content := range files {
+		writeTestFile(t, local, name, content)
+	}
+
+	objects, err := d.UploadTree(local, "hls/42", UploadOptions{})
+	if err != nil {
+		t.Fatalf("UploadTree: %v", err)
+	}
+	if len(objects) != len(files) {
+		t.Errorf("UploadTree returned %d objects, want %d", len(objects), len(files))
+	}
+	for name, content := range files {
+		if _, ok := objects[name]; !ok {
+			t.Errorf("no object for %s", name)
+		}
+		stored, err := os.ReadFile(filepath.Join(srv.root, "hls", "42", filepath.FromSlash(name)))
+		if err != nil || string(stored) != content {
+			t.Errorf("stored %s = %q, %v", name, stored, err)
+		}
+	}
+
+	var mkcols int
+	for _, request := range srv.takeRequests() {
+		if strings.HasPrefix(request, "MKCOL ") {
+			mkcols++
+		}
+	}
+	// hls/, hls/42/ and the variant's directory for the first file of each
+	// variant; every other file takes a single PUT.
+	if mkcols != 3*2 {
+		t.Errorf("made %d MKCOL requests, want %d", mkcols, 3*2)
+	}
+}
+
+func TestWebDAVStorageRejectedCredentials(t *testing.T) {
+	srv := newTestWebDAVServer(t, http.StatusConflict)
+	d := srv.storage(t, "wrong")
+
+	_, err := d.Upload(writeTestFile(t, t.TempDir(), "a.mp4", "data"), "a.mp4", UploadOptions{Retries: 2})
+	if err == nil || !strings.Contains(err.Error(), "status code 401") {
+		t.Fatalf("Upload = %v, want a 401", err)
+	}
+	if IsRetryable(err) {
+		t.Errorf("rejected credentials are retried: %v", err)
+	}
+}
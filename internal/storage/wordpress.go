@@ -1,14 +1,24 @@
 package storage
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,91 +40,461 @@ func NewWordPressStorage(apiURL, username, appPassword string) *WordPressStorage
 	}
 }
 
-func (w *WordPressStorage) DownloadFile(url, destPath string) error {
-	resp, err := w.client.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+func (w *WordPressStorage) Name() string {
+	return BackendWordPress
+}
+
+func (w *WordPressStorage) Download(url, destPath string, opts DownloadOptions) error {
+	return downloadHTTP(sourceClient(w.client, opts), getRequest(url), destPath, opts)
+}
+
+// mediaItem is the part of the REST API media object the worker uses.
+type mediaItem struct {
+	ID        int    `json:"id"`
+	SourceURL string `json:"source_url"`
+}
+
+// Upload adds the file to the media library and attaches it to
+// opts.PostID. WordPress files everything by upload date in one directory,
+// so the key is flattened into a file name (see mediaName) and the returned
+// URL is the one WordPress reports.
+//
+// With an idempotency key the item is created with a slug derived from it.
+// An attempt whose response was lost, or an earlier run of a resumed job,
+// may still have created the item, so retries and resumed uploads look the
+// slug up before sending the file.
+func (w *WordPressStorage) Upload(filePath, key string, opts UploadOptions) (*UploadedObject, error) {
+	name := mediaName(key)
+	slug := mediaSlug(opts.IdempotencyKey, key)
+
+	params := url.Values{}
+	if slug != "" {
+		params.Set("slug", slug)
+	}
+	if opts.PostID > 0 {
+		params.Set("post", strconv.Itoa(opts.PostID))
+	}
+	if opts.Title != "" {
+		params.Set("title", opts.Title)
+	}
+	if opts.AltText != "" {
+		params.Set("alt_text", opts.AltText)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+	uploadURL := w.apiURL + "/media"
+	if len(params) > 0 {
+		uploadURL += "?" + params.Encode()
 	}
 
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	return retryUpload(key, opts, func(attempt int) (*UploadedObject, error) {
+		if (attempt > 0 || opts.Resumed) && slug != "" {
+			item, err := w.findMediaBySlug(slug)
+			if err == nil {
+				return &UploadedObject{Key: name, URL: item.SourceURL, MediaID: item.ID}, nil
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+		}
+
+		item, err := w.postFile(uploadURL, filePath, name, opts.Progress)
+		if err != nil {
+			return nil, err
+		}
+		return &UploadedObject{Key: name, URL: item.SourceURL, MediaID: item.ID}, nil
+	})
+}
+
+// mediaName is the file name an object is stored under: its key with the
+// slashes turned into dashes, so keys that differ only in their directory
+// stay apart.
+func mediaName(key string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(path.Clean("/"+key), "/"), "/", "-")
+}
+
+// mediaSlug is the slug of the media item an upload creates. It only uses
+// characters WordPress keeps as they are, so the item can be found by it.
+func mediaSlug(idempotencyKey, key string) string {
+	if idempotencyKey == "" {
+		return ""
 	}
+	sum := sha256.Sum256([]byte(idempotencyKey + "\n" + key))
+	return "vc-" + hex.EncodeToString(sum[:16])
+}
+
+// ReplaceAttachment swaps the file of an existing attachment for the one at
+// filePath through the companion plugin, which keeps the current file as a
+// backup that RevertAttachment restores.
+func (w *WordPressStorage) ReplaceAttachment(attachmentID int, jobID, filePath, key string, progress ProgressFunc) (*UploadedObject, error) {
+	replaceURL := fmt.Sprintf("%s/attachments/%d/replace?job_id=%s", w.pluginURL(), attachmentID, url.QueryEscape(jobID))
 
-	out, err := os.Create(destPath)
+	name := mediaName(key)
+	item, err := w.postFile(replaceURL, filePath, name, progress)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return nil, err
 	}
-	defer out.Close()
+	return &UploadedObject{Key: name, URL: item.SourceURL, MediaID: item.ID}, nil
+}
+
+// RevertAttachment restores the file the attachment had before jobID
+// replaced it. The plugin refuses when a later job replaced it again.
+func (w *WordPressStorage) RevertAttachment(attachmentID int, jobID string) (*UploadedObject, error) {
+	revertURL := fmt.Sprintf("%s/attachments/%d/revert?job_id=%s", w.pluginURL(), attachmentID, url.QueryEscape(jobID))
 
-	_, err = io.Copy(out, resp.Body)
+	req, err := http.NewRequest("POST", revertURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	w.authorize(req)
 
-	return nil
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revert attachment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	item, err := decodeMediaResponse(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revert attachment: %w", err)
+	}
+	return &UploadedObject{Key: path.Base(item.SourceURL), URL: item.SourceURL, MediaID: item.ID}, nil
 }
 
-func (w *WordPressStorage) UploadFile(filePath string) (string, error) {
+// postFile sends the file as the raw request body, which the media endpoint
+// accepts together with a Content-Disposition header, so it is never held in
+// memory.
+func (w *WordPressStorage) postFile(endpoint, filePath, key string, progress ProgressFunc) (*mediaItem, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, newProgressReader(file, info.Size(), progress))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = info.Size()
+
+	w.authorize(req)
+	req.Header.Set("Content-Type", contentTypeFor(key))
+	req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
 
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+	resp, err := w.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %w", err)
+		return nil, retryable(fmt.Errorf("failed to upload file: %w", err))
 	}
+	defer resp.Body.Close()
 
-	_, err = io.Copy(part, file)
+	item, err := decodeMediaResponse(resp)
 	if err != nil {
-		return "", fmt.Errorf("failed to copy file: %w", err)
+		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
+	return item, nil
+}
 
-	writer.Close()
+func decodeMediaResponse(resp *http.Response) (*mediaItem, error) {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusConflict:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: %s", ErrConflict, string(bodyBytes))
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+		if retryableStatus(resp.StatusCode) {
+			return nil, retryable(err)
+		}
+		return nil, err
+	}
 
-	uploadURL := w.apiURL + "/media"
-	req, err := http.NewRequest("POST", uploadURL, body)
+	var item mediaItem
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return nil, fmt.Errorf("failed to decode media response: %w", err)
+	}
+	if item.ID == 0 || item.SourceURL == "" {
+		return nil, fmt.Errorf("media response has no id or source_url")
+	}
+	return &item, nil
+}
+
+// pluginURL is the namespace of the routes registered by the companion
+// plugin in wordpress-plugin/, next to the wp/v2 namespace in apiURL.
+func (w *WordPressStorage) pluginURL() string {
+	return strings.TrimSuffix(strings.TrimSuffix(w.apiURL, "/"), "/wp/v2") + "/video-compressor/v1"
+}
+
+// UploadTree flattens the tree into the media library, naming each file
+// after its key like Upload does. Because the directory layout is lost, the
+// references in HLS playlists are rewritten to the names the files were
+// stored under, and playlists are uploaded after the files they reference.
+// The references stay relative, so a playlist served from a CDN loads its
+// segments from the CDN too.
+func (w *WordPressStorage) UploadTree(localDir, prefix string, opts UploadOptions) (map[string]*UploadedObject, error) {
+	all, sizes, total, err := listTree(localDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	auth := base64.StdEncoding.EncodeToString([]byte(w.username + ":" + w.appPassword))
-	req.Header.Set("Authorization", "Basic "+auth)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	var files, playlists []string
+	sizeOf := make(map[string]int64)
+	for i, rel := range all {
+		sizeOf[rel] = sizes[i]
+		if strings.HasSuffix(rel, ".m3u8") {
+			playlists = append(playlists, rel)
+		} else {
+			files = append(files, rel)
+		}
+	}
+
+	// Variant playlists sit deeper than the master playlist that lists them.
+	sort.SliceStable(playlists, func(i, j int) bool {
+		return strings.Count(playlists[i], "/") > strings.Count(playlists[j], "/")
+	})
+
+	tracker := &treeProgress{total: total, progress: opts.Progress}
+	objects := make(map[string]*UploadedObject)
+	urls := make(map[string]string)
+	for _, rel := range append(files, playlists...) {
+		localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+		fileOpts := opts
+		fileOpts.Progress = tracker.file(sizeOf[rel])
+
+		var object *UploadedObject
+		if strings.HasSuffix(rel, ".m3u8") {
+			object, err = w.uploadPlaylist(localPath, path.Join(prefix, rel), path.Dir(rel), urls, fileOpts)
+		} else {
+			object, err = w.Upload(localPath, path.Join(prefix, rel), fileOpts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", rel, err)
+		}
+		objects[rel] = object
+		urls[rel] = object.URL
+	}
+
+	return objects, nil
+}
+
+// uploadPlaylist uploads a playlist that references the files in urls by
+// their bare names. WordPress files uploads by date, so a tree uploaded at
+// the turn of a month can end up in two directories; a playlist stored
+// apart from the files it references is replaced by one with their
+// absolute URLs.
+func (w *WordPressStorage) uploadPlaylist(localPath, key, dir string, urls map[string]string, opts UploadOptions) (*UploadedObject, error) {
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	relative, referenced, err := rewritePlaylist(content, dir, urls, path.Base)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(localPath, relative, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write playlist: %w", err)
+	}
+	object, err := w.Upload(localPath, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	split := false
+	for _, url := range referenced {
+		split = split || urlDir(url) != urlDir(object.URL)
+	}
+	if !split {
+		return object, nil
+	}
+
+	if err := w.deleteMedia(object.MediaID); err != nil {
+		return nil, err
+	}
+	absolute, _, err := rewritePlaylist(content, dir, urls, func(url string) string { return url })
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(localPath, absolute, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return w.Upload(localPath, key, opts)
+}
+
+// rewritePlaylist replaces the references in a playlist to files that were
+// uploaded with ref applied to their URL. It returns the new playlist and
+// the URLs it references.
+func rewritePlaylist(content []byte, dir string, urls map[string]string, ref func(url string) string) ([]byte, []string, error) {
+	var out bytes.Buffer
+	var referenced []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && !strings.HasPrefix(line, "#") {
+			if url, ok := urls[path.Join(dir, line)]; ok {
+				line = ref(url)
+				referenced = append(referenced, url)
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	return out.Bytes(), referenced, nil
+}
+
+// urlDir is a URL up to and including the last slash of its path.
+func urlDir(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return url[:strings.LastIndex(url, "/")+1]
+}
+
+// Delete removes the media library item whose file name matches key.
+func (w *WordPressStorage) Delete(key string) error {
+	item, err := w.findMedia(key)
+	if err != nil {
+		return err
+	}
+	return w.deleteMedia(item.ID)
+}
+
+// findMedia looks up the media library item stored under key.
+func (w *WordPressStorage) findMedia(key string) (*mediaItem, error) {
+	name := mediaName(key)
+	search := strings.TrimSuffix(name, path.Ext(name))
+
+	req, err := http.NewRequest("GET", w.apiURL+"/media?per_page=100&search="+url.QueryEscape(search), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	w.authorize(req)
 
 	resp, err := w.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %w", err)
+		return nil, fmt.Errorf("failed to look up media: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to upload file: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to look up media: status code %d", resp.StatusCode)
+	}
+
+	var items []mediaItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("failed to decode media list: %w", err)
+	}
+
+	for _, item := range items {
+		if path.Base(item.SourceURL) == name {
+			return &item, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// findMediaBySlug looks up the media item created with slug.
+func (w *WordPressStorage) findMediaBySlug(slug string) (*mediaItem, error) {
+	req, err := http.NewRequest("GET", w.apiURL+"/media?slug="+url.QueryEscape(slug), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	w.authorize(req)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, retryable(fmt.Errorf("failed to look up media: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("failed to look up media: status code %d", resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return nil, retryable(err)
+		}
+		return nil, err
 	}
 
-	return fmt.Sprintf("%s/uploads/%s", w.apiURL, filepath.Base(filePath)), nil
+	var items []mediaItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("failed to decode media list: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+	return &items[0], nil
 }
 
-func (w *WordPressStorage) GetFileSize(url string) (int64, error) {
-	resp, err := w.client.Head(url)
+func (w *WordPressStorage) deleteMedia(id int) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/media/%d?force=true", w.apiURL, id), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	w.authorize(req)
+
+	resp, err := w.client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get file size: %w", err)
+		return fmt.Errorf("failed to delete media: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("failed to get file size: status code %d", resp.StatusCode)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete media: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
 	}
+	return nil
+}
 
-	return resp.ContentLength, nil
+func (w *WordPressStorage) Stat(key string) (*ObjectInfo, error) {
+	item, err := w.findMedia(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.client.Head(item.SourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get file info: status code %d", resp.StatusCode)
+	}
+
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+// PublicURL looks up the URL WordPress serves the file from, since the
+// upload path depends on the date it was added. It is empty for files that
+// are not in the media library.
+func (w *WordPressStorage) PublicURL(key string) string {
+	item, err := w.findMedia(key)
+	if err != nil {
+		return ""
+	}
+	return item.SourceURL
+}
+
+func (w *WordPressStorage) authorize(req *http.Request) {
+	auth := base64.StdEncoding.EncodeToString([]byte(w.username + ":" + w.appPassword))
+	req.Header.Set("Authorization", "Basic "+auth)
 }
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testWordPressServer is a media endpoint that keeps the items it creates
+// and counts the files POSTed to it. Items are filed under uploads/2024/01
+// until monthEnds uploads were made, and under uploads/2024/02 after that.
+// failNext makes the next POST create its item and then answer 502, as if
+// the response had been lost.
+type testWordPressServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	items     []testMediaItem
+	posts     int
+	monthEnds int
+	failNext  bool
+}
+
+type testMediaItem struct {
+	mediaItem
+	Slug    string `json:"slug"`
+	Content string `json:"-"`
+}
+
+func newTestWordPressServer(t *testing.T) *testWordPressServer {
+	t.Helper()
+	srv := &testWordPressServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "editor" || password != "app password" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+
+		if id, ok := strings.CutPrefix(r.URL.Path, "/wp-json/wp/v2/media/"); ok && r.Method == "DELETE" {
+			for i, item := range srv.items {
+				if fmt.Sprint(item.ID) == id {
+					srv.items = append(srv.items[:i], srv.items[i+1:]...)
+					json.NewEncoder(w).Encode(map[string]bool{"deleted": true})
+					return
+				}
+			}
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/wp-json/wp/v2/media" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			found := []testMediaItem{}
+			for _, item := range srv.items {
+				if item.Slug == r.URL.Query().Get("slug") {
+					found = append(found, item)
+				}
+			}
+			json.NewEncoder(w).Encode(found)
+		case "POST":
+			content, _ := io.ReadAll(r.Body)
+			month := "01"
+			if srv.monthEnds > 0 && srv.posts >= srv.monthEnds {
+				month = "02"
+			}
+			srv.posts++
+			name := strings.TrimPrefix(r.Header.Get("Content-Disposition"), "attachment; filename=")
+			item := testMediaItem{
+				mediaItem: mediaItem{ID: srv.posts, SourceURL: srv.URL + "/wp-content/uploads/2024/" + month + "/" + name},
+				Slug:      r.URL.Query().Get("slug"),
+				Content:   string(content),
+			}
+			srv.items = append(srv.items, item)
+			if srv.failNext {
+				srv.failNext = false
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(item)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *testWordPressServer) storage() *WordPressStorage {
+	return NewWordPressStorage(srv.URL+"/wp-json/wp/v2", "editor", "app password")
+}
+
+// stored returns the content of the item a file name is stored under.
+func (srv *testWordPressServer) stored(name string) (sourceURL, content string, ok bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, item := range srv.items {
+		if path.Base(item.SourceURL) == name {
+			return item.SourceURL, item.Content, true
+		}
+	}
+	return "", "", false
+}
+
+func (srv *testWordPressServer) postCount() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.posts
+}
+
+func TestWordPressUploadIdempotency(t *testing.T) {
+	local := t.TempDir()
+	file := writeTestFile(t, local, "medium.webp", "webp data")
+	const key = "a1b2/medium.webp"
+
+	t.Run("new upload", func(t *testing.T) {
+		srv := newTestWordPressServer(t)
+		object, err := srv.storage().Upload(file, key, UploadOptions{IdempotencyKey: "a1b2", PostID: 42})
+		if err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		if srv.postCount() != 1 || object.MediaID != 1 || !strings.HasSuffix(object.URL, "/a1b2-medium.webp") {
+			t.Errorf("Upload = %+v after %d POSTs, want media 1 from one POST", object, srv.postCount())
+		}
+	})
+
+	t.Run("lost response is retried without a duplicate", func(t *testing.T) {
+		srv := newTestWordPressServer(t)
+		srv.failNext = true
+		object, err := srv.storage().Upload(file, key, UploadOptions{IdempotencyKey: "a1b2", Retries: 1})
+		if err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		if srv.postCount() != 1 || object.MediaID != 1 {
+			t.Errorf("Upload = %+v after %d POSTs, want media 1 from one POST", object, srv.postCount())
+		}
+	})
+
+	t.Run("requeued job finds the item of its earlier run", func(t *testing.T) {
+		srv := newTestWordPressServer(t)
+		if _, err := srv.storage().Upload(file, key, UploadOptions{IdempotencyKey: "a1b2"}); err != nil {
+			t.Fatal(err)
+		}
+		// The job is run again from its first attempt, as after a breaker
+		// hold or a crashed worker.
+		object, err := srv.storage().Upload(file, key, UploadOptions{IdempotencyKey: "a1b2", Resumed: true})
+		if err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		if srv.postCount() != 1 || object.MediaID != 1 {
+			t.Errorf("Upload = %+v after %d POSTs, want media 1 from one POST", object, srv.postCount())
+		}
+
+		// Another output of the same job was not uploaded yet.
+		other := writeTestFile(t, local, "small.webp", "small data")
+		object, err = srv.storage().Upload(other, "a1b2/small.webp", UploadOptions{IdempotencyKey: "a1b2", Resumed: true})
+		if err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		if srv.postCount() != 2 || object.MediaID != 2 {
+			t.Errorf("Upload = %+v after %d POSTs, want media 2 from a second POST", object, srv.postCount())
+		}
+	})
+
+	t.Run("other job uploads its own item", func(t *testing.T) {
+		srv := newTestWordPressServer(t)
+		if _, err := srv.storage().Upload(file, key, UploadOptions{IdempotencyKey: "a1b2"}); err != nil {
+			t.Fatal(err)
+		}
+		object, err := srv.storage().Upload(file, key, UploadOptions{IdempotencyKey: "c3d4", Resumed: true})
+		if err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		if srv.postCount() != 2 || object.MediaID != 2 {
+			t.Errorf("Upload = %+v after %d POSTs, want media 2 from a second POST", object, srv.postCount())
+		}
+	})
+}
+
+func TestWordPressUploadTree(t *testing.T) {
+	files := map[string]string{
+		"master.m3u8":         "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\n720p/playlist.m3u8\n",
+		"720p/playlist.m3u8":  "#EXTM3U\n#EXTINF:6.0,\nsegment_000.ts\n#EXTINF:6.0,\nsegment_001.ts\n#EXT-X-ENDLIST\n",
+		"720p/segment_000.ts": "segment 0",
+		"720p/segment_001.ts": "segment 1",
+	}
+	upload := func(t *testing.T, srv *testWordPressServer) map[string]*UploadedObject {
+		t.Helper()
+		local := t.TempDir()
+		for name, content := range files {
+			writeTestFile(t, local, name, content)
+		}
+		objects, err := srv.storage().UploadTree(local, "hls/42", UploadOptions{IdempotencyKey: "42"})
+		if err != nil {
+			t.Fatalf("UploadTree: %v", err)
+		}
+		if len(objects) != len(files) {
+			t.Fatalf("UploadTree returned %d objects, want %d", len(objects), len(files))
+		}
+		return objects
+	}
+
+	t.Run("relative references", func(t *testing.T) {
+		srv := newTestWordPressServer(t)
+		objects := upload(t, srv)
+
+		want := map[string]string{
+			"hls-42-master.m3u8":        "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\nhls-42-720p-playlist.m3u8\n",
+			"hls-42-720p-playlist.m3u8": "#EXTM3U\n#EXTINF:6.0,\nhls-42-720p-segment_000.ts\n#EXTINF:6.0,\nhls-42-720p-segment_001.ts\n#EXT-X-ENDLIST\n",
+		}
+		for name, content := range want {
+			if _, got, ok := srv.stored(name); !ok || got != content {
+				t.Errorf("stored %s = %q, want %q", name, got, content)
+			}
+		}
+		if srv.postCount() != len(files) {
+			t.Errorf("made %d POSTs, want %d", srv.postCount(), len(files))
+		}
+
+		// The references resolve against wherever the master playlist is
+		// served from, such as a CDN.
+		cdn := "https://cdn.example.com/uploads/2024/01/" + path.Base(objects["master.m3u8"].URL)
+		if got := resolveReference(t, cdn, "hls-42-720p-playlist.m3u8"); got != "https://cdn.example.com/uploads/2024/01/hls-42-720p-playlist.m3u8" {
+			t.Errorf("variant playlist resolves to %s", got)
+		}
+	})
+
+	t.Run("upload directory changes", func(t *testing.T) {
+		srv := newTestWordPressServer(t)
+		// The segments and the variant playlist go first; the master
+		// playlist is filed in the next month.
+		srv.monthEnds = 3
+		objects := upload(t, srv)
+
+		variant, _, _ := srv.stored("hls-42-720p-playlist.m3u8")
+		want := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\n" + variant + "\n"
+		if _, got, ok := srv.stored("hls-42-master.m3u8"); !ok || got != want {
+			t.Errorf("stored master playlist = %q, want %q", got, want)
+		}
+		if sourceURL, _, _ := srv.stored("hls-42-master.m3u8"); objects["master.m3u8"].URL != sourceURL {
+			t.Errorf("master playlist URL = %s, want the replacement %s", objects["master.m3u8"].URL, sourceURL)
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		if len(srv.items) != len(files) {
+			t.Errorf("media library has %d items, want %d", len(srv.items), len(files))
+		}
+	})
+}
+
+func resolveReference(t *testing.T, base, ref string) string {
+	t.Helper()
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.ResolveReference(&url.URL{Path: ref}).String()
+}
@@ -0,0 +1,132 @@
+package tenants
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const apiKeyPrefix = "vc_"
+
+var ErrNoEncryptionKey = errors.New("TENANT_ENCRYPTION_KEY is not configured")
+
+// newAEAD builds the AES-256-GCM cipher for tenant secrets from a base64
+// encoded 32-byte key. An empty key gives a nil cipher; tenants then work
+// but cannot have storage credentials.
+func newAEAD(encodedKey string) (cipher.AEAD, error) {
+	if encodedKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid tenant encryption key: got %d bytes, need 32", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts cfg for the tenant. The tenant ID is authenticated along
+// with it, so a sealed config copied to another tenant's row does not open.
+func (s *Store) seal(tenantID string, cfg *models.TenantStorage) ([]byte, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	return s.sealJSON(tenantID, "storage config", cfg)
+}
+
+func (s *Store) open(tenantID string, sealed []byte) (*models.TenantStorage, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	cfg := &models.TenantStorage{}
+	if err := s.openJSON(tenantID, "storage config", sealed, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// sealRewrites encrypts the URL rewrite rules, which hold token secrets.
+// They are bound to their column as well as the tenant.
+func (s *Store) sealRewrites(tenantID string, rules []models.URLRewriteRule) ([]byte, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return s.sealJSON(tenantID+"/url_rewrites", "url rewrites", rules)
+}
+
+func (s *Store) openRewrites(tenantID string, sealed []byte) ([]models.URLRewriteRule, error) {
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	var rules []models.URLRewriteRule
+	if err := s.openJSON(tenantID+"/url_rewrites", "url rewrites", sealed, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *Store) sealJSON(additionalData, what string, v interface{}) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrNoEncryptionKey
+	}
+
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", what, err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, []byte(additionalData)), nil
+}
+
+func (s *Store) openJSON(additionalData, what string, sealed []byte, v interface{}) error {
+	if s.aead == nil {
+		return ErrNoEncryptionKey
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return fmt.Errorf("failed to decrypt %s: data is too short", what)
+	}
+	plaintext, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(additionalData))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", what, err)
+	}
+
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", what, err)
+	}
+	return nil
+}
+
+func newAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
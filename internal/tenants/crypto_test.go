@@ -0,0 +1,197 @@
+package tenants
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	aead, err := newAEAD(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Store{aead: aead}
+}
+
+func TestNewAEAD(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantNil bool
+		wantErr string
+	}{
+		{name: "no key", key: "", wantNil: true},
+		{name: "32 bytes", key: base64.StdEncoding.EncodeToString(make([]byte, 32))},
+		{name: "16 bytes", key: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: "got 16 bytes, need 32"},
+		{name: "not base64", key: "not base64!", wantErr: "invalid tenant encryption key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aead, err := newAEAD(tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newAEAD = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newAEAD = %v", err)
+			}
+			if (aead == nil) != tt.wantNil {
+				t.Errorf("newAEAD returned cipher %v, want nil %v", aead, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestSealStorageConfig(t *testing.T) {
+	s := newTestStore(t)
+	cfg := &models.TenantStorage{Backend: "s3", S3Bucket: "media", S3AccessKey: "AKID", S3SecretKey: "top secret"}
+
+	sealed, err := s.seal("tenant-a", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("top secret")) {
+		t.Fatal("sealed config contains the secret in plain text")
+	}
+
+	opened, err := s.open("tenant-a", sealed)
+	if err != nil {
+		t.Fatalf("open = %v", err)
+	}
+	if !reflect.DeepEqual(opened, cfg) {
+		t.Errorf("open = %+v, want %+v", opened, cfg)
+	}
+
+	again, err := s.seal("tenant-a", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, sealed) {
+		t.Error("sealing twice gave the same ciphertext, nonces are reused")
+	}
+
+	if cfg, err := s.seal("tenant-a", nil); cfg != nil || err != nil {
+		t.Errorf("seal(nil) = %v, %v, want nothing", cfg, err)
+	}
+	if cfg, err := s.open("tenant-a", nil); cfg != nil || err != nil {
+		t.Errorf("open(nil) = %v, %v, want nothing", cfg, err)
+	}
+}
+
+func TestOpenRejectsForeignData(t *testing.T) {
+	s := newTestStore(t)
+	sealedConfig, err := s.seal("tenant-a", &models.TenantStorage{Backend: "s3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []models.URLRewriteRule{{Prefix: "https://a.example.com/", Token: &models.URLToken{Secret: "token secret"}}}
+	sealedRules, err := s.sealRewrites("tenant-a", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte(nil), sealedConfig...)
+	tampered[len(tampered)-1] ^= 1
+
+	otherAEAD, err := newAEAD(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &Store{aead: otherAEAD}
+
+	tests := []struct {
+		name string
+		open func() error
+	}{
+		{"config copied to another tenant", func() error {
+			_, err := s.open("tenant-b", sealedConfig)
+			return err
+		}},
+		{"rewrites copied to another tenant", func() error {
+			_, err := s.openRewrites("tenant-b", sealedRules)
+			return err
+		}},
+		{"rewrites opened as config", func() error {
+			_, err := s.open("tenant-a", sealedRules)
+			return err
+		}},
+		{"config opened as rewrites", func() error {
+			_, err := s.openRewrites("tenant-a", sealedConfig)
+			return err
+		}},
+		{"tenant ID that ends like the rewrites column", func() error {
+			_, err := s.open("tenant-a/url_rewrites", sealedConfig)
+			return err
+		}},
+		{"tampered ciphertext", func() error {
+			_, err := s.open("tenant-a", tampered)
+			return err
+		}},
+		{"truncated ciphertext", func() error {
+			_, err := s.open("tenant-a", sealedConfig[:5])
+			return err
+		}},
+		{"other key", func() error {
+			_, err := other.open("tenant-a", sealedConfig)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.open()
+			if err == nil || !strings.Contains(err.Error(), "failed to decrypt") {
+				t.Errorf("open = %v, want a decryption failure", err)
+			}
+		})
+	}
+
+	opened, err := s.openRewrites("tenant-a", sealedRules)
+	if err != nil {
+		t.Fatalf("openRewrites = %v", err)
+	}
+	if !reflect.DeepEqual(opened, rules) {
+		t.Errorf("openRewrites = %+v, want %+v", opened, rules)
+	}
+}
+
+func TestSealWithoutKey(t *testing.T) {
+	s := &Store{}
+	if _, err := s.seal("tenant-a", &models.TenantStorage{Backend: "s3"}); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("seal = %v, want ErrNoEncryptionKey", err)
+	}
+	if _, err := s.sealRewrites("tenant-a", []models.URLRewriteRule{{Prefix: "https://a.example.com/"}}); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("sealRewrites = %v, want ErrNoEncryptionKey", err)
+	}
+	if _, err := s.open("tenant-a", []byte("sealed")); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("open = %v, want ErrNoEncryptionKey", err)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	key, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(key) != len(apiKeyPrefix)+64 {
+		t.Errorf("newAPIKey = %q", key)
+	}
+	other, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Error("newAPIKey returned the same key twice")
+	}
+	if hashAPIKey(key) != hashAPIKey(key) || hashAPIKey(key) == hashAPIKey(other) {
+		t.Error("hashAPIKey is not a stable, distinct digest")
+	}
+}
@@ -0,0 +1,415 @@
+package tenants
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/video-compressor/internal/database"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/storage"
+)
+
+var (
+	ErrNotFound = errors.New("tenant not found")
+	ErrInvalid  = errors.New("invalid tenant")
+)
+
+// Store keeps tenants in Postgres and resolves the storage backend and
+// source policy each tenant's jobs use. Tenants without a storage
+// destination of their own use the deployment's backends.
+//
+// rewrites are the deployment's URL rewrite rules by backend name. They
+// apply to results on the deployment's backends, after the tenant's own.
+type Store struct {
+	db       *database.Database
+	registry *storage.Registry
+	sources  *storage.SourcePolicy
+	aead     cipher.AEAD
+	rewrites map[string][]models.URLRewriteRule
+
+	mu       sync.Mutex
+	resolved map[string]*resolved
+}
+
+// resolved is what a tenant's settings turn into, rebuilt whenever the
+// tenant is updated.
+type resolved struct {
+	updatedAt time.Time
+	backend   storage.Backend
+	sources   *storage.SourcePolicy
+	rewrites  []models.URLRewriteRule
+}
+
+func NewStore(db *database.Database, registry *storage.Registry, sources *storage.SourcePolicy, rewrites map[string][]models.URLRewriteRule, encryptionKey string) (*Store, error) {
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db:       db,
+		registry: registry,
+		sources:  sources,
+		aead:     aead,
+		rewrites: rewrites,
+		resolved: make(map[string]*resolved),
+	}, nil
+}
+
+// Authenticate returns the tenant an API key belongs to.
+func (s *Store) Authenticate(apiKey string) (*models.Tenant, error) {
+	tenant, err := s.db.GetTenantByAPIKeyHash(hashAPIKey(apiKey))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return tenant, nil
+}
+
+// Create registers a tenant and returns it with its API key, which is not
+// stored and cannot be shown again.
+func (s *Store) Create(req *models.TenantRequest) (*models.Tenant, string, error) {
+	tenant := &models.Tenant{
+		TenantID:    uuid.New().String(),
+		Name:        req.Name,
+		Storage:     req.Storage,
+		SourceHosts: req.SourceHosts,
+		URLRewrites: req.URLRewrites,
+	}
+	if err := s.validate(tenant); err != nil {
+		return nil, "", err
+	}
+
+	apiKey, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	tenant.APIKeyHash = hashAPIKey(apiKey)
+
+	if tenant.SealedStorage, err = s.seal(tenant.TenantID, tenant.Storage); err != nil {
+		return nil, "", err
+	}
+	if tenant.SealedURLRewrites, err = s.sealRewrites(tenant.TenantID, tenant.URLRewrites); err != nil {
+		return nil, "", err
+	}
+	if err := s.db.CreateTenant(tenant); err != nil {
+		return nil, "", err
+	}
+	return tenant, apiKey, nil
+}
+
+// Get returns a tenant with its storage config and URL rewrites decrypted.
+func (s *Store) Get(tenantID string) (*models.Tenant, error) {
+	tenant, err := s.db.GetTenant(tenantID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if err := s.unseal(tenant); err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+func (s *Store) List() ([]*models.Tenant, error) {
+	tenants, err := s.db.ListTenants()
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range tenants {
+		if err := s.unseal(tenant); err != nil {
+			return nil, err
+		}
+	}
+	return tenants, nil
+}
+
+func (s *Store) unseal(tenant *models.Tenant) error {
+	var err error
+	if tenant.Storage, err = s.open(tenant.TenantID, tenant.SealedStorage); err != nil {
+		return err
+	}
+	if tenant.URLRewrites, err = s.openRewrites(tenant.TenantID, tenant.SealedURLRewrites); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update replaces a tenant's settings. Secrets left empty keep their
+// current value as long as the backend stays the same, so a config read
+// back from the API, where secrets are redacted, can be sent again as is.
+// The same goes for the token secret of a URL rewrite rule, which is kept
+// from the rule at the same position.
+func (s *Store) Update(tenantID string, req *models.TenantRequest) (*models.Tenant, error) {
+	tenant, err := s.Get(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Storage != nil && tenant.Storage != nil && req.Storage.Backend == tenant.Storage.Backend {
+		if req.Storage.WordPressAppPassword == "" {
+			req.Storage.WordPressAppPassword = tenant.Storage.WordPressAppPassword
+		}
+		if req.Storage.S3SecretKey == "" {
+			req.Storage.S3SecretKey = tenant.Storage.S3SecretKey
+		}
+		if req.Storage.SFTPPassword == "" {
+			req.Storage.SFTPPassword = tenant.Storage.SFTPPassword
+		}
+		if req.Storage.SFTPPrivateKey == "" {
+			req.Storage.SFTPPrivateKey = tenant.Storage.SFTPPrivateKey
+		}
+		if req.Storage.WebDAVPassword == "" {
+			req.Storage.WebDAVPassword = tenant.Storage.WebDAVPassword
+		}
+	}
+
+	for i, rule := range req.URLRewrites {
+		if rule.Token == nil || rule.Token.Secret != "" || i >= len(tenant.URLRewrites) {
+			continue
+		}
+		if current := tenant.URLRewrites[i].Token; current != nil {
+			token := *rule.Token
+			token.Secret = current.Secret
+			req.URLRewrites[i].Token = &token
+		}
+	}
+
+	tenant.Name = req.Name
+	tenant.Storage = req.Storage
+	tenant.SourceHosts = req.SourceHosts
+	tenant.URLRewrites = req.URLRewrites
+	if err := s.validate(tenant); err != nil {
+		return nil, err
+	}
+	if tenant.SealedStorage, err = s.seal(tenant.TenantID, tenant.Storage); err != nil {
+		return nil, err
+	}
+	if tenant.SealedURLRewrites, err = s.sealRewrites(tenant.TenantID, tenant.URLRewrites); err != nil {
+		return nil, err
+	}
+	if err := s.db.UpdateTenant(tenant); err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// RotateKey gives a tenant a new API key. The old key stops working at once.
+func (s *Store) RotateKey(tenantID string) (string, error) {
+	apiKey, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.UpdateTenantAPIKey(tenantID, hashAPIKey(apiKey)); err != nil {
+		return "", ErrNotFound
+	}
+	return apiKey, nil
+}
+
+func (s *Store) Delete(tenantID string) error {
+	if err := s.db.DeleteTenant(tenantID); err != nil {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	delete(s.resolved, tenantID)
+	s.mu.Unlock()
+	return nil
+}
+
+// Backend returns the backend a job of the tenant stores its outputs on.
+// name is the job's storage_backend; a tenant with its own destination only
+// accepts that destination's backend name or an empty one.
+func (s *Store) Backend(tenantID, name string) (storage.Backend, error) {
+	r, err := s.resolve(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || r.backend == nil {
+		return s.registry.Get(name)
+	}
+	if name != "" && name != r.backend.Name() {
+		return nil, fmt.Errorf("storage backend %q is not configured for this tenant", name)
+	}
+	return r.backend, nil
+}
+
+// SourceBackend returns the backend that fetches the sources of the
+// tenant's jobs stored on backend. Tenants without storage of their own
+// share the deployment's backends, whose URLs may name other tenants'
+// files, so those are fetched under the tenant's source policy instead of
+// being read from the store.
+func (s *Store) SourceBackend(tenantID string, backend storage.Backend) storage.Backend {
+	if tenantID == "" {
+		return backend
+	}
+	if r, err := s.resolve(tenantID); err == nil && r != nil && r.backend != nil {
+		return backend
+	}
+	return storage.SharedSource(backend)
+}
+
+// Sources returns the source policy for the tenant's jobs.
+func (s *Store) Sources(tenantID string) (*storage.SourcePolicy, error) {
+	r, err := s.resolve(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return s.sources, nil
+	}
+	return r.sources, nil
+}
+
+// StorageName identifies the place a job's outputs end up. A tenant's own
+// destination is named "<backend>@<tenant_id>", so results stored there
+// are never mistaken for results on the deployment's backend of the same
+// type.
+func (s *Store) StorageName(tenantID, name string) string {
+	if r, err := s.resolve(tenantID); err == nil && r != nil && r.backend != nil {
+		return r.backend.Name() + "@" + tenantID
+	}
+	if name != "" {
+		return name
+	}
+	return s.registry.Default()
+}
+
+// URLRewriter returns the rewriter for the result URLs of a job stored
+// under storageName, or nil when no rule applies. The tenant's rules come
+// first; the deployment's rules only apply to its own backends.
+func (s *Store) URLRewriter(tenantID, storageName string, now time.Time) *storage.URLRewriter {
+	name, owner, _ := strings.Cut(storageName, "@")
+
+	var tenantRules, deploymentRules []models.URLRewriteRule
+	if r, err := s.resolve(tenantID); err == nil && r != nil {
+		tenantRules = r.rewrites
+	}
+	if owner == "" {
+		deploymentRules = s.rewrites[name]
+	}
+	return storage.NewURLRewriter(name, now, tenantRules, deploymentRules)
+}
+
+// BackendByStorageName is the reverse of StorageName.
+func (s *Store) BackendByStorageName(storageName string) (storage.Backend, error) {
+	name, tenantID, _ := strings.Cut(storageName, "@")
+	if tenantID == "" {
+		return s.registry.Get(name)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("storage backend %q is not configured", storageName)
+	}
+	return s.Backend(tenantID, name)
+}
+
+func (s *Store) resolve(tenantID string) (*resolved, error) {
+	if tenantID == "" {
+		return nil, nil
+	}
+
+	tenant, err := s.db.GetTenant(tenantID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.resolved[tenantID]; ok && r.updatedAt.Equal(tenant.UpdatedAt) {
+		return r, nil
+	}
+
+	cfg, err := s.open(tenant.TenantID, tenant.SealedStorage)
+	if err != nil {
+		return nil, err
+	}
+	r := &resolved{updatedAt: tenant.UpdatedAt}
+	if cfg != nil {
+		if r.backend, err = newBackend(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if r.sources, err = s.sources.WithHosts(tenant.SourceHosts); err != nil {
+		return nil, err
+	}
+	if r.rewrites, err = s.openRewrites(tenant.TenantID, tenant.SealedURLRewrites); err != nil {
+		return nil, err
+	}
+
+	s.resolved[tenantID] = r
+	return r, nil
+}
+
+func (s *Store) validate(tenant *models.Tenant) error {
+	tenant.Name = strings.TrimSpace(tenant.Name)
+	if tenant.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if _, err := s.sources.WithHosts(tenant.SourceHosts); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := storage.ValidateURLRewrites(tenant.URLRewrites); err != nil {
+		return fmt.Errorf("%w: url_rewrites: %v", ErrInvalid, err)
+	}
+	if len(tenant.URLRewrites) > 0 && s.aead == nil {
+		return ErrNoEncryptionKey
+	}
+	if tenant.Storage == nil {
+		return nil
+	}
+	if s.aead == nil {
+		return ErrNoEncryptionKey
+	}
+	if _, err := newBackend(tenant.Storage); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+func newBackend(cfg *models.TenantStorage) (storage.Backend, error) {
+	switch cfg.Backend {
+	case storage.BackendWordPress:
+		if cfg.WordPressAPIURL == "" || cfg.WordPressUsername == "" || cfg.WordPressAppPassword == "" {
+			return nil, fmt.Errorf("wordpress storage needs wordpress_api_url, wordpress_username and wordpress_app_password")
+		}
+		return storage.NewWordPressStorage(cfg.WordPressAPIURL, cfg.WordPressUsername, cfg.WordPressAppPassword), nil
+	case storage.BackendLocal:
+		if cfg.LocalPath == "" {
+			return nil, fmt.Errorf("local storage needs local_path")
+		}
+		return storage.NewLocalStorage(cfg.LocalPath, cfg.LocalBaseURL)
+	case storage.BackendS3:
+		return storage.NewS3Storage(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PublicURL: cfg.S3PublicURL,
+			PathStyle: cfg.S3PathStyle,
+		})
+	case storage.BackendSFTP:
+		return storage.NewSFTPStorage(storage.SFTPConfig{
+			Addr:       cfg.SFTPAddr,
+			Username:   cfg.SFTPUsername,
+			Password:   cfg.SFTPPassword,
+			PrivateKey: cfg.SFTPPrivateKey,
+			HostKey:    cfg.SFTPHostKey,
+			Root:       cfg.SFTPRoot,
+			PublicURL:  cfg.SFTPPublicURL,
+		})
+	case storage.BackendWebDAV:
+		return storage.NewWebDAVStorage(storage.WebDAVConfig{
+			URL:       cfg.WebDAVURL,
+			Username:  cfg.WebDAVUsername,
+			Password:  cfg.WebDAVPassword,
+			PublicURL: cfg.WebDAVPublicURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
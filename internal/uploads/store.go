@@ -0,0 +1,352 @@
+package uploads
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/video-compressor/internal/database"
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const cleanupInterval = 15 * time.Minute
+
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrTooLarge       = errors.New("upload is too large")
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrBusy           = errors.New("upload is being written by another request")
+	ErrIncomplete     = errors.New("upload is not complete")
+)
+
+// DB keeps the state of uploads. *database.Database keeps it in Postgres.
+type DB interface {
+	CreateUpload(upload *models.Upload) error
+	GetUpload(uploadID string) (*models.Upload, error)
+	AdvanceUpload(uploadID string, oldOffset, newOffset int64, expiresAt time.Time) (bool, error)
+	CompleteUpload(uploadID, sha256 string, expiresAt time.Time) error
+	DeleteUpload(uploadID string) error
+	DeleteExpiredUploads() ([]string, error)
+}
+
+var _ DB = (*database.Database)(nil)
+
+// Store keeps uploaded source files on local disk, next to the worker that
+// reads them, with their state in Postgres. Uploads expire ttl after they
+// were last written to or completed.
+type Store struct {
+	db      DB
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	writing sync.Map
+	stop    chan struct{}
+}
+
+func NewStore(db DB, dir string, ttl time.Duration, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	return &Store{
+		db:      db,
+		dir:     dir,
+		ttl:     ttl,
+		maxSize: maxSize,
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Create starts an upload of size bytes that is filled with Append.
+func (s *Store) Create(tenantID, filename, contentType string, size int64) (*models.Upload, error) {
+	if s.maxSize > 0 && size > s.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	upload := &models.Upload{
+		UploadID:    uuid.New().String(),
+		TenantID:    tenantID,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+		Status:      models.UploadStatusUploading,
+		ExpiresAt:   time.Now().Add(s.ttl),
+	}
+
+	file, err := os.Create(s.path(upload.UploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	file.Close()
+
+	if err := s.db.CreateUpload(upload); err != nil {
+		os.Remove(s.path(upload.UploadID))
+		return nil, err
+	}
+
+	if size == 0 {
+		return s.complete(upload)
+	}
+	return upload, nil
+}
+
+// Save stores a whole file in one go, as a multipart upload does.
+func (s *Store) Save(tenantID, filename, contentType string, r io.Reader) (*models.Upload, error) {
+	id := uuid.New().String()
+	path := s.path(id)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	defer file.Close()
+
+	if s.maxSize > 0 {
+		r = io.LimitReader(r, s.maxSize+1)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+	if s.maxSize > 0 && size > s.maxSize {
+		os.Remove(path)
+		return nil, ErrTooLarge
+	}
+
+	now := time.Now()
+	upload := &models.Upload{
+		UploadID:    id,
+		TenantID:    tenantID,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+		Offset:      size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Status:      models.UploadStatusComplete,
+		ExpiresAt:   now.Add(s.ttl),
+		CompletedAt: &now,
+	}
+
+	if err := s.db.CreateUpload(upload); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Get returns an upload that has not expired yet.
+func (s *Store) Get(uploadID string) (*models.Upload, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return nil, ErrNotFound
+	}
+
+	upload, err := s.db.GetUpload(uploadID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return upload, nil
+}
+
+// Append writes r to the upload starting at offset, which has to be where
+// the previous request left off. Whatever arrives before r fails is kept,
+// so a client can resume from the returned offset.
+func (s *Store) Append(uploadID string, offset int64, r io.Reader) (*models.Upload, error) {
+	if _, busy := s.writing.LoadOrStore(uploadID, struct{}{}); busy {
+		return nil, ErrBusy
+	}
+	defer s.writing.Delete(uploadID)
+
+	upload, err := s.Get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.UploadStatusUploading || offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	file, err := os.OpenFile(s.path(upload.UploadID), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	// Bytes past the recorded offset are from a request whose offset was
+	// never saved, so they are written again.
+	if err := file.Truncate(offset); err != nil {
+		return nil, fmt.Errorf("failed to truncate upload file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek upload file: %w", err)
+	}
+
+	written, copyErr := io.Copy(file, io.LimitReader(r, upload.Size-offset))
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	expiresAt := time.Now().Add(s.ttl)
+	if written > 0 {
+		ok, err := s.db.AdvanceUpload(upload.UploadID, offset, offset+written, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrOffsetMismatch
+		}
+		upload.Offset += written
+		upload.ExpiresAt = expiresAt
+	}
+	if copyErr != nil {
+		return upload, fmt.Errorf("failed to write upload: %w", copyErr)
+	}
+
+	if upload.Offset == upload.Size {
+		return s.complete(upload)
+	}
+	return upload, nil
+}
+
+func (s *Store) complete(upload *models.Upload) (*models.Upload, error) {
+	file, err := os.Open(s.path(upload.UploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("failed to hash upload: %w", err)
+	}
+
+	now := time.Now()
+	upload.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	upload.Status = models.UploadStatusComplete
+	upload.ExpiresAt = now.Add(s.ttl)
+	upload.CompletedAt = &now
+	if err := s.db.CompleteUpload(upload.UploadID, upload.SHA256, upload.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to complete upload: %w", err)
+	}
+	return upload, nil
+}
+
+func (s *Store) Delete(uploadID string) error {
+	if err := s.db.DeleteUpload(uploadID); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	if err := os.Remove(s.path(uploadID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete upload file: %w", err)
+	}
+	return nil
+}
+
+// CopyTo places a complete upload at destPath. It hard-links when it can,
+// since the upload directory and the job directories usually share a disk.
+func (s *Store) CopyTo(uploadID, destPath string, maxSize int64, checksum string) error {
+	upload, err := s.Get(uploadID)
+	if err != nil {
+		return err
+	}
+	if upload.Status != models.UploadStatusComplete {
+		return ErrIncomplete
+	}
+	if maxSize > 0 && upload.Size > maxSize {
+		return fmt.Errorf("%w: file is %d bytes, the limit is %d bytes", models.ErrLimitExceeded, upload.Size, maxSize)
+	}
+	if checksum != "" && !strings.EqualFold(checksum, upload.SHA256) {
+		return fmt.Errorf("%w: expected sha256 %s, got %s", models.ErrChecksumMismatch, strings.ToLower(checksum), upload.SHA256)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Link(s.path(uploadID), destPath); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(s.path(uploadID))
+	if err != nil {
+		return fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("failed to copy upload: %w", err)
+	}
+	return dst.Close()
+}
+
+// Start removes expired uploads, finished or not, until Stop is called.
+func (s *Store) Start() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	s.cleanup()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.cleanup()
+		}
+	}
+}
+
+func (s *Store) Stop() {
+	close(s.stop)
+}
+
+func (s *Store) cleanup() {
+	ids, err := s.db.DeleteExpiredUploads()
+	if err != nil {
+		log.Printf("Failed to clean up uploads: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove expired upload %s: %v", id, err)
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("Removed %d expired uploads", len(ids))
+	}
+}
+
+func (s *Store) path(uploadID string) string {
+	return filepath.Join(s.dir, uploadID)
+}
+
+// cleanFilename keeps only the last element of a client-supplied name.
+func cleanFilename(name string) string {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
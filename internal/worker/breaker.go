@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/video-compressor/internal/storage"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerStatus is the state of the circuit breaker of one storage target,
+// as shown by /ready.
+type BreakerStatus struct {
+	Storage  string       `json:"storage"`
+	State    BreakerState `json:"state"`
+	Failures int          `json:"consecutive_failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+}
+
+// breakers keeps one circuit breaker per storage target. Uploads that still
+// fail after their retries because the target is unreachable or answers
+// with a 5xx count against it; once threshold of them happen in a row the
+// breaker opens and jobs for the target are held back in Redis, keeping
+// their place in the queue. After
+// cooldown a single job is let through to probe the target: an upload that
+// succeeds closes the breaker, one that fails opens it again.
+type breakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probedAt time.Time
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*breaker),
+	}
+}
+
+func (b *breakers) get(storageName string) *breaker {
+	br, ok := b.breakers[storageName]
+	if !ok {
+		br = &breaker{state: BreakerClosed}
+		b.breakers[storageName] = br
+	}
+	return br
+}
+
+// allow reports whether a job storing its outputs on storageName may start.
+func (b *breakers) allow(storageName string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(storageName)
+	now := time.Now()
+	switch br.state {
+	case BreakerOpen:
+		if now.Sub(br.openedAt) < b.cooldown {
+			return false
+		}
+		log.Printf("Storage %s circuit half-open, letting a job through", storageName)
+		br.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		// The probe may not upload anything, for example when its result
+		// comes from the cache, so another one goes out after a cooldown.
+		if now.Sub(br.probedAt) < b.cooldown {
+			return false
+		}
+	default:
+		return true
+	}
+	br.probedAt = now
+	return true
+}
+
+// paused reports whether jobs for storageName would be turned away now. It
+// does not let a probe through.
+func (b *breakers) paused(storageName string) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.breakers[storageName]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	switch br.state {
+	case BreakerOpen:
+		return now.Sub(br.openedAt) < b.cooldown
+	case BreakerHalfOpen:
+		return now.Sub(br.probedAt) < b.cooldown
+	}
+	return false
+}
+
+// record counts the outcome of an upload to storageName. Failures the
+// request or file caused say nothing about the target and are ignored.
+func (b *breakers) record(storageName string, err error) {
+	if b.threshold <= 0 || (err != nil && !storage.IsRetryable(err)) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(storageName)
+	if err == nil {
+		if br.state != BreakerClosed {
+			log.Printf("Storage %s circuit closed", storageName)
+		}
+		br.state = BreakerClosed
+		br.failures = 0
+		return
+	}
+
+	br.failures++
+	if br.state == BreakerHalfOpen || (br.state == BreakerClosed && br.failures >= b.threshold) {
+		log.Printf("Storage %s circuit open after %d failed uploads, pausing its jobs for %s", storageName, br.failures, b.cooldown)
+		br.state = BreakerOpen
+		br.openedAt = time.Now()
+	}
+}
+
+func (b *breakers) status() []BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(b.breakers))
+	for name, br := range b.breakers {
+		status := BreakerStatus{
+			Storage:  name,
+			State:    br.state,
+			Failures: br.failures,
+		}
+		if br.state != BreakerClosed {
+			openedAt := br.openedAt
+			status.OpenedAt = &openedAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Storage < statuses[j].Storage
+	})
+	return statuses
+}
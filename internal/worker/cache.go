@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/storage"
+)
+
+// resultCacheVersion is part of every parameter hash. Bump it when a change
+// to the encoding pipeline should stop old results from being served.
+const resultCacheVersion = 1
+
+type resultCacheKey struct {
+	contentHash string
+	paramsHash  string
+}
+
+func (w *Worker) videoCacheKey(job *models.Job, inputPath string) *resultCacheKey {
+	if job.StorageMode == models.StorageModeReplace {
+		return nil
+	}
+	return w.cacheKey(job.JobID, inputPath, w.videoCacheParams(job))
+}
+
+func (w *Worker) imageCacheKey(job *models.Job, inputPath string) *resultCacheKey {
+	if job.StorageMode == models.StorageModeReplace {
+		return nil
+	}
+	return w.cacheKey(job.JobID, inputPath, w.imageCacheParams(job))
+}
+
+// videoCacheParams are the settings a video result depends on besides the
+// input. Results of jobs with equal params can stand in for each other. The
+// tenant is part of them: tenants without storage of their own share the
+// deployment's backends, but never each other's results.
+func (w *Worker) videoCacheParams(job *models.Job) interface{} {
+	data := job.VideoData
+	variants := append([]string(nil), data.HLSVariants...)
+	sort.Strings(variants)
+	title := mediaTitle(data.Title, w.sourceName(data.FileURL, data.UploadID))
+
+	return struct {
+		Version      int                 `json:"version"`
+		Tenant       string              `json:"tenant"`
+		Storage      string              `json:"storage"`
+		OutputNaming string              `json:"output_naming"`
+		SharedKey    string              `json:"shared_key"`
+		Quality      models.VideoQuality `json:"quality"`
+		HLSEnabled   bool                `json:"hls_enabled"`
+		HLSVariants  []string            `json:"hls_variants"`
+		Attachment   *attachmentParams   `json:"attachment,omitempty"`
+	}{
+		resultCacheVersion, job.TenantID, w.storageName(job), job.OutputNaming, keyTemplate(job).SharedKey(keyFields(job, title)),
+		data.Quality, data.HLSEnabled, variants, w.attachmentParams(job, title, ""),
+	}
+}
+
+func (w *Worker) imageCacheParams(job *models.Job) interface{} {
+	data := job.ImageData
+	variants := append([]models.ImageVariantSpec(nil), data.Variants...)
+	sort.Slice(variants, func(i, j int) bool { return variants[i].Name < variants[j].Name })
+	formats := append([]models.ImageFormat(nil), data.Formats...)
+	sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+	widths := append([]int(nil), data.SrcsetWidths...)
+	sort.Ints(widths)
+	title := mediaTitle(data.Title, w.sourceName(data.FileURL, data.UploadID))
+
+	return struct {
+		Version      int                       `json:"version"`
+		Tenant       string                    `json:"tenant"`
+		Storage      string                    `json:"storage"`
+		OutputNaming string                    `json:"output_naming"`
+		SharedKey    string                    `json:"shared_key"`
+		Backend      string                    `json:"backend"`
+		Quality      models.ImageQuality       `json:"quality"`
+		Variants     []models.ImageVariantSpec `json:"variants"`
+		Formats      []models.ImageFormat      `json:"formats"`
+		SrcsetWidths []int                     `json:"srcset_widths"`
+		FocalPoint   *models.FocalPoint        `json:"focal_point"`
+		QualityMode  models.QualityMode        `json:"quality_mode"`
+		TargetScore  float64                   `json:"target_score"`
+		Attachment   *attachmentParams         `json:"attachment,omitempty"`
+	}{
+		resultCacheVersion, job.TenantID, w.storageName(job), job.OutputNaming, keyTemplate(job).SharedKey(keyFields(job, title)), w.imageCompressor.Backend().Name(), data.Quality, variants, formats, widths,
+		data.FocalPoint, data.QualityMode, data.TargetScore,
+		w.attachmentParams(job, title, data.AltText),
+	}
+}
+
+// attachmentParams describe the media items a job's outputs become on a
+// backend with a media library. Its results hold attachments of the job's
+// post, so they only serve jobs for the same post, title and alt text.
+type attachmentParams struct {
+	PostID  int    `json:"post_id"`
+	Title   string `json:"title"`
+	AltText string `json:"alt_text"`
+}
+
+func (w *Worker) attachmentParams(job *models.Job, title, altText string) *attachmentParams {
+	name, _, _ := strings.Cut(w.storageName(job), "@")
+	if name != storage.BackendWordPress {
+		return nil
+	}
+	return &attachmentParams{
+		PostID:  job.PostID,
+		Title:   title,
+		AltText: altText,
+	}
+}
+
+// paramsHash hashes the canonical JSON form of the job's params for one
+// media type. It returns "" when the job has no data for that type.
+func (w *Worker) paramsHash(job *models.Job, mediaType models.CompressionType) (string, error) {
+	var params interface{}
+	switch {
+	case mediaType == models.CompressionTypeVideo && job.VideoData != nil:
+		params = w.videoCacheParams(job)
+	case mediaType == models.CompressionTypeImage && job.ImageData != nil:
+		params = w.imageCacheParams(job)
+	default:
+		return "", nil
+	}
+	return hashParams(params)
+}
+
+func hashParams(params interface{}) (string, error) {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(paramsJSON)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// storageName is the backend the job's outputs go to. Cached results hold
+// URLs on that backend, so it is part of every key.
+func (w *Worker) storageName(job *models.Job) string {
+	return w.tenants.StorageName(job.TenantID, job.StorageBackend)
+}
+
+// cacheKey hashes the input file and the canonical JSON form of params. It
+// returns nil when the cache is disabled or the input cannot be read.
+func (w *Worker) cacheKey(jobID, inputPath string, params interface{}) *resultCacheKey {
+	if w.config.ResultCacheTTL <= 0 {
+		return nil
+	}
+
+	contentHash, err := fileSHA256(inputPath)
+	if err != nil {
+		log.Printf("Failed to hash input for job %s: %v", jobID, err)
+		return nil
+	}
+
+	paramsHash, err := hashParams(params)
+	if err != nil {
+		log.Printf("Failed to encode parameters for job %s: %v", jobID, err)
+		return nil
+	}
+
+	return &resultCacheKey{
+		contentHash: contentHash,
+		paramsHash:  paramsHash,
+	}
+}
+
+func (w *Worker) cachedVideoResult(job *models.Job, key *resultCacheKey) *models.VideoResult {
+	var result models.VideoResult
+	sourceJobID, ok := w.lookupCache(job.JobID, models.CompressionTypeVideo, key, &result)
+	if !ok {
+		return nil
+	}
+	result.Cached = true
+	result.CachedFrom = sourceJobID
+	result.ProcessingTime = 0
+	return &result
+}
+
+func (w *Worker) cachedImageResult(job *models.Job, key *resultCacheKey) *models.ImageResult {
+	var result models.ImageResult
+	sourceJobID, ok := w.lookupCache(job.JobID, models.CompressionTypeImage, key, &result)
+	if !ok {
+		return nil
+	}
+	result.Cached = true
+	result.CachedFrom = sourceJobID
+	result.ProcessingTime = 0
+	return &result
+}
+
+func (w *Worker) lookupCache(jobID string, mediaType models.CompressionType, key *resultCacheKey, dest interface{}) (string, bool) {
+	if key == nil {
+		return "", false
+	}
+
+	sourceJobID, found, err := w.db.GetCachedResult(mediaType, key.contentHash, key.paramsHash, w.config.ResultCacheTTL, dest)
+	if err != nil {
+		log.Printf("Failed to read result cache for job %s: %v", jobID, err)
+		return "", false
+	}
+	if found {
+		log.Printf("Serving %s result for job %s from cache (produced by job %s)", mediaType, jobID, sourceJobID)
+	}
+	return sourceJobID, found
+}
+
+func (w *Worker) cacheResult(jobID string, mediaType models.CompressionType, key *resultCacheKey, result interface{}) {
+	if key == nil {
+		return
+	}
+	if err := w.db.SaveCachedResult(mediaType, key.contentHash, key.paramsHash, jobID, result); err != nil {
+		log.Printf("Failed to cache %s result for job %s: %v", mediaType, jobID, err)
+	}
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
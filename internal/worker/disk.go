@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const janitorInterval = 10 * time.Minute
+
+// tempArtifact matches what the compressors used to write straight into
+// TempDir before every job got a directory of its own, such as hls_<unix>,
+// compressed_<unix>.mp4 and <variant>_<unix>.webp.
+var tempArtifact = regexp.MustCompile(`^.+_\d{9,}([._].*)?$`)
+
+// jobDir returns the directory a job works in. Submitted job IDs are
+// validated, but jobs queued before that was checked may hold separators or
+// dot segments, and those must never resolve outside TempDir.
+func (w *Worker) jobDir(jobID string) (string, bool) {
+	if jobID == "" || jobID == "." || jobID == ".." || strings.ContainsAny(jobID, `/\`) {
+		return "", false
+	}
+	dir := filepath.Join(w.config.TempDir, jobID)
+	if filepath.Dir(dir) != filepath.Clean(w.config.TempDir) {
+		return "", false
+	}
+	return dir, true
+}
+
+// tempDisk admits jobs only while the disk holding TempDir has room for
+// them. Each running job holds a reservation of its estimated need until it
+// finishes, whether or not it has written that much yet.
+// Walking TempDir is slow with many HLS trees in it, so the space it takes
+// up is measured by the janitor rather than on every readiness check.
+type tempDisk struct {
+	dir     string
+	minFree int64
+
+	mu       sync.Mutex
+	reserved map[string]int64
+	blocked  bool
+	used     int64
+	measured time.Time
+}
+
+func newTempDisk(dir string, minFree int64) *tempDisk {
+	return &tempDisk{
+		dir:      dir,
+		minFree:  minFree,
+		reserved: make(map[string]int64),
+	}
+}
+
+// reserve admits a job needing need bytes. A job is always admitted when no
+// other job is running and the minimum is free, so one larger than the
+// whole budget cannot hold up the queue forever.
+func (d *tempDisk) reserve(jobID string, need int64) bool {
+	free, _, err := diskSpace(d.dir)
+	if err != nil {
+		log.Printf("Failed to check temp disk space: %v", err)
+		free = -1
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if free >= 0 {
+		available := free - d.reservedBytes() - d.minFree
+		if need > available && (len(d.reserved) > 0 || free < d.minFree) {
+			if !d.blocked {
+				log.Printf("Temp disk has %d bytes free with %d reserved, holding job %s that needs about %d", free, d.reservedBytes(), jobID, need)
+			}
+			d.blocked = true
+			return false
+		}
+	}
+
+	if d.blocked {
+		log.Printf("Temp disk has room again, admitting jobs")
+	}
+	d.blocked = false
+	d.reserved[jobID] = need
+	return true
+}
+
+// grow adds bytes to a running job's reservation once more of its need is
+// known.
+func (d *tempDisk) grow(jobID string, bytes int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.reserved[jobID]; ok {
+		d.reserved[jobID] += bytes
+	}
+}
+
+func (d *tempDisk) release(jobID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.reserved, jobID)
+}
+
+func (d *tempDisk) reservedBytes() int64 {
+	var total int64
+	for _, need := range d.reserved {
+		total += need
+	}
+	return total
+}
+
+// measure records the space the files in TempDir take up.
+func (d *tempDisk) measure() {
+	used := dirSize(d.dir)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.used = used
+	d.measured = time.Now()
+}
+
+func (d *tempDisk) usage() *models.TempDiskUsage {
+	usage := &models.TempDiskUsage{
+		Path:         d.dir,
+		MinFreeBytes: d.minFree,
+	}
+	if free, total, err := diskSpace(d.dir); err == nil {
+		usage.FreeBytes = free
+		usage.TotalBytes = total
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	usage.ReservedBytes = d.reservedBytes()
+	usage.Admitting = !d.blocked
+	if !d.measured.IsZero() {
+		measured := d.measured
+		usage.UsedBytes = d.used
+		usage.MeasuredAt = &measured
+	}
+	return usage
+}
+
+// TempUsage reports the temp disk for /ready and the queue stats.
+func (w *Worker) TempUsage() *models.TempDiskUsage {
+	return w.disk.usage()
+}
+
+// diskNeed estimates the temp space a job takes up: its inputs and
+// everything made from them. Only the size of uploaded inputs is recorded
+// when the job is submitted; downloaded inputs count as nothing until
+// fetchSource has them, leaving the minimum free space to cover them.
+func (w *Worker) diskNeed(job *models.Job) int64 {
+	return job.SourceSize * int64(w.config.TempSpaceMultiplier)
+}
+
+func (w *Worker) runJanitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	w.cleanTempDir()
+	w.disk.measure()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.cleanTempDir()
+			w.disk.measure()
+		}
+	}
+}
+
+// cleanTempDir removes job directories and older compressor outputs that
+// crashed or killed jobs left in TempDir once they are older than
+// TempOrphanTTL.
+// Everything a running job writes is newer than the job, so nothing
+// modified after the oldest running job started is touched.
+func (w *Worker) cleanTempDir() {
+	cutoff := time.Now().Add(-w.config.TempOrphanTTL)
+	w.activeJobs.Range(func(_, started interface{}) bool {
+		if t := started.(time.Time); t.Before(cutoff) {
+			cutoff = t
+		}
+		return true
+	})
+
+	entries, err := os.ReadDir(w.config.TempDir)
+	if err != nil {
+		log.Printf("Failed to read temp dir: %v", err)
+		return
+	}
+
+	var removed int
+	var freed int64
+	for _, entry := range entries {
+		entryPath := filepath.Join(w.config.TempDir, entry.Name())
+		if samePath(entryPath, w.config.UploadDir) {
+			continue
+		}
+		if _, err := uuid.Parse(entry.Name()); err != nil && !tempArtifact.MatchString(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		size := info.Size()
+		if info.IsDir() {
+			size = dirSize(entryPath)
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			log.Printf("Failed to remove %s: %v", entryPath, err)
+			continue
+		}
+		removed++
+		freed += size
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d orphaned temp files, freeing %d bytes", removed, freed)
+	}
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package worker
+
+import "errors"
+
+func diskSpace(dir string) (free, total int64, err error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobDir(t *testing.T) {
+	tempDir := t.TempDir()
+	w := &Worker{config: &Config{TempDir: tempDir}}
+
+	tests := []struct {
+		jobID string
+		valid bool
+	}{
+		{"a1b2c3d4-e5f6-7890-abcd-ef1234567890", true},
+		{"post_42", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc", false},
+		{"a/../../etc", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"/etc", false},
+	}
+	for _, tt := range tests {
+		dir, ok := w.jobDir(tt.jobID)
+		if ok != tt.valid {
+			t.Errorf("jobDir(%q) = %q, %v, want valid %v", tt.jobID, dir, ok, tt.valid)
+			continue
+		}
+		if ok && filepath.Dir(dir) != tempDir {
+			t.Errorf("jobDir(%q) = %q, not directly under %s", tt.jobID, dir, tempDir)
+		}
+	}
+}
+
+func TestTempDiskGrow(t *testing.T) {
+	d := newTempDisk(t.TempDir(), 0)
+	if !d.reserve("a", 10) {
+		t.Fatal("reserve refused the only job")
+	}
+	d.grow("a", 5)
+	d.grow("finished", 100)
+	if got := d.reservedBytes(); got != 15 {
+		t.Errorf("reservedBytes = %d, want 15", got)
+	}
+	d.release("a")
+	if got := d.reservedBytes(); got != 0 {
+		t.Errorf("reservedBytes after release = %d, want 0", got)
+	}
+}
+
+func TestTempDiskUsageMeasured(t *testing.T) {
+	dir := t.TempDir()
+	d := newTempDisk(dir, 0)
+	if usage := d.usage(); usage.UsedBytes != 0 || usage.MeasuredAt != nil {
+		t.Errorf("usage before measuring = %d bytes at %v", usage.UsedBytes, usage.MeasuredAt)
+	}
+
+	writeFile(t, filepath.Join(dir, "job", "input.mp4"), 10)
+	d.measure()
+	writeFile(t, filepath.Join(dir, "job", "output.mp4"), 5)
+
+	// Readiness checks report the last measurement instead of walking
+	// TempDir again.
+	usage := d.usage()
+	if usage.UsedBytes != 10 || usage.MeasuredAt == nil {
+		t.Errorf("usage = %d bytes at %v, want 10 as measured", usage.UsedBytes, usage.MeasuredAt)
+	}
+	d.measure()
+	if usage := d.usage(); usage.UsedBytes != 15 {
+		t.Errorf("usage after measuring again = %d bytes, want 15", usage.UsedBytes)
+	}
+}
+
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package worker
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskSpace returns the bytes available to the process and the size of the
+// filesystem holding dir.
+func diskSpace(dir string) (free, total int64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, fmt.Errorf("failed to read disk space: %w", err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), int64(stat.Blocks) * int64(stat.Bsize), nil
+}
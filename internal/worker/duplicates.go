@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"log"
+	"os"
+
+	"github.com/yourusername/video-compressor/internal/models"
+)
+
+const videoHashFrames = 5
+
+func (w *Worker) videoHashes(jobID, inputPath, workDir string, duration float64) []uint64 {
+	if duration <= 0 {
+		return nil
+	}
+
+	frames, err := w.videoCompressor.ExtractKeyframes(inputPath, workDir, duration, videoHashFrames)
+	if err != nil {
+		log.Printf("Failed to extract keyframes for job %s: %v", jobID, err)
+		return nil
+	}
+
+	var hashes []uint64
+	for _, frame := range frames {
+		hash, err := w.imageCompressor.PerceptualHash(frame)
+		os.Remove(frame)
+		if err != nil {
+			log.Printf("Failed to hash keyframe for job %s: %v", jobID, err)
+			return nil
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// findDuplicate stores the hashes of a job's media and, if the job asked to
+// reuse duplicates, returns the closest earlier job that already has a result
+// for the same media type, made with the same params. A result made with
+// other settings, such as fewer formats, is never reused.
+func (w *Worker) findDuplicate(job *models.Job, mediaType models.CompressionType, hashes []uint64) *models.Job {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	if err := w.db.SaveMediaHashes(job.JobID, mediaType, hashes); err != nil {
+		log.Printf("Failed to save %s hashes for job %s: %v", mediaType, job.JobID, err)
+	}
+
+	// A replace-mode job has to overwrite its own attachment, so another
+	// job's result is never good enough.
+	if !job.ReuseDuplicate || job.StorageMode == models.StorageModeReplace {
+		return nil
+	}
+
+	maxDistance := models.DefaultDuplicateMaxDistance
+	if job.DuplicateMaxDistance != nil {
+		maxDistance = *job.DuplicateMaxDistance
+	}
+
+	params, err := w.paramsHash(job, mediaType)
+	if err != nil || params == "" {
+		return nil
+	}
+
+	matches, err := w.db.FindDuplicates(job.JobID, job.TenantID, mediaType, hashes, maxDistance, 10)
+	if err != nil {
+		log.Printf("Failed to look up duplicates for job %s: %v", job.JobID, err)
+		return nil
+	}
+
+	for _, match := range matches {
+		original, err := w.db.GetJobByID(match.JobID)
+		if err != nil {
+			continue
+		}
+		if originalParams, err := w.paramsHash(original, mediaType); err != nil || originalParams != params {
+			continue
+		}
+		switch {
+		case mediaType == models.CompressionTypeVideo && original.VideoResult != nil && original.VideoResult.Status == "completed":
+			return original
+		case mediaType == models.CompressionTypeImage && original.ImageResult != nil && original.ImageResult.Status == "completed":
+			return original
+		}
+	}
+	return nil
+}
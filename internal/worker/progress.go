@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yourusername/video-compressor/internal/database"
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/storage"
+)
+
+// progressInterval is the least time between two upload progress writes.
+const progressInterval = time.Second
+
+func (w *Worker) setStep(jobID string, mediaType models.CompressionType, step models.JobStep) {
+	if err := w.db.UpdateProgress(jobID, mediaType, step, nil); err != nil {
+		log.Printf("Failed to record %s step for job %s: %v", mediaType, jobID, err)
+	}
+}
+
+// uploadProgress turns the byte counts reported by the storage backend into
+// the upload percentage of one media part of a job.
+type uploadProgress struct {
+	mu        sync.Mutex
+	db        *database.Database
+	jobID     string
+	mediaType models.CompressionType
+	total     int64
+	queued    int64
+	percent   int
+	written   time.Time
+}
+
+// startUpload moves the media part to the uploading step. total is the
+// number of bytes that will be uploaded through the returned tracker.
+func (w *Worker) startUpload(jobID string, mediaType models.CompressionType, total int64) *uploadProgress {
+	u := &uploadProgress{
+		db:        w.db,
+		jobID:     jobID,
+		mediaType: mediaType,
+		total:     total,
+		percent:   -1,
+	}
+	u.report(0)
+	return u
+}
+
+// file returns the progress callback for the next upload of size bytes.
+func (u *uploadProgress) file(size int64) storage.ProgressFunc {
+	u.mu.Lock()
+	base := u.queued
+	u.queued += size
+	u.mu.Unlock()
+
+	return func(sent, _ int64) {
+		u.report(base + sent)
+	}
+}
+
+func (u *uploadProgress) report(sent int64) {
+	percent := 100
+	if u.total > 0 && sent < u.total {
+		percent = int(sent * 100 / u.total)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if percent == u.percent || (percent < 100 && u.percent >= 0 && time.Since(u.written) < progressInterval) {
+		return
+	}
+	u.percent = percent
+	u.written = time.Now()
+
+	if err := u.db.UpdateProgress(u.jobID, u.mediaType, models.JobStepUploading, &percent); err != nil {
+		log.Printf("Failed to record upload progress for job %s: %v", u.jobID, err)
+	}
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"os"
+
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/storage"
+)
+
+// destination resolves the backend a job stores its outputs on and the
+// policy its source URLs are fetched under, both set by the job's tenant.
+func (w *Worker) destination(job *models.Job) (storage.Backend, *storage.SourcePolicy, error) {
+	backend, err := w.tenants.Backend(job.TenantID, job.StorageBackend)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources, err := w.tenants.Sources(job.TenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return backend, sources, nil
+}
+
+// fetchSource puts a job's source file at inputPath. Files pushed to the
+// upload endpoints come from the upload store, everything else is
+// downloaded through the job's storage backend as the tenant sees it. The
+// job's temp space reservation grows by the estimate for a downloaded file,
+// whose size was not known when the job was admitted.
+func (w *Worker) fetchSource(job *models.Job, backend storage.Backend, fileURL, uploadID, inputPath string, opts storage.DownloadOptions) error {
+	if uploadID != "" {
+		return w.uploads.CopyTo(uploadID, inputPath, opts.MaxSize, opts.SHA256)
+	}
+	if err := w.tenants.SourceBackend(job.TenantID, backend).Download(fileURL, inputPath, opts); err != nil {
+		return err
+	}
+	if info, err := os.Stat(inputPath); err == nil {
+		w.disk.grow(job.JobID, info.Size()*int64(w.config.TempSpaceMultiplier))
+	}
+	return nil
+}
+
+// sourceName is the source URL, or the client's file name for uploads. It
+// is used for the input's file extension and default output titles.
+func (w *Worker) sourceName(fileURL, uploadID string) string {
+	if uploadID == "" {
+		return fileURL
+	}
+	if upload, err := w.uploads.Get(uploadID); err == nil {
+		return upload.Filename
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/video-compressor/internal/models"
+	"github.com/yourusername/video-compressor/internal/storage"
+)
+
+// mediaUpload carries what a media library is told about the outputs of one
+// job: they are attached to the job's post and titled after the source.
+// Keys come from the job's naming template. Every upload made with it is
+// counted by the breaker of the job's storage target.
+type mediaUpload struct {
+	storage.UploadOptions
+	keys   *storage.KeyTemplate
+	fields storage.KeyFields
+	report func(err error)
+}
+
+func (w *Worker) newMediaUpload(job *models.Job, title, altText, sourceURL string) mediaUpload {
+	title = mediaTitle(title, sourceURL)
+	storageName := w.storageName(job)
+	return mediaUpload{
+		UploadOptions: storage.UploadOptions{
+			PostID:         job.PostID,
+			Title:          title,
+			AltText:        altText,
+			Retries:        w.config.UploadRetries,
+			IdempotencyKey: job.JobID,
+			// A job that started before was requeued, whether by a retry,
+			// its storage breaker or a crashed worker, and may have
+			// uploaded some of its outputs already.
+			Resumed: job.StartedAt != nil || job.RetryCount > 0,
+		},
+		keys:   keyTemplate(job),
+		fields: keyFields(job, title),
+		report: func(err error) {
+			w.breakers.record(storageName, err)
+		},
+	}
+}
+
+// keyTemplate parses the job's output naming. Jobs stored before a template
+// became invalid fall back to the default.
+func keyTemplate(job *models.Job) *storage.KeyTemplate {
+	keys, err := storage.ParseKeyTemplate(job.OutputNaming)
+	if err != nil {
+		log.Printf("Invalid output naming for job %s, using the default: %v", job.JobID, err)
+		keys, _ = storage.ParseKeyTemplate("")
+	}
+	return keys
+}
+
+func keyFields(job *models.Job, title string) storage.KeyFields {
+	return storage.KeyFields{
+		JobID:  job.JobID,
+		PostID: job.PostID,
+		Slug:   title,
+	}
+}
+
+// labelled returns the options for one output, with label added to the title
+// so the outputs of a job can be told apart in the media library.
+func (m mediaUpload) labelled(label string) mediaUpload {
+	if m.Title != "" {
+		m.Title += " (" + label + ")"
+	}
+	return m
+}
+
+// key names an output of the job. ext is taken from localPath, so the
+// formats of one variant get keys of their own.
+func (m mediaUpload) key(variant, localPath string) string {
+	return m.keys.Key(m.fields, variant, strings.TrimPrefix(filepath.Ext(localPath), "."))
+}
+
+// upload stores localPath as the given variant of the job.
+func (m mediaUpload) upload(backend storage.Backend, localPath, variant string) (*storage.UploadedObject, error) {
+	object, err := backend.Upload(localPath, m.key(variant, localPath), m.UploadOptions)
+	m.report(err)
+	return object, err
+}
+
+// uploadTree stores the files below localDir under the key of the variant
+// with no extension.
+func (m mediaUpload) uploadTree(backend storage.Backend, localDir, variant string) (map[string]*storage.UploadedObject, error) {
+	objects, err := backend.UploadTree(localDir, m.keys.Key(m.fields, variant, ""), m.UploadOptions)
+	m.report(err)
+	return objects, err
+}
+
+// replaceAttachment overwrites the file of the job's attachment with
+// localPath instead of adding a new media item. The plugin backs up the
+// current file on every call, so it is not retried.
+func (m mediaUpload) replaceAttachment(backend storage.Backend, job *models.Job, localPath, variant string) (*storage.UploadedObject, error) {
+	replacer, ok := backend.(storage.AttachmentReplacer)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %s cannot replace attachments", backend.Name())
+	}
+	object, err := replacer.ReplaceAttachment(attachmentID(job), job.JobID, localPath, m.key(variant, localPath), m.Progress)
+	m.report(err)
+	return object, err
+}
+
+// attachmentID is the attachment a replace-mode job overwrites. The plugin
+// sends the attachment itself as the post, so that is the default.
+func attachmentID(job *models.Job) int {
+	if job.AttachmentID != nil {
+		return *job.AttachmentID
+	}
+	return job.PostID
+}
+
+// mediaTitle is the title the outputs of a job get: the one from the
+// request, or else the source file name.
+func mediaTitle(title, sourceURL string) string {
+	if title != "" {
+		return title
+	}
+	return sourceTitle(sourceURL)
+}
+
+func sourceTitle(sourceURL string) string {
+	name := sourceURL
+	if parsed, err := url.Parse(sourceURL); err == nil {
+		name = parsed.Path
+	}
+	name = path.Base(name)
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
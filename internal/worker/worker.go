@@ -2,10 +2,12 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +16,8 @@ import (
 	"github.com/yourusername/video-compressor/internal/models"
 	"github.com/yourusername/video-compressor/internal/queue"
 	"github.com/yourusername/video-compressor/internal/storage"
+	"github.com/yourusername/video-compressor/internal/tenants"
+	"github.com/yourusername/video-compressor/internal/uploads"
 	"github.com/yourusername/video-compressor/pkg/config"
 )
 
@@ -23,7 +27,10 @@ type Worker struct {
 	queue            *queue.RedisQueue
 	videoCompressor  *compressor.VideoCompressor
 	imageCompressor  *compressor.ImageCompressor
-	storage          *storage.WordPressStorage
+	tenants          *tenants.Store
+	uploads          *uploads.Store
+	breakers         *breakers
+	disk             *tempDisk
 	activeJobs       sync.Map
 	maxConcurrentJobs int
 	ctx              context.Context
@@ -35,8 +42,16 @@ type Config struct {
 	JobTimeout        time.Duration
 	CheckInterval     time.Duration
 	TempDir           string
+	UploadDir         string
+	TempSpaceMultiplier int
+	TempOrphanTTL     time.Duration
 	MaxRetries        int
 	RetryBackoff      []int
+	DownloadRetries   int
+	UploadRetries     int
+	MaxVideoFileSize  int64
+	MaxImageFileSize  int64
+	ResultCacheTTL    time.Duration
 }
 
 func NewWorker(
@@ -45,7 +60,8 @@ func NewWorker(
 	q *queue.RedisQueue,
 	videoComp *compressor.VideoCompressor,
 	imageComp *compressor.ImageCompressor,
-	wpStorage *storage.WordPressStorage,
+	tenantStore *tenants.Store,
+	store *uploads.Store,
 ) *Worker {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -55,14 +71,25 @@ func NewWorker(
 			JobTimeout:        time.Duration(cfg.JobTimeout) * time.Second,
 			CheckInterval:     time.Duration(cfg.QueueCheckInterval) * time.Second,
 			TempDir:           cfg.TempDir,
+			UploadDir:         cfg.UploadDir,
+			TempSpaceMultiplier: cfg.TempSpaceMultiplier,
+			TempOrphanTTL:     time.Duration(cfg.TempOrphanTTL) * time.Second,
 			MaxRetries:        cfg.MaxRetries,
 			RetryBackoff:      cfg.RetryBackoffSeconds,
+			DownloadRetries:   cfg.DownloadRetries,
+			UploadRetries:     cfg.UploadRetries,
+			MaxVideoFileSize:  cfg.MaxVideoFileSize,
+			MaxImageFileSize:  cfg.MaxImageFileSize,
+			ResultCacheTTL:    time.Duration(cfg.ResultCacheTTL) * time.Second,
 		},
 		db:                db,
 		queue:             q,
 		videoCompressor:   videoComp,
 		imageCompressor:   imageComp,
-		storage:           wpStorage,
+		tenants:           tenantStore,
+		uploads:           store,
+		disk:              newTempDisk(cfg.TempDir, cfg.TempMinFreeBytes),
+		breakers:          newBreakers(cfg.StorageBreakerThreshold, time.Duration(cfg.StorageBreakerCooldown)*time.Second),
 		maxConcurrentJobs: cfg.MaxConcurrentJobs,
 		ctx:               ctx,
 		cancel:            cancel,
@@ -72,6 +99,8 @@ func NewWorker(
 func (w *Worker) Start() {
 	log.Println("Worker started, checking queue every", w.config.CheckInterval)
 
+	go w.runJanitor()
+
 	ticker := time.NewTicker(w.config.CheckInterval)
 	defer ticker.Stop()
 
@@ -91,6 +120,12 @@ func (w *Worker) Stop() {
 	w.cancel()
 }
 
+// StorageStatus returns the circuit breaker state of every storage target
+// the worker has uploaded to.
+func (w *Worker) StorageStatus() []BreakerStatus {
+	return w.breakers.status()
+}
+
 func (w *Worker) processQueue() {
 	activeCount := 0
 	w.activeJobs.Range(func(_, _ interface{}) bool {
@@ -104,11 +139,13 @@ func (w *Worker) processQueue() {
 
 	availableSlots := w.maxConcurrentJobs - activeCount
 
-	for i := 0; i < availableSlots; i++ {
-		jobID, err := w.queue.Dequeue()
+	w.releaseHeldJobs()
+
+	for availableSlots > 0 {
+		jobID, err := w.queue.Peek()
 		if err != nil {
 			log.Printf("Failed to dequeue job: %v", err)
-			continue
+			return
 		}
 
 		if jobID == "" {
@@ -118,21 +155,83 @@ func (w *Worker) processQueue() {
 		job, err := w.db.GetJobByID(jobID)
 		if err != nil {
 			log.Printf("Failed to get job %s: %v", jobID, err)
-			w.queue.MarkComplete(jobID)
+			if err := w.queue.RemoveJob(jobID); err != nil {
+				log.Printf("Failed to drop job %s: %v", jobID, err)
+				return
+			}
 			continue
 		}
 
-		w.activeJobs.Store(jobID, true)
+		// Jobs for a paused storage target are held back in their place
+		// until its breaker lets them through again.
+		storageName := w.storageName(job)
+		if !w.breakers.allow(storageName) {
+			if err := w.queue.Hold(jobID, storageName); err != nil {
+				log.Printf("Failed to hold job %s: %v", jobID, err)
+				return
+			}
+			continue
+		}
+
+		// Jobs are admitted in queue order, so one waiting for space is not
+		// overtaken by smaller ones.
+		if !w.disk.reserve(jobID, w.diskNeed(job)) {
+			break
+		}
+
+		claimed, err := w.queue.Claim(jobID)
+		if err != nil || !claimed {
+			w.disk.release(jobID)
+			if err != nil {
+				log.Printf("Failed to dequeue job %s: %v", jobID, err)
+				return
+			}
+			continue
+		}
+
+		w.activeJobs.Store(jobID, time.Now())
 		go w.processJob(job)
+		availableSlots--
+	}
+}
+
+// releaseHeldJobs puts the jobs held for storage targets that are no longer
+// paused back in the queue.
+func (w *Worker) releaseHeldJobs() {
+	targets, err := w.queue.HeldTargets()
+	if err != nil {
+		log.Printf("Failed to read held jobs: %v", err)
+		return
+	}
+	for _, target := range targets {
+		if w.breakers.paused(target) {
+			continue
+		}
+		if released, err := w.queue.Release(target); err != nil {
+			log.Printf("Failed to release jobs held for %s: %v", target, err)
+		} else if released > 0 {
+			log.Printf("Released %d jobs held for storage %s", released, target)
+		}
 	}
 }
 
 func (w *Worker) processJob(job *models.Job) {
+	jobDir, dirOK := w.jobDir(job.JobID)
 	defer func() {
 		w.activeJobs.Delete(job.JobID)
+		w.disk.release(job.JobID)
+		if dirOK {
+			os.RemoveAll(jobDir)
+		}
 		w.queue.MarkComplete(job.JobID)
 	}()
 
+	if !dirOK {
+		log.Printf("Job %s failed permanently: invalid job ID", job.JobID)
+		w.db.MarkJobFailed(job.JobID, "invalid job ID")
+		return
+	}
+
 	log.Printf("Processing job %s (type: %s)", job.JobID, job.CompressionType)
 
 	ctx, cancel := context.WithTimeout(w.ctx, w.config.JobTimeout)
@@ -178,7 +277,9 @@ func (w *Worker) processJob(job *models.Job) {
 			errorMsg += fmt.Sprintf("Image: %v", imageErr)
 		}
 
-		if job.RetryCount < w.config.MaxRetries {
+		permanent := isPermanent(videoErr) || isPermanent(imageErr)
+
+		if job.RetryCount < w.config.MaxRetries && !permanent {
 			log.Printf("Job %s failed (attempt %d/%d): %s", job.JobID, job.RetryCount+1, w.config.MaxRetries, errorMsg)
 			w.db.IncrementRetryCount(job.JobID)
 			
@@ -209,18 +310,56 @@ func (w *Worker) processVideo(ctx context.Context, job *models.Job) error {
 
 	w.db.UpdateVideoStatus(job.JobID, models.JobStatusProcessing)
 
-	jobDir := filepath.Join(w.config.TempDir, job.JobID)
-	if err := os.MkdirAll(jobDir, 0755); err != nil {
-		return fmt.Errorf("failed to create job directory: %w", err)
+	backend, sources, err := w.destination(job)
+	if err != nil {
+		return err
 	}
-	defer os.RemoveAll(jobDir)
 
-	inputPath := filepath.Join(jobDir, "input_video"+filepath.Ext(job.VideoData.FileURL))
-	log.Printf("Downloading video from %s", job.VideoData.FileURL)
-	if err := w.storage.DownloadFile(job.VideoData.FileURL, inputPath); err != nil {
+	jobDir, _ := w.jobDir(job.JobID)
+	workDir := filepath.Join(jobDir, "video")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return fmt.Errorf("failed to create job directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	source := w.sourceName(job.VideoData.FileURL, job.VideoData.UploadID)
+	inputPath := filepath.Join(workDir, "input_video"+filepath.Ext(source))
+	w.setStep(job.JobID, models.CompressionTypeVideo, models.JobStepDownloading)
+	log.Printf("Downloading video from %s", source)
+	if err := w.fetchSource(job, backend, job.VideoData.FileURL, job.VideoData.UploadID, inputPath, storage.DownloadOptions{
+		MaxSize: w.config.MaxVideoFileSize,
+		SHA256:  job.VideoData.SHA256,
+		Retries: w.config.DownloadRetries,
+		Source:  sources,
+	}); err != nil {
 		return fmt.Errorf("failed to download video: %w", err)
 	}
 
+	cacheKey := w.videoCacheKey(job, inputPath)
+	if cached := w.cachedVideoResult(job, cacheKey); cached != nil {
+		w.db.UpdateVideoResult(job.JobID, cached)
+		w.db.UpdateVideoStatus(job.JobID, models.JobStatusCompleted)
+		return nil
+	}
+
+	w.setStep(job.JobID, models.CompressionTypeVideo, models.JobStepCompressing)
+	probe, err := w.videoCompressor.Inspect(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to inspect video: %w", err)
+	}
+
+	hashes := w.videoHashes(job.JobID, inputPath, workDir, probe.Duration)
+	if original := w.findDuplicate(job, models.CompressionTypeVideo, hashes); original != nil {
+		result := *original.VideoResult
+		if result.DuplicateOf == "" {
+			result.DuplicateOf = original.JobID
+		}
+		log.Printf("Reusing video result of job %s for duplicate job %s", result.DuplicateOf, job.JobID)
+		w.db.UpdateVideoResult(job.JobID, &result)
+		w.db.UpdateVideoStatus(job.JobID, models.JobStatusCompleted)
+		return nil
+	}
+
 	originalSize, err := w.videoCompressor.GetVideoInfo(inputPath)
 	if err != nil {
 		return fmt.Errorf("failed to get video info: %w", err)
@@ -232,23 +371,35 @@ func (w *Worker) processVideo(ctx context.Context, job *models.Job) error {
 		OriginalSize: originalSize,
 	}
 
+	videoOpts := w.newMediaUpload(job, job.VideoData.Title, "", source)
+
 	if job.VideoData.HLSEnabled && len(job.VideoData.HLSVariants) > 0 {
 		log.Printf("Generating HLS variants for job %s", job.JobID)
-		masterPlaylist, variantURLs, err := w.videoCompressor.GenerateHLS(inputPath, job.VideoData.HLSVariants)
+		masterPlaylist, variantPlaylists, err := w.videoCompressor.GenerateHLS(inputPath, workDir, job.VideoData.HLSVariants)
 		if err != nil {
 			return fmt.Errorf("failed to generate HLS: %w", err)
 		}
+		hlsDir := filepath.Dir(masterPlaylist)
 
-		hlsURL, err := w.storage.UploadFile(masterPlaylist)
+		hlsSize := dirSize(hlsDir)
+		upload := w.startUpload(job.JobID, models.CompressionTypeVideo, hlsSize)
+		opts := videoOpts.labelled("HLS")
+		opts.Progress = upload.file(hlsSize)
+		objects, err := opts.uploadTree(backend, hlsDir, "hls")
 		if err != nil {
-			return fmt.Errorf("failed to upload HLS master playlist: %w", err)
+			return fmt.Errorf("failed to upload HLS output: %w", err)
 		}
 
-		result.HLSPlaylistURL = hlsURL
-		result.HLSVariants = variantURLs
+		master := objects[filepath.Base(masterPlaylist)]
+		result.HLSPlaylistURL = master.URL
+		result.MediaID = master.MediaID
+		result.HLSVariants = make(map[string]string)
+		for variant, playlist := range variantPlaylists {
+			result.HLSVariants[variant] = objects[playlist].URL
+		}
 	} else {
 		log.Printf("Compressing video with quality %s for job %s", job.VideoData.Quality, job.JobID)
-		compressedPath, err := w.videoCompressor.Compress(inputPath, job.VideoData.Quality)
+		compressedPath, err := w.videoCompressor.Compress(inputPath, workDir, job.VideoData.Quality)
 		if err != nil {
 			return fmt.Errorf("failed to compress video: %w", err)
 		}
@@ -257,18 +408,36 @@ func (w *Worker) processVideo(ctx context.Context, job *models.Job) error {
 		result.CompressedSize = compressedSize
 		result.CompressionRatio = float64(originalSize-compressedSize) / float64(originalSize)
 
-		compressedURL, err := w.storage.UploadFile(compressedPath)
-		if err != nil {
-			return fmt.Errorf("failed to upload compressed video: %w", err)
+		upload := w.startUpload(job.JobID, models.CompressionTypeVideo, compressedSize)
+		opts := videoOpts
+		opts.Progress = upload.file(compressedSize)
+		if job.StorageMode == models.StorageModeReplace {
+			replaced, err := opts.replaceAttachment(backend, job, compressedPath, "compressed")
+			if err != nil {
+				return fmt.Errorf("failed to replace attachment: %w", err)
+			}
+			result.CompressedURL = replaced.URL
+			result.MediaID = replaced.MediaID
+			result.ReplacedAttachmentID = replaced.MediaID
+		} else {
+			compressed, err := opts.upload(backend, compressedPath, "compressed")
+			if err != nil {
+				return fmt.Errorf("failed to upload compressed video: %w", err)
+			}
+			result.CompressedURL = compressed.URL
+			result.MediaID = compressed.MediaID
 		}
+	}
 
-		result.CompressedURL = compressedURL
+	if err := w.addPoster(backend, videoOpts.labelled("poster"), inputPath, workDir, result); err != nil {
+		log.Printf("Failed to generate poster for job %s: %v", job.JobID, err)
 	}
 
 	result.ProcessingTime = int(time.Since(startTime).Seconds())
 
 	w.db.UpdateVideoResult(job.JobID, result)
 	w.db.UpdateVideoStatus(job.JobID, models.JobStatusCompleted)
+	w.cacheResult(job.JobID, models.CompressionTypeVideo, cacheKey, result)
 
 	log.Printf("Video processing completed for job %s", job.JobID)
 	return nil
@@ -281,19 +450,64 @@ func (w *Worker) processImage(ctx context.Context, job *models.Job) error {
 
 	w.db.UpdateImageStatus(job.JobID, models.JobStatusProcessing)
 
-	jobDir := filepath.Join(w.config.TempDir, job.JobID)
-	if err := os.MkdirAll(jobDir, 0755); err != nil {
-		return fmt.Errorf("failed to create job directory: %w", err)
+	backend, sources, err := w.destination(job)
+	if err != nil {
+		return err
 	}
-	defer os.RemoveAll(jobDir)
 
-	inputPath := filepath.Join(jobDir, "input_image"+filepath.Ext(job.ImageData.FileURL))
-	log.Printf("Downloading image from %s", job.ImageData.FileURL)
-	if err := w.storage.DownloadFile(job.ImageData.FileURL, inputPath); err != nil {
+	jobDir, _ := w.jobDir(job.JobID)
+	workDir := filepath.Join(jobDir, "image")
+	variantDir := filepath.Join(workDir, "variants")
+	if err := os.MkdirAll(variantDir, 0755); err != nil {
+		return fmt.Errorf("failed to create job directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	inputPath := filepath.Join(workDir, "input_image")
+	w.setStep(job.JobID, models.CompressionTypeImage, models.JobStepDownloading)
+	sourceName := w.sourceName(job.ImageData.FileURL, job.ImageData.UploadID)
+	log.Printf("Downloading image from %s", sourceName)
+	if err := w.fetchSource(job, backend, job.ImageData.FileURL, job.ImageData.UploadID, inputPath, storage.DownloadOptions{
+		MaxSize: w.config.MaxImageFileSize,
+		SHA256:  job.ImageData.SHA256,
+		Retries: w.config.DownloadRetries,
+		Source:  sources,
+	}); err != nil {
 		return fmt.Errorf("failed to download image: %w", err)
 	}
 
-	originalSize, _, err := w.imageCompressor.GetImageInfo(inputPath)
+	cacheKey := w.imageCacheKey(job, inputPath)
+	if cached := w.cachedImageResult(job, cacheKey); cached != nil {
+		w.db.UpdateImageResult(job.JobID, cached)
+		w.db.UpdateImageStatus(job.JobID, models.JobStatusCompleted)
+		return nil
+	}
+
+	w.setStep(job.JobID, models.CompressionTypeImage, models.JobStepCompressing)
+	source, err := w.imageCompressor.Prepare(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	log.Printf("Detected %s input for job %s", source.Format, job.JobID)
+
+	var hashes []uint64
+	if hash, err := w.imageCompressor.PerceptualHash(source.Path); err != nil {
+		log.Printf("Failed to hash image for job %s: %v", job.JobID, err)
+	} else {
+		hashes = []uint64{hash}
+	}
+	if original := w.findDuplicate(job, models.CompressionTypeImage, hashes); original != nil {
+		result := *original.ImageResult
+		if result.DuplicateOf == "" {
+			result.DuplicateOf = original.JobID
+		}
+		log.Printf("Reusing image result of job %s for duplicate job %s", result.DuplicateOf, job.JobID)
+		w.db.UpdateImageResult(job.JobID, &result)
+		w.db.UpdateImageStatus(job.JobID, models.JobStatusCompleted)
+		return nil
+	}
+
+	originalSize, _, err := w.imageCompressor.GetImageInfo(source.OriginalPath)
 	if err != nil {
 		return fmt.Errorf("failed to get image info: %w", err)
 	}
@@ -301,12 +515,27 @@ func (w *Worker) processImage(ctx context.Context, job *models.Job) error {
 	startTime := time.Now()
 
 	variants := job.ImageData.Variants
-	if len(variants) == 0 {
-		variants = []string{"thumbnail", "medium", "large", "original"}
+	if len(variants) == 0 && len(job.ImageData.SrcsetWidths) == 0 {
+		for _, name := range models.DefaultImageVariants {
+			variants = append(variants, models.ImageVariantPresets[name])
+		}
+	}
+
+	var srcsetWidths []int
+	for _, width := range job.ImageData.SrcsetWidths {
+		if source.Width > 0 && width > source.Width {
+			continue
+		}
+		srcsetWidths = append(srcsetWidths, width)
+		variants = append(variants, models.ImageVariantSpec{
+			Name:  srcsetVariantName(width),
+			Width: width,
+			Fit:   models.FitInside,
+		})
 	}
 
 	log.Printf("Generating image variants for job %s: %v", job.JobID, variants)
-	variantPaths, err := w.imageCompressor.CompressWithVariants(inputPath, job.ImageData.Quality, variants)
+	variantOutputs, err := w.imageCompressor.CompressWithVariants(source, variantDir, job.ImageData, variants)
 	if err != nil {
 		return fmt.Errorf("failed to compress image: %w", err)
 	}
@@ -317,25 +546,86 @@ func (w *Worker) processImage(ctx context.Context, job *models.Job) error {
 		Variants:     make(map[string]models.ImageVariant),
 	}
 
-	var totalCompressedSize int64
-	for variantName, variantPath := range variantPaths {
-		size, dimensions, _ := w.imageCompressor.GetImageInfo(variantPath)
+	var uploadSize int64
+	for _, output := range variantOutputs {
+		uploadSize += fileSize(output.Path)
+		for _, formatPath := range output.Formats {
+			uploadSize += fileSize(formatPath)
+		}
+	}
+	upload := w.startUpload(job.JobID, models.CompressionTypeImage, uploadSize)
+	imageOpts := w.newMediaUpload(job, job.ImageData.Title, job.ImageData.AltText, sourceName)
 
-		url, err := w.storage.UploadFile(variantPath)
-		if err != nil {
-			log.Printf("Failed to upload %s variant: %v", variantName, err)
-			continue
+	var totalCompressedSize int64
+	for variantName, output := range variantOutputs {
+		size, dimensions, _ := w.imageCompressor.GetImageInfo(output.Path)
+
+		opts := imageOpts.labelled(variantName)
+		opts.Progress = upload.file(fileSize(output.Path))
+		var uploaded *storage.UploadedObject
+		if job.StorageMode == models.StorageModeReplace && variantName == "original" {
+			uploaded, err = opts.replaceAttachment(backend, job, output.Path, variantName)
+			if err != nil {
+				return fmt.Errorf("failed to replace attachment: %w", err)
+			}
+			result.ReplacedAttachmentID = uploaded.MediaID
+		} else {
+			uploaded, err = opts.upload(backend, output.Path, variantName)
+			if err != nil {
+				log.Printf("Failed to upload %s variant: %v", variantName, err)
+				continue
+			}
 		}
 
-		result.Variants[variantName] = models.ImageVariant{
-			URL:        url,
+		variant := models.ImageVariant{
+			URL:        uploaded.URL,
 			Size:       size,
 			Dimensions: dimensions,
+			Quality:    output.Quality,
+			Score:      output.Score,
+			MediaID:    uploaded.MediaID,
 		}
 
+		for format, formatPath := range output.Formats {
+			opts := imageOpts.labelled(variantName + ", " + string(format))
+			opts.Progress = upload.file(fileSize(formatPath))
+			formatUpload, err := opts.upload(backend, formatPath, variantName)
+			if err != nil {
+				log.Printf("Failed to upload %s variant as %s: %v", variantName, format, err)
+				continue
+			}
+			if variant.Formats == nil {
+				variant.Formats = make(map[models.ImageFormat]string)
+			}
+			variant.Formats[format] = formatUpload.URL
+			if formatUpload.MediaID != 0 {
+				if variant.FormatMediaIDs == nil {
+					variant.FormatMediaIDs = make(map[models.ImageFormat]int)
+				}
+				variant.FormatMediaIDs[format] = formatUpload.MediaID
+			}
+		}
+
+		result.Variants[variantName] = variant
+
 		totalCompressedSize += size
 	}
 
+	result.Srcset, result.SrcsetFormats = buildSrcset(result.Variants, srcsetWidths)
+
+	placeholder, err := w.imageCompressor.Placeholder(source.Path)
+	if err != nil {
+		log.Printf("Failed to compute placeholder for job %s: %v", job.JobID, err)
+	}
+	result.Placeholder = placeholder
+
+	if animation, err := compressor.InspectAnimation(source.OriginalPath); err != nil {
+		log.Printf("Failed to inspect frames for job %s: %v", job.JobID, err)
+	} else if animation != nil {
+		log.Printf("Converting animated image for job %s (%d frames)", job.JobID, animation.Frames())
+		result.Animation = w.convertAnimation(backend, imageOpts, job.JobID, source.OriginalPath, animation, workDir)
+	}
+
 	result.CompressedSize = totalCompressedSize
 	if originalSize > 0 {
 		result.CompressionRatio = float64(originalSize-totalCompressedSize) / float64(originalSize)
@@ -344,7 +634,134 @@ func (w *Worker) processImage(ctx context.Context, job *models.Job) error {
 
 	w.db.UpdateImageResult(job.JobID, result)
 	w.db.UpdateImageStatus(job.JobID, models.JobStatusCompleted)
+	if len(result.Variants) == len(variantOutputs) {
+		w.cacheResult(job.JobID, models.CompressionTypeImage, cacheKey, result)
+	}
 
 	log.Printf("Image processing completed for job %s", job.JobID)
 	return nil
 }
+
+func (w *Worker) addPoster(backend storage.Backend, opts mediaUpload, inputPath, workDir string, result *models.VideoResult) error {
+	posterPath, err := w.videoCompressor.ExtractPoster(inputPath, workDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(posterPath)
+
+	placeholder, err := w.imageCompressor.Placeholder(posterPath)
+	if err != nil {
+		return err
+	}
+
+	poster, err := opts.upload(backend, posterPath, "poster")
+	if err != nil {
+		return fmt.Errorf("failed to upload poster: %w", err)
+	}
+
+	result.PosterURL = poster.URL
+	result.PosterMediaID = poster.MediaID
+	result.Placeholder = placeholder
+	return nil
+}
+
+var animationFormats = []struct {
+	format   string
+	mimeType string
+}{
+	{"mp4", "video/mp4"},
+	{"webm", "video/webm"},
+	{"webp", "image/webp"},
+}
+
+func (w *Worker) convertAnimation(backend storage.Backend, opts mediaUpload, jobID, inputPath string, animation *compressor.Animation, workDir string) map[string]models.AnimatedOutput {
+	if err := w.videoCompressor.CheckAnimation(animation); err != nil {
+		log.Printf("Skipping animation for job %s: %v", jobID, err)
+		return nil
+	}
+
+	// ffmpeg cannot decode animated WebP, so its frames go through the
+	// image backend.
+	if animation.Format == compressor.SourceWebP {
+		framesDir := filepath.Join(workDir, "frames")
+		if err := os.MkdirAll(framesDir, 0755); err != nil {
+			log.Printf("Failed to create frames directory for job %s: %v", jobID, err)
+			return nil
+		}
+		defer os.RemoveAll(framesDir)
+
+		list, err := w.imageCompressor.ExtractFrames(inputPath, framesDir, animation)
+		if err != nil {
+			log.Printf("Failed to decode animation for job %s: %v", jobID, err)
+			return nil
+		}
+		inputPath = list
+	}
+
+	outputs := make(map[string]models.AnimatedOutput)
+
+	for _, f := range animationFormats {
+		outputPath, err := w.videoCompressor.ConvertAnimation(inputPath, workDir, f.format)
+		if err != nil {
+			log.Printf("Failed to convert animation to %s for job %s: %v", f.format, jobID, err)
+			continue
+		}
+
+		size, _ := w.videoCompressor.GetVideoInfo(outputPath)
+		uploaded, err := opts.labelled("animated "+f.format).upload(backend, outputPath, "animated")
+		os.Remove(outputPath)
+		if err != nil {
+			log.Printf("Failed to upload %s animation for job %s: %v", f.format, jobID, err)
+			continue
+		}
+
+		outputs[f.format] = models.AnimatedOutput{
+			URL:      uploaded.URL,
+			Size:     size,
+			MimeType: f.mimeType,
+			MediaID:  uploaded.MediaID,
+		}
+	}
+
+	if len(outputs) == 0 {
+		return nil
+	}
+	return outputs
+}
+
+func srcsetVariantName(width int) string {
+	return fmt.Sprintf("srcset-%d", width)
+}
+
+func buildSrcset(variants map[string]models.ImageVariant, widths []int) (string, map[models.ImageFormat]string) {
+	var srcset []string
+	formats := make(map[models.ImageFormat][]string)
+
+	for _, width := range widths {
+		variant, ok := variants[srcsetVariantName(width)]
+		if !ok {
+			continue
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", variant.URL, width))
+		for format, url := range variant.Formats {
+			formats[format] = append(formats[format], fmt.Sprintf("%s %dw", url, width))
+		}
+	}
+
+	if len(formats) == 0 {
+		return strings.Join(srcset, ", "), nil
+	}
+
+	formatSrcsets := make(map[models.ImageFormat]string)
+	for format, entries := range formats {
+		formatSrcsets[format] = strings.Join(entries, ", ")
+	}
+	return strings.Join(srcset, ", "), formatSrcsets
+}
+
+// isPermanent reports whether err comes from the input itself, so running
+// the job again would fail the same way.
+func isPermanent(err error) bool {
+	return errors.Is(err, models.ErrLimitExceeded) || errors.Is(err, models.ErrChecksumMismatch) ||
+		errors.Is(err, models.ErrSourceNotAllowed)
+}
@@ -16,22 +16,71 @@ type Config struct {
 	LogLevel                string
 	MaxVideoFileSize        int64
 	MaxImageFileSize        int64
+	MaxImagePixels          int64
+	MaxImageDimension       int
+	MaxVideoDuration        int
+	MaxVideoDimension       int
+	MaxVideoStreams         int
+	MaxAnimationFrames      int
 	TempDir                 string
+	UploadDir               string
+	TempMinFreeBytes        int64
+	TempSpaceMultiplier     int
+	TempOrphanTTL           int
+	UploadTTL               int
 	RedisURL                string
 	DatabaseURL             string
 	MaxConcurrentJobs       int
 	JobTimeout              int
 	QueueCheckInterval      int
 	FFmpegPath              string
+	FFprobePath             string
 	ImageMagickPath         string
+	IdentifyPath            string
+	ImageBackend            string
+	SRGBProfilePath         string
 	WordPressAPIURL         string
 	WordPressUsername       string
 	WordPressAppPassword    string
+	StorageBackend          string
+	LocalStoragePath        string
+	LocalStorageBaseURL     string
+	S3Endpoint              string
+	S3Region                string
+	S3Bucket                string
+	S3AccessKey             string
+	S3SecretKey             string
+	S3PublicURL             string
+	S3PathStyle             bool
+	SFTPAddr                string
+	SFTPUsername            string
+	SFTPPassword            string
+	SFTPPrivateKeyFile      string
+	SFTPHostKey             string
+	SFTPRoot                string
+	SFTPPublicURL           string
+	WebDAVURL               string
+	WebDAVUsername          string
+	WebDAVPassword          string
+	WebDAVPublicURL         string
+	FileSigningKey          string
+	TenantEncryptionKey     string
+	FileURLTTL              int
+	FileBaseURL             string
+	URLRewrites             string
+	SourceAllowedSchemes    []string
+	SourceAllowedHosts      []string
+	SourceAllowedNetworks   []string
 	RateLimitPerMinute      int
 	RateLimitMaxConcurrent  int
 	RateLimitMaxJobsPerDay  int
 	MaxRetries              int
 	RetryBackoffSeconds     []int
+	DownloadRetries         int
+	UploadRetries           int
+	StorageBreakerThreshold int
+	StorageBreakerCooldown  int
+	ResultCacheTTL          int
 }
 
 func Load() *Config {
@@ -44,22 +93,71 @@ func Load() *Config {
 		LogLevel:                getEnv("LOG_LEVEL", "info"),
 		MaxVideoFileSize:        getEnvAsInt64("MAX_VIDEO_FILE_SIZE", 5000000000),
 		MaxImageFileSize:        getEnvAsInt64("MAX_IMAGE_FILE_SIZE", 500000000),
+		MaxImagePixels:          getEnvAsInt64("MAX_IMAGE_PIXELS", 100000000),
+		MaxImageDimension:       getEnvAsInt("MAX_IMAGE_DIMENSION", 20000),
+		MaxVideoDuration:        getEnvAsInt("MAX_VIDEO_DURATION", 7200),
+		MaxVideoDimension:       getEnvAsInt("MAX_VIDEO_DIMENSION", 7680),
+		MaxVideoStreams:         getEnvAsInt("MAX_VIDEO_STREAMS", 8),
+		MaxAnimationFrames:      getEnvAsInt("MAX_ANIMATION_FRAMES", 1000),
 		TempDir:                 getEnv("TEMP_DIR", "/tmp/compression"),
+		UploadDir:               getEnv("UPLOAD_DIR", "/tmp/compression/uploads"),
+		TempMinFreeBytes:        getEnvAsInt64("TEMP_MIN_FREE_BYTES", 1073741824),
+		TempSpaceMultiplier:     getEnvAsInt("TEMP_SPACE_MULTIPLIER", 3),
+		TempOrphanTTL:           getEnvAsInt("TEMP_ORPHAN_TTL", 21600),
+		UploadTTL:               getEnvAsInt("UPLOAD_TTL", 86400),
 		RedisURL:                getEnv("REDIS_URL", "redis://localhost:6379"),
 		DatabaseURL:             getEnv("DATABASE_URL", ""),
 		MaxConcurrentJobs:       getEnvAsInt("MAX_CONCURRENT_JOBS", 5),
 		JobTimeout:              getEnvAsInt("JOB_TIMEOUT", 3600),
 		QueueCheckInterval:      getEnvAsInt("QUEUE_CHECK_INTERVAL", 5),
 		FFmpegPath:              getEnv("FFMPEG_PATH", "/usr/bin/ffmpeg"),
+		FFprobePath:             getEnv("FFPROBE_PATH", "/usr/bin/ffprobe"),
 		ImageMagickPath:         getEnv("IMAGEMAGICK_PATH", "/usr/bin/convert"),
+		IdentifyPath:            getEnv("IDENTIFY_PATH", "/usr/bin/identify"),
+		ImageBackend:            getEnv("IMAGE_BACKEND", "auto"),
+		SRGBProfilePath:         getEnv("SRGB_PROFILE_PATH", "/usr/share/color/icc/sRGB.icc"),
 		WordPressAPIURL:         getEnv("WORDPRESS_API_URL", ""),
 		WordPressUsername:       getEnv("WORDPRESS_USERNAME", ""),
 		WordPressAppPassword:    getEnv("WORDPRESS_APP_PASSWORD", ""),
+		StorageBackend:          getEnv("STORAGE_BACKEND", "wordpress"),
+		LocalStoragePath:        getEnv("LOCAL_STORAGE_PATH", ""),
+		LocalStorageBaseURL:     getEnv("LOCAL_STORAGE_BASE_URL", ""),
+		S3Endpoint:              getEnv("S3_ENDPOINT", ""),
+		S3Region:                getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:                getEnv("S3_BUCKET", ""),
+		S3AccessKey:             getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:             getEnv("S3_SECRET_KEY", ""),
+		S3PublicURL:             getEnv("S3_PUBLIC_URL", ""),
+		S3PathStyle:             getEnvAsBool("S3_PATH_STYLE", false),
+		SFTPAddr:                getEnv("SFTP_ADDR", ""),
+		SFTPUsername:            getEnv("SFTP_USERNAME", ""),
+		SFTPPassword:            getEnv("SFTP_PASSWORD", ""),
+		SFTPPrivateKeyFile:      getEnv("SFTP_PRIVATE_KEY_FILE", ""),
+		SFTPHostKey:             getEnv("SFTP_HOST_KEY", ""),
+		SFTPRoot:                getEnv("SFTP_ROOT", ""),
+		SFTPPublicURL:           getEnv("SFTP_PUBLIC_URL", ""),
+		WebDAVURL:               getEnv("WEBDAV_URL", ""),
+		WebDAVUsername:          getEnv("WEBDAV_USERNAME", ""),
+		WebDAVPassword:          getEnv("WEBDAV_PASSWORD", ""),
+		WebDAVPublicURL:         getEnv("WEBDAV_PUBLIC_URL", ""),
+		FileSigningKey:          getEnv("FILE_SIGNING_KEY", ""),
+		TenantEncryptionKey:     getEnv("TENANT_ENCRYPTION_KEY", ""),
+		FileURLTTL:              getEnvAsInt("FILE_URL_TTL", 3600),
+		FileBaseURL:             getEnv("FILE_BASE_URL", ""),
+		URLRewrites:             getEnv("URL_REWRITES", ""),
+		SourceAllowedSchemes:    getEnvAsSlice("SOURCE_ALLOWED_SCHEMES", []string{"https", "http"}, ","),
+		SourceAllowedHosts:      getEnvAsSlice("SOURCE_ALLOWED_HOSTS", []string{}, ","),
+		SourceAllowedNetworks:   getEnvAsSlice("SOURCE_ALLOWED_NETWORKS", []string{}, ","),
 		RateLimitPerMinute:      getEnvAsInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 10),
 		RateLimitMaxConcurrent:  getEnvAsInt("RATE_LIMIT_MAX_CONCURRENT", 100),
 		RateLimitMaxJobsPerDay:  getEnvAsInt("RATE_LIMIT_MAX_JOBS_PER_DAY", 1000),
 		MaxRetries:              getEnvAsInt("MAX_RETRIES", 3),
 		RetryBackoffSeconds:     getEnvAsIntSlice("RETRY_BACKOFF_SECONDS", []int{60, 300, 900}, ","),
+		DownloadRetries:         getEnvAsInt("DOWNLOAD_RETRIES", 5),
+		UploadRetries:           getEnvAsInt("UPLOAD_RETRIES", 3),
+		StorageBreakerThreshold: getEnvAsInt("STORAGE_BREAKER_THRESHOLD", 5),
+		StorageBreakerCooldown:  getEnvAsInt("STORAGE_BREAKER_COOLDOWN", 60),
+		ResultCacheTTL:          getEnvAsInt("RESULT_CACHE_TTL", 2592000),
 	}
 }
 
@@ -86,6 +184,14 @@ func getEnvAsInt64(key string, defaultVal int64) int64 {
 	return defaultVal
 }
 
+func getEnvAsBool(key string, defaultVal bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultVal
+}
+
 func getEnvAsSlice(key string, defaultVal []string, sep string) []string {
 	valueStr := getEnv(key, "")
 	if valueStr == "" {
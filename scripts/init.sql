@@ -1,6 +1,7 @@
 CREATE TABLE IF NOT EXISTS jobs (
     id SERIAL PRIMARY KEY,
     job_id VARCHAR(255) UNIQUE NOT NULL,
+    tenant_id VARCHAR(64),
     post_id INTEGER NOT NULL,
     user_id INTEGER,
     compression_type VARCHAR(50) NOT NULL,
@@ -9,15 +10,41 @@ CREATE TABLE IF NOT EXISTS jobs (
     video_quality VARCHAR(50),
     video_hls_enabled BOOLEAN DEFAULT FALSE,
     video_hls_variants TEXT[],
+    video_title TEXT,
+    video_sha256 VARCHAR(64),
+    video_upload_id VARCHAR(64),
     
     image_file_url TEXT,
     image_quality VARCHAR(50),
     image_variants TEXT[],
+    image_formats TEXT[],
+    image_variant_specs JSONB,
+    image_srcset_widths INTEGER[],
+    image_focal_x REAL,
+    image_focal_y REAL,
+    image_quality_mode VARCHAR(20),
+    image_target_score REAL,
+    image_title TEXT,
+    image_alt_text TEXT,
+    image_sha256 VARCHAR(64),
+    image_upload_id VARCHAR(64),
+    
+    reuse_duplicate BOOLEAN DEFAULT FALSE,
+    duplicate_max_distance INTEGER,
+    storage_backend VARCHAR(50),
+    storage_mode VARCHAR(20),
+    attachment_id INTEGER,
+    output_naming VARCHAR(255),
+    source_size BIGINT,
     
     priority INTEGER DEFAULT 5,
     status VARCHAR(50) NOT NULL DEFAULT 'pending',
     video_status VARCHAR(50),
     image_status VARCHAR(50),
+    video_step VARCHAR(50),
+    image_step VARCHAR(50),
+    video_upload_progress INTEGER,
+    image_upload_progress INTEGER,
     
     video_result JSONB,
     image_result JSONB,
@@ -59,6 +86,64 @@ CREATE TABLE IF NOT EXISTS queue_stats (
 
 CREATE INDEX idx_queue_stats_date ON queue_stats(date);
 
+-- 64-bit pHash per image, or per sampled keyframe for videos
+CREATE TABLE IF NOT EXISTS media_hashes (
+    id SERIAL PRIMARY KEY,
+    job_id VARCHAR(255) NOT NULL REFERENCES jobs(job_id) ON DELETE CASCADE,
+    media_type VARCHAR(10) NOT NULL,
+    frame_index INTEGER NOT NULL DEFAULT 0,
+    phash BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (job_id, media_type, frame_index)
+);
+
+CREATE INDEX idx_media_hashes_media_type ON media_hashes(media_type, frame_index);
+
+-- Finished results keyed by the SHA-256 of the input bytes and of the canonical encoding parameters
+CREATE TABLE IF NOT EXISTS result_cache (
+    media_type VARCHAR(10) NOT NULL,
+    content_hash CHAR(64) NOT NULL,
+    params_hash CHAR(64) NOT NULL,
+    job_id VARCHAR(255) NOT NULL,
+    result JSONB NOT NULL,
+    hit_count INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_hit_at TIMESTAMP,
+    PRIMARY KEY (media_type, content_hash, params_hash)
+);
+
+CREATE INDEX idx_result_cache_created_at ON result_cache(created_at);
+
+-- Source files pushed to the service with multipart or tus uploads
+CREATE TABLE IF NOT EXISTS uploads (
+    upload_id VARCHAR(64) PRIMARY KEY,
+    tenant_id VARCHAR(64),
+    filename TEXT NOT NULL DEFAULT '',
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    sha256 CHAR(64),
+    status VARCHAR(20) NOT NULL DEFAULT 'uploading',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_uploads_expires_at ON uploads(expires_at);
+
+-- storage holds the tenant's destination and credentials, sealed with
+-- AES-256-GCM under TENANT_ENCRYPTION_KEY; url_rewrites is sealed the same way
+CREATE TABLE IF NOT EXISTS tenants (
+    tenant_id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    api_key_hash CHAR(64) UNIQUE NOT NULL,
+    storage BYTEA,
+    source_hosts TEXT[],
+    url_rewrites BYTEA,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
 BEGIN
@@ -72,3 +157,39 @@ CREATE TRIGGER update_jobs_updated_at BEFORE UPDATE ON jobs
 
 CREATE TRIGGER update_queue_stats_updated_at BEFORE UPDATE ON queue_stats
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_tenants_updated_at BEFORE UPDATE ON tenants
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Columns added after the initial release, for databases created by an older init.sql
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_formats TEXT[];
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_variant_specs JSONB;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_srcset_widths INTEGER[];
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_focal_x REAL;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_focal_y REAL;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_quality_mode VARCHAR(20);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_target_score REAL;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS reuse_duplicate BOOLEAN DEFAULT FALSE;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS duplicate_max_distance INTEGER;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS storage_backend VARCHAR(50);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS video_step VARCHAR(50);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_step VARCHAR(50);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS video_upload_progress INTEGER;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_upload_progress INTEGER;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS video_title TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_title TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_alt_text TEXT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS storage_mode VARCHAR(20);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS attachment_id INTEGER;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS video_sha256 VARCHAR(64);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_sha256 VARCHAR(64);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS video_upload_id VARCHAR(64);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS image_upload_id VARCHAR(64);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS output_naming VARCHAR(255);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS source_size BIGINT;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS url_rewrites BYTEA;
+
+-- Indexes on those columns, created once the columns exist
+CREATE INDEX IF NOT EXISTS idx_jobs_tenant_id ON jobs(tenant_id);
@@ -35,6 +35,9 @@ class VideoCompressorAPI {
         // Settings
         add_action('admin_init', [$this, 'register_settings']);
         
+        // REST routes used by the compression service
+        add_action('rest_api_init', [$this, 'register_rest_routes']);
+        
         // Cron job for checking status
         add_action('compressor_check_pending_jobs', [$this, 'check_pending_jobs']);
         if (!wp_next_scheduled('compressor_check_pending_jobs')) {
@@ -206,6 +209,7 @@ class VideoCompressorAPI {
                     if (isset($result['image_result'])) {
                         update_post_meta($item->post_id, '_compressed_image_data', $result['image_result']);
                     }
+                    $this->save_placeholder_meta($item->post_id, $result);
                 }
             } elseif ($status && $status['overall_status'] === 'failed') {
                 update_post_meta($item->post_id, '_compression_status', 'failed');
@@ -213,6 +217,206 @@ class VideoCompressorAPI {
         }
     }
     
+    /**
+     * Store placeholder data (BlurHash, LQIP, colours) as attachment meta
+     */
+    private function save_placeholder_meta($post_id, $result) {
+        $placeholder = null;
+        if (isset($result['image_result']['placeholder'])) {
+            $placeholder = $result['image_result']['placeholder'];
+        } elseif (isset($result['video_result']['placeholder'])) {
+            $placeholder = $result['video_result']['placeholder'];
+        }
+        
+        if (isset($result['video_result']['poster_url'])) {
+            update_post_meta($post_id, '_compressed_poster_url', $result['video_result']['poster_url']);
+        }
+        
+        if (!$placeholder) {
+            return;
+        }
+        
+        update_post_meta($post_id, '_compression_blurhash', $placeholder['blurhash']);
+        update_post_meta($post_id, '_compression_lqip', $placeholder['lqip']);
+        update_post_meta($post_id, '_compression_dominant_color', $placeholder['dominant_color']);
+        update_post_meta($post_id, '_compression_average_color', $placeholder['average_color']);
+    }
+    
+    /**
+     * Register the routes the service uses to replace attachments in place
+     */
+    public function register_rest_routes() {
+        $args = [
+            'id' => [
+                'validate_callback' => function($value) {
+                    return is_numeric($value);
+                }
+            ]
+        ];
+        
+        register_rest_route('video-compressor/v1', '/attachments/(?P<id>\d+)/replace', [
+            'methods' => 'POST',
+            'callback' => [$this, 'rest_replace_attachment'],
+            'permission_callback' => [$this, 'can_edit_attachment'],
+            'args' => $args
+        ]);
+        
+        register_rest_route('video-compressor/v1', '/attachments/(?P<id>\d+)/revert', [
+            'methods' => 'POST',
+            'callback' => [$this, 'rest_revert_attachment'],
+            'permission_callback' => [$this, 'can_edit_attachment'],
+            'args' => $args
+        ]);
+    }
+    
+    /**
+     * Only users who may edit the attachment can change its file
+     */
+    public function can_edit_attachment($request) {
+        return current_user_can('edit_post', (int) $request['id']);
+    }
+    
+    /**
+     * Swap the attachment's file for the uploaded one, keeping the old file as a backup
+     */
+    public function rest_replace_attachment($request) {
+        $attachment_id = (int) $request['id'];
+        $job_id = sanitize_text_field($request->get_param('job_id'));
+        
+        if (get_post_type($attachment_id) !== 'attachment') {
+            return new WP_Error('vc_not_found', 'Attachment not found', ['status' => 404]);
+        }
+        
+        $body = $request->get_body();
+        if ($body === '') {
+            return new WP_Error('vc_empty_body', 'No file was sent', ['status' => 400]);
+        }
+        
+        $filename = '';
+        $disposition = $request->get_header('content_disposition');
+        if ($disposition && preg_match('/filename="?([^";]+)"?/i', $disposition, $matches)) {
+            $filename = sanitize_file_name($matches[1]);
+        }
+        if ($filename === '') {
+            return new WP_Error('vc_missing_filename', 'Content-Disposition must name the file', ['status' => 400]);
+        }
+        
+        $filetype = wp_check_filetype($filename);
+        if (!$filetype['type']) {
+            return new WP_Error('vc_invalid_type', 'File type is not allowed', ['status' => 400]);
+        }
+        
+        $current_file = get_attached_file($attachment_id);
+        $dir = dirname($current_file);
+        $new_file = $dir . '/' . wp_unique_filename($dir, $filename);
+        
+        if (file_put_contents($new_file, $body) === false) {
+            return new WP_Error('vc_write_failed', 'Failed to write file', ['status' => 500]);
+        }
+        
+        $original_file = function_exists('wp_get_original_image_path') ? wp_get_original_image_path($attachment_id) : false;
+        
+        $backups = get_post_meta($attachment_id, '_vc_backups', true);
+        if (!is_array($backups)) {
+            $backups = [];
+        }
+        $backups[] = [
+            'job_id' => $job_id,
+            'file' => $original_file ? $original_file : $current_file,
+            'mime_type' => get_post_mime_type($attachment_id),
+            'metadata' => wp_get_attachment_metadata($attachment_id),
+            'replaced_at' => current_time('mysql')
+        ];
+        update_post_meta($attachment_id, '_vc_backups', $backups);
+        
+        $this->delete_intermediate_sizes($attachment_id);
+        $this->switch_attachment_file($attachment_id, $new_file, $filetype['type']);
+        
+        return rest_ensure_response($this->attachment_response($attachment_id));
+    }
+    
+    /**
+     * Restore the file an attachment had before the given job replaced it
+     */
+    public function rest_revert_attachment($request) {
+        $attachment_id = (int) $request['id'];
+        $job_id = sanitize_text_field($request->get_param('job_id'));
+        
+        $backups = get_post_meta($attachment_id, '_vc_backups', true);
+        if (!is_array($backups) || empty($backups)) {
+            return new WP_Error('vc_no_backup', 'Attachment has no backup to restore', ['status' => 404]);
+        }
+        
+        // Only the latest replacement can be undone, older backups are
+        // restored in order.
+        $backup = end($backups);
+        if ($job_id && $backup['job_id'] !== $job_id) {
+            return new WP_Error('vc_not_latest', 'Attachment was replaced again by a later job', ['status' => 409]);
+        }
+        
+        if (!file_exists($backup['file'])) {
+            return new WP_Error('vc_backup_missing', 'Backup file no longer exists', ['status' => 410]);
+        }
+        
+        $current_file = get_attached_file($attachment_id);
+        $this->delete_intermediate_sizes($attachment_id);
+        if ($current_file && $current_file !== $backup['file'] && file_exists($current_file)) {
+            wp_delete_file($current_file);
+        }
+        
+        $this->switch_attachment_file($attachment_id, $backup['file'], $backup['mime_type']);
+        
+        array_pop($backups);
+        if (empty($backups)) {
+            delete_post_meta($attachment_id, '_vc_backups');
+        } else {
+            update_post_meta($attachment_id, '_vc_backups', $backups);
+        }
+        
+        return rest_ensure_response($this->attachment_response($attachment_id));
+    }
+    
+    /**
+     * Point an attachment at a new file and regenerate its sizes
+     */
+    private function switch_attachment_file($attachment_id, $file, $mime_type) {
+        require_once ABSPATH . 'wp-admin/includes/image.php';
+        
+        update_attached_file($attachment_id, $file);
+        wp_update_post([
+            'ID' => $attachment_id,
+            'post_mime_type' => $mime_type
+        ]);
+        
+        $metadata = wp_generate_attachment_metadata($attachment_id, $file);
+        wp_update_attachment_metadata($attachment_id, $metadata);
+    }
+    
+    /**
+     * Remove the generated sizes of an attachment's current file
+     */
+    private function delete_intermediate_sizes($attachment_id) {
+        $metadata = wp_get_attachment_metadata($attachment_id);
+        if (empty($metadata['sizes'])) {
+            return;
+        }
+        
+        $dir = dirname(get_attached_file($attachment_id));
+        foreach ($metadata['sizes'] as $size) {
+            wp_delete_file($dir . '/' . $size['file']);
+        }
+    }
+    
+    /**
+     * Response in the shape of the core media endpoint
+     */
+    private function attachment_response($attachment_id) {
+        return [
+            'id' => $attachment_id,
+            'source_url' => wp_get_attachment_url($attachment_id)
+        ];
+    }
+    
     /**
      * Add admin menu
      */
@@ -441,6 +645,9 @@ class VideoCompressorAPI {
                 if ($result && isset($result['video_result']['compressed_url'])) {
                     update_post_meta($post_id, '_compressed_video_url', $result['video_result']['compressed_url']);
                 }
+                if ($result) {
+                    $this->save_placeholder_meta($post_id, $result);
+                }
             }
             
             wp_send_json_success($status);